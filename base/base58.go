@@ -23,9 +23,9 @@ func Base58Encode(input []byte) []byte {
 		result = append(result, b58Alphabet[mod.Int64()])
 	}
 
-	// Append bitcoin pubkey hash leading symbol
+	// Append bitcoin pubkey hash leading symbol, one for every leading zero byte
 	// https://en.bitcoin.it/wiki/Base58Check_encoding#Version_bytes
-	if input[0] == 0x00 {
+	for i := 0; i < len(input) && input[i] == 0x00; i++ {
 		result = append(result, b58Alphabet[0])
 	}
 
@@ -46,7 +46,7 @@ func Base58Decode(input []byte) []byte {
 
 	decoded := result.Bytes()
 
-	if input[0] == b58Alphabet[0] {
+	for i := 0; i < len(input) && input[i] == b58Alphabet[0]; i++ {
 		decoded = append([]byte{0x00}, decoded...)
 	}
 
@@ -2,6 +2,7 @@ package base
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"strings"
@@ -15,40 +16,76 @@ type Block struct {
 	PrevBlockHash []byte         // the hash of the previous block
 	Hash          []byte         // the hash of the block
 	Nonce         int            // the nonce of the block
+	TargetBits    int            // the difficulty the block was mined with
+	Algorithm     string         // the proof-of-work algorithm of the chain
 }
 
 // NewBlock creates and returns Block
-func NewBlock(transactions []*Transaction, prevBlockHash []byte) *Block {
-	block := &Block{Timestamp: time.Now().Unix(), Transactions: transactions, PrevBlockHash: prevBlockHash}
-	block.Mine(nil, 0, 1) // will set hash and nonce
+func NewBlock(transactions []*Transaction, prevBlockHash []byte, targetBits int, algorithm string) *Block {
+	block := &Block{Timestamp: time.Now().Unix(), Transactions: transactions, PrevBlockHash: prevBlockHash, TargetBits: targetBits, Algorithm: algorithm}
+	// will set hash and nonce
+	if _, _, err := block.Mine(context.Background(), 0, 1); err != nil {
+		panic(err.Error())
+	}
 	return block
 }
 
 // NewGenesisBlock creates and returns genesis Block
-func NewGenesisBlock(coinbase *Transaction) *Block {
-	return NewBlock([]*Transaction{coinbase}, []byte{})
+func NewGenesisBlock(coinbase *Transaction, targetBits int, algorithm string) *Block {
+	return NewBlock([]*Transaction{coinbase}, []byte{}, targetBits, algorithm)
+}
+
+// Mine calculates and sets the block hash and nonce, as the miner id of
+// nSlaves sharing the nonce space with nRoutines goroutines each.
+// See Miner.Mine for how the nonce space is searched.
+func (b *Block) Mine(ctx context.Context, id, nSlaves int) (int, []byte, error) {
+	if _, err := b.MineWithStats(ctx, id, nSlaves); err != nil {
+		return 0, nil, err
+	}
+	return b.Nonce, b.Hash, nil
+}
+
+// MineWithStats mines the block like Mine and reports the number of hashes
+// computed and the time it took, also when mining fails
+func (b *Block) MineWithStats(ctx context.Context, id, nSlaves int) (MiningStats, error) {
+	return Miner{ID: id, NSlaves: nSlaves}.Mine(ctx, b)
 }
 
-// Mine calculates and sets the block hash and nonce.
-func (b *Block) Mine(stopChan chan bool, id, nSlaves int) {
-	// TODO(student)
-	pow := NewProofOfWork(b)
-	notifyChan := make(chan NonceHash)
-	for start := id; start < nRoutines*nSlaves; start += nSlaves {
-		go pow.Run(start, nRoutines*nSlaves, notifyChan)
+// roll changes the block header so that the nonce space can be searched
+// again, moving the timestamp to the current time when it has advanced and
+// otherwise incrementing the extra nonce of the coinbase transaction
+func (b *Block) roll() bool {
+	if now := time.Now().Unix(); now > b.Timestamp {
+		b.Timestamp = now
+		return true
+	}
+
+	if len(b.Transactions) == 0 {
+		return false
 	}
-	select {
-	case <-stopChan:
-		notifyChan <- NonceHash{}
-	case nh := <-notifyChan:
-		b.Nonce = nh.Nonce
-		b.Hash = nh.Hash
+	coinbase := b.Transactions[0]
+	rolled, err := coinbase.SetExtraNonce(coinbase.ExtraNonce() + 1)
+	if err != nil {
+		return false
 	}
-	close(notifyChan)
+	b.Transactions = append([]*Transaction{rolled}, b.Transactions[1:]...)
+	return true
 }
 
-// HashTransactions returns a hash of the transactions in the block
+// HashTransactions returns a hash of the transactions in the block,
+// the root of the Merkle tree of their IDs
 func (b *Block) HashTransactions() []byte {
+	var data [][]byte
+	for _, tx := range b.Transactions {
+		data = append(data, tx.SerializeNoWitness())
+	}
+	merkleTree := NewMerkleTree(data)
+	return merkleTree.RootNode.Hash
+}
+
+// HashWitnesses returns a hash of the transactions in the block including
+// their witness, committed to by the header next to HashTransactions
+func (b *Block) HashWitnesses() []byte {
 	var data [][]byte
 	for _, tx := range b.Transactions {
 		data = append(data, tx.Serialize())
@@ -57,6 +94,23 @@ func (b *Block) HashTransactions() []byte {
 	return merkleTree.RootNode.Hash
 }
 
+// Serialize returns the binary encoding of a Block
+func (b *Block) Serialize() []byte {
+	e := &encoder{buf: make([]byte, 0, b.Size())}
+	b.encode(e)
+	return e.buf
+}
+
+// DeserializeBlock decodes a serialized Block
+func DeserializeBlock(data []byte) (*Block, error) {
+	d := &decoder{data: data}
+	block := decodeBlock(d)
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
 // FindTransaction finds a transaction by its ID
 func (b *Block) FindTransaction(ID []byte) (*Transaction, error) {
 	for _, tx := range b.Transactions {
@@ -72,6 +126,7 @@ func (b *Block) String() string {
 
 	lines = append(lines, fmt.Sprintf("============ Block %x ============", b.Hash))
 	lines = append(lines, fmt.Sprintf("Prev. hash: %x", b.PrevBlockHash))
+	lines = append(lines, fmt.Sprintf("Target bits: %d (%s)", b.TargetBits, b.Algorithm))
 	lines = append(lines, fmt.Sprintf("Timestamp: %v\n", time.Unix(b.Timestamp, 0)))
 	for _, tx := range b.Transactions {
 		lines = append(lines, fmt.Sprintf("%v\n", tx))
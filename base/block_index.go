@@ -0,0 +1,339 @@
+package base
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// ErrReorgTooDeep is returned when a branch with more work forks from the
+// best chain below the blocks whose changes the UTXO database can revert
+var ErrReorgTooDeep = errors.New("reorganization deeper than the undo records")
+
+// blockNode keeps in memory the header fields of a stored block,
+// linking every known block to its parent to form a tree
+type blockNode struct {
+	hash       []byte
+	parent     *blockNode
+	height     int
+	timestamp  int64
+	targetBits int
+	work       *big.Int // cumulative work of the branch ending at this block
+	children   int
+	invalid    bool // set when its transactions fail validation
+}
+
+func newBlockNode(block *Block, parent *blockNode) *blockNode {
+	node := &blockNode{
+		hash:       block.Hash,
+		parent:     parent,
+		timestamp:  block.Timestamp,
+		targetBits: block.TargetBits,
+		work:       blockWork(block.TargetBits),
+	}
+	if parent != nil {
+		node.height = parent.height + 1
+		node.work.Add(node.work, parent.work)
+		parent.children++
+	}
+	return node
+}
+
+// blockWork returns the expected number of hashes needed to mine
+// a block with the given difficulty, 2 ** targetBits
+func blockWork(targetBits int) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(targetBits))
+}
+
+// ancestor returns the block of the node's branch at the given height
+func (n *blockNode) ancestor(height int) *blockNode {
+	if height < 0 || height > n.height {
+		return nil
+	}
+	node := n
+	for node.height > height {
+		node = node.parent
+	}
+	return node
+}
+
+// ReorgEvent describes a switch of the best chain to another branch
+type ReorgEvent struct {
+	OldTip       []byte   // the tip before the reorganization
+	NewTip       []byte   // the tip after the reorganization
+	Fork         []byte   // the last block shared by both branches
+	Disconnected []*Block // blocks removed from the chain, from the old tip down
+	Connected    []*Block // blocks added to the chain, from the fork up
+}
+
+// OnReorg registers a function called after every reorganization
+func (bc *Blockchain) OnReorg(fn func(ReorgEvent)) {
+	bc.reorgHandlers = append(bc.reorgHandlers, fn)
+}
+
+// OnConnect registers a function called after every block that becomes
+// the tip of the chain. The blocks connected by a reorganization are
+// notified once it succeeds, before the functions of OnReorg are called.
+func (bc *Blockchain) OnConnect(fn func(*Block)) {
+	bc.connectHandlers = append(bc.connectHandlers, fn)
+}
+
+// IsMainChain checks if the block of the given hash is part of the best chain
+func (bc Blockchain) IsMainChain(hash []byte) bool {
+	node, ok := bc.index[hex.EncodeToString(hash)]
+	return ok && node.height < len(bc.nodes) && bc.nodes[node.height] == node
+}
+
+// SideBlocks returns the known blocks that are not part of the best chain
+func (bc Blockchain) SideBlocks() [][]byte {
+	var hashes [][]byte
+	for _, node := range bc.index {
+		if !bc.IsMainChain(node.hash) {
+			hashes = append(hashes, node.hash)
+		}
+	}
+	return hashes
+}
+
+// ProcessBlock validates a block mined elsewhere and adds it to the block
+// tree. The chain switches to the block's branch when it has more work than
+// the current one, rolling the UTXO set back to the fork and forward again.
+func (bc *Blockchain) ProcessBlock(block *Block) error {
+	key := hex.EncodeToString(block.Hash)
+	if _, ok := bc.index[key]; ok {
+		return nil
+	}
+	if err := bc.ValidateBlock(block); err != nil {
+		return err
+	}
+	if err := bc.store.PutBlock(block); err != nil {
+		return err
+	}
+
+	parent := bc.index[hex.EncodeToString(block.PrevBlockHash)]
+	node := newBlockNode(block, parent)
+	bc.index[key] = node
+	if err := bc.addLeaf(node); err != nil {
+		return err
+	}
+
+	tip := bc.nodes[len(bc.nodes)-1]
+	switch {
+	case parent == tip:
+		return bc.connectTip(block, node)
+	case node.work.Cmp(tip.work) > 0:
+		return bc.reorganize(node)
+	}
+	return nil
+}
+
+// connectTip makes the block, child of the current tip, the new tip
+// and notifies the functions registered with OnConnect
+func (bc *Blockchain) connectTip(block *Block, node *blockNode) error {
+	if err := bc.setTip(block, node); err != nil {
+		return err
+	}
+	for _, fn := range bc.connectHandlers {
+		fn(block)
+	}
+	return nil
+}
+
+// setTip makes the block, child of the current tip, the new tip
+func (bc *Blockchain) setTip(block *Block, node *blockNode) error {
+	if err := bc.utxos.Connect(block, node.height); err != nil {
+		return err
+	}
+	if err := bc.store.PutMeta(tipKey, block.Hash); err != nil {
+		bc.utxos.Disconnect(block.Hash, block.PrevBlockHash)
+		return err
+	}
+	bc.nodes = append(bc.nodes, node)
+	bc.tip = block
+	return nil
+}
+
+// reorganize switches the best chain to the branch ending at newTip.
+// The blocks of the branch are validated while they are connected, and if
+// one of them is invalid the chain is restored to its previous tip. The
+// handlers are only notified once the whole branch is connected, so that
+// a failed reorganization leaves them as they were.
+func (bc *Blockchain) reorganize(newTip *blockNode) error {
+	var branch []*blockNode
+	fork := newTip
+	for !bc.IsMainChain(fork.hash) {
+		branch = append([]*blockNode{fork}, branch...)
+		fork = fork.parent
+	}
+	oldBranch := append([]*blockNode{}, bc.nodes[fork.height+1:]...)
+	if len(oldBranch) > UTXOUndoDepth {
+		return fmt.Errorf("%w: %d blocks", ErrReorgTooDeep, len(oldBranch))
+	}
+
+	event := ReorgEvent{OldTip: bc.tip.Hash, NewTip: newTip.hash, Fork: fork.hash}
+	for i := len(oldBranch) - 1; i >= 0; i-- {
+		block, err := bc.store.GetBlock(oldBranch[i].hash)
+		if err != nil {
+			return err
+		}
+		event.Disconnected = append(event.Disconnected, block)
+	}
+
+	if err := bc.rewind(fork); err != nil {
+		return err
+	}
+	for i, node := range branch {
+		block, err := bc.store.GetBlock(node.hash)
+		if err == nil {
+			err = bc.checkTransactions(block, node.height, bc.utxos.Set())
+		}
+		if err != nil {
+			for _, invalid := range branch[i:] {
+				invalid.invalid = true
+			}
+			if rerr := bc.restore(fork, oldBranch); rerr != nil {
+				return rerr
+			}
+			return err
+		}
+		if err := bc.setTip(block, node); err != nil {
+			return err
+		}
+		event.Connected = append(event.Connected, block)
+	}
+
+	for _, block := range event.Connected {
+		for _, fn := range bc.connectHandlers {
+			fn(block)
+		}
+	}
+	for _, fn := range bc.reorgHandlers {
+		fn(event)
+	}
+	return nil
+}
+
+// rewind disconnects the blocks of the best chain above fork,
+// reverting their changes to the UTXO set
+func (bc *Blockchain) rewind(fork *blockNode) error {
+	for len(bc.nodes) > fork.height+1 {
+		if err := bc.disconnectTip(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// disconnectTip makes the parent of the current tip the new tip
+func (bc *Blockchain) disconnectTip() error {
+	node := bc.nodes[len(bc.nodes)-1]
+	parent, err := bc.store.GetBlock(node.parent.hash)
+	if err != nil {
+		return err
+	}
+	if err := bc.store.PutMeta(tipKey, parent.Hash); err != nil {
+		return err
+	}
+	if err := bc.utxos.Disconnect(node.hash, parent.Hash); err != nil {
+		bc.store.PutMeta(tipKey, node.hash)
+		return err
+	}
+	bc.nodes = bc.nodes[:len(bc.nodes)-1]
+	bc.tip = parent
+	return nil
+}
+
+// restore connects back the blocks of a branch that was already valid
+func (bc *Blockchain) restore(fork *blockNode, branch []*blockNode) error {
+	if err := bc.rewind(fork); err != nil {
+		return err
+	}
+	for _, node := range branch {
+		block, err := bc.store.GetBlock(node.hash)
+		if err != nil {
+			return err
+		}
+		if err := bc.setTip(block, node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addLeaf makes a new node the tip of its branch in place of its parent,
+// and stores the hashes of the tips of all branches, so that side branches
+// are known again when the chain is reopened
+func (bc *Blockchain) addLeaf(node *blockNode) error {
+	if node.parent != nil {
+		delete(bc.leaves, hex.EncodeToString(node.parent.hash))
+	}
+	bc.leaves[hex.EncodeToString(node.hash)] = node.hash
+
+	leaves := make([][]byte, 0, len(bc.leaves))
+	for _, hash := range bc.leaves {
+		leaves = append(leaves, hash)
+	}
+	raw, err := json.Marshal(leaves)
+	if err != nil {
+		return err
+	}
+	return bc.store.PutMeta(leavesKey, raw)
+}
+
+// loadIndex rebuilds the block tree from the stored branch tips,
+// reading the headers of their blocks back to the genesis
+func (bc *Blockchain) loadIndex(tipHash []byte) error {
+	// Decoding into a fresh slice keeps tipHash from being overwritten
+	var leaves [][]byte
+	if raw, err := bc.store.GetMeta(leavesKey); err == nil {
+		if err := json.Unmarshal(raw, &leaves); err != nil {
+			return err
+		}
+	}
+	if len(leaves) == 0 {
+		leaves = [][]byte{tipHash}
+	}
+
+	bc.index = make(map[string]*blockNode)
+	for _, leaf := range leaves {
+		var branch []*Block
+		hash := leaf
+		for len(hash) > 0 {
+			if _, ok := bc.index[hex.EncodeToString(hash)]; ok {
+				break
+			}
+			block, err := bc.store.GetBlock(hash)
+			if err != nil {
+				return fmt.Errorf("reading block %x: %v", hash, err)
+			}
+			branch = append(branch, block)
+			hash = block.PrevBlockHash
+		}
+
+		parent := bc.index[hex.EncodeToString(hash)]
+		for i := len(branch) - 1; i >= 0; i-- {
+			node := newBlockNode(branch[i], parent)
+			bc.index[hex.EncodeToString(node.hash)] = node
+			parent = node
+		}
+	}
+
+	bc.leaves = make(map[string][]byte)
+	for key, node := range bc.index {
+		if node.children == 0 {
+			bc.leaves[key] = node.hash
+		}
+	}
+
+	tip, ok := bc.index[hex.EncodeToString(tipHash)]
+	if !ok {
+		return fmt.Errorf("tip %x not found", tipHash)
+	}
+	bc.nodes = make([]*blockNode, tip.height+1)
+	for node := tip; node != nil; node = node.parent {
+		bc.nodes[node.height] = node
+	}
+	return nil
+}
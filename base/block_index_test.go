@@ -0,0 +1,114 @@
+package base
+
+import (
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mineOn(parent *Block, address, data string) *Block {
+	coinbase := NewCoinbaseTX(address, data)
+	return NewBlock([]*Transaction{coinbase}, parent.Hash, parent.TargetBits, parent.Algorithm)
+}
+
+func TestProcessBlockSwitchesToBranchWithMoreWork(t *testing.T) {
+	address := NewWallet().GetStringAddress()
+	bc := CreateBlockchainWithParams(address, testChainParams())
+	genesis := bc.GetGenesisBlock()
+
+	var events []ReorgEvent
+	bc.OnReorg(func(event ReorgEvent) {
+		events = append(events, event)
+	})
+
+	a1 := mineOn(genesis, address, "a1")
+	assert.NoError(t, bc.ProcessBlock(a1))
+	assert.Equal(t, a1.Hash, bc.CurrentBlock().Hash)
+
+	b1 := mineOn(genesis, address, "b1")
+	assert.NoError(t, bc.ProcessBlock(b1))
+	assert.Equal(t, a1.Hash, bc.CurrentBlock().Hash, "equal work must not switch branches")
+	assert.False(t, bc.IsMainChain(b1.Hash))
+	assert.Empty(t, events)
+
+	b2 := mineOn(b1, address, "b2")
+	assert.NoError(t, bc.ProcessBlock(b2))
+	assert.Equal(t, b2.Hash, bc.CurrentBlock().Hash)
+	assert.Equal(t, 2, bc.Height())
+	assert.True(t, bc.IsMainChain(b1.Hash))
+	assert.False(t, bc.IsMainChain(a1.Hash))
+	assert.Equal(t, [][]byte{a1.Hash}, bc.SideBlocks())
+
+	assert.Len(t, events, 1)
+	assert.Equal(t, genesis.Hash, events[0].Fork)
+	assert.Equal(t, a1.Hash, events[0].Disconnected[0].Hash)
+	assert.Equal(t, b1.Hash, events[0].Connected[0].Hash)
+	assert.Equal(t, b2.Hash, events[0].Connected[1].Hash)
+
+	_, ok := bc.UTXOSet()[NewOutpoint(a1.Transactions[0].ID, 0)]
+	assert.False(t, ok, "the reward of a disconnected block must not be spendable")
+	assert.True(t, bc.FindUTXOSet().Equal(bc.UTXOSet()))
+}
+
+func TestProcessBlockKeepsTheTipsOfAllBranches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blockstore")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	address := NewWallet().GetStringAddress()
+	bc, err := OpenBlockchainDir(dir, address, testChainParams())
+	assert.NoError(t, err)
+	genesis := bc.GetGenesisBlock()
+	a1 := mineOn(genesis, address, "a1")
+	assert.NoError(t, bc.ProcessBlock(a1))
+	b1 := mineOn(genesis, address, "b1")
+	assert.NoError(t, bc.ProcessBlock(b1))
+	b2 := mineOn(b1, address, "b2")
+	assert.NoError(t, bc.ProcessBlock(b2))
+
+	// Each block replaces its parent among the tips
+	assert.Equal(t, map[string][]byte{hex.EncodeToString(a1.Hash): a1.Hash, hex.EncodeToString(b2.Hash): b2.Hash}, bc.leaves)
+	assert.NoError(t, bc.Close())
+
+	bc, err = OpenBlockchainDir(dir, address, testChainParams())
+	assert.NoError(t, err)
+	defer bc.Close()
+	assert.Equal(t, b2.Hash, bc.CurrentBlock().Hash)
+	assert.Equal(t, [][]byte{a1.Hash}, bc.SideBlocks())
+	assert.Len(t, bc.leaves, 2)
+}
+
+func TestProcessBlockRejectsOrphans(t *testing.T) {
+	address := NewWallet().GetStringAddress()
+	bc := CreateBlockchainWithParams(address, testChainParams())
+	orphan := mineOn(&Block{Hash: []byte("unknown"), TargetBits: 8}, address, "orphan")
+
+	assert.Error(t, bc.ProcessBlock(orphan))
+}
+
+func TestProcessBlockRejectsReorganizationsBelowUndoRecords(t *testing.T) {
+	address := NewWallet().GetStringAddress()
+	params := testChainParams()
+	params.RetargetInterval = 0
+	bc := CreateBlockchainWithParams(address, params)
+	genesis := bc.GetGenesisBlock()
+	for i := 0; i <= UTXOUndoDepth; i++ {
+		_, err := bc.AddBlock([]*Transaction{NewCoinbaseTX(address, "")})
+		assert.NoError(t, err)
+	}
+	tip := bc.CurrentBlock()
+
+	block := genesis
+	for i := 0; i <= UTXOUndoDepth; i++ {
+		block = mineOn(block, address, "side")
+		assert.NoError(t, bc.ProcessBlock(block))
+	}
+	err := bc.ProcessBlock(mineOn(block, address, "side"))
+	assert.True(t, errors.Is(err, ErrReorgTooDeep), err)
+	assert.Equal(t, tip.Hash, bc.CurrentBlock().Hash)
+	assert.NoError(t, bc.CheckUTXOs())
+}
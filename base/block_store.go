@@ -0,0 +1,344 @@
+package base
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrNotFound is returned by a BlockStore for missing blocks or metadata
+var ErrNotFound = errors.New("not found")
+
+// BlockStore persists blocks by hash along with small metadata values,
+// such as the tip of the chain
+type BlockStore interface {
+	PutBlock(block *Block) error
+	GetBlock(hash []byte) (*Block, error)
+	HasBlock(hash []byte) bool
+	PutMeta(key string, value []byte) error
+	GetMeta(key string) ([]byte, error)
+	Close() error
+}
+
+// UTXOStore is implemented by the block stores that also persist
+// the UTXO database of their chain
+type UTXOStore interface {
+	OpenUTXODB() (*UTXODB, error)
+}
+
+// MemoryBlockStore keeps blocks in memory
+type MemoryBlockStore struct {
+	mu     sync.RWMutex
+	blocks map[string]*Block
+	meta   map[string][]byte
+}
+
+// NewMemoryBlockStore creates an empty MemoryBlockStore
+func NewMemoryBlockStore() *MemoryBlockStore {
+	return &MemoryBlockStore{blocks: make(map[string]*Block), meta: make(map[string][]byte)}
+}
+
+// PutBlock stores the block
+func (s *MemoryBlockStore) PutBlock(block *Block) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocks[hex.EncodeToString(block.Hash)] = block
+	return nil
+}
+
+// GetBlock returns the block of a given hash
+func (s *MemoryBlockStore) GetBlock(hash []byte) (*Block, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	block, ok := s.blocks[hex.EncodeToString(hash)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return block, nil
+}
+
+// HasBlock checks if the block of a given hash is stored
+func (s *MemoryBlockStore) HasBlock(hash []byte) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.blocks[hex.EncodeToString(hash)]
+	return ok
+}
+
+// PutMeta stores a metadata value
+func (s *MemoryBlockStore) PutMeta(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.meta[key] = append([]byte{}, value...)
+	return nil
+}
+
+// GetMeta returns a metadata value
+func (s *MemoryBlockStore) GetMeta(key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.meta[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+// Close does nothing for a MemoryBlockStore
+func (s *MemoryBlockStore) Close() error {
+	return nil
+}
+
+// Files of a FileBlockStore
+const (
+	blockFileName = "blocks.dat"
+	indexFileName = "blocks.idx"
+	metaFileName  = "meta.json"
+)
+
+// FileBlockStore keeps blocks in an append-only file of length-prefixed
+// serialized blocks, plus an append-only index of the offset of every
+// block by hash. Only the index is kept in memory.
+type FileBlockStore struct {
+	mu      sync.Mutex
+	dir     string
+	data    *os.File
+	index   *os.File
+	size    int64 // size of the data file
+	offsets map[string]blockLocation
+	meta    map[string][]byte
+}
+
+// blockLocation is the position of a serialized block in the data file
+type blockLocation struct {
+	Offset int64
+	Size   uint32
+}
+
+// OpenFileBlockStore opens or creates a FileBlockStore in dir.
+// Blocks written to the data file but missing from the index, for instance
+// after a crash, are indexed again and a trailing partial block is dropped.
+// A trailing partial index record is dropped as well, its block being
+// indexed again, or dropped when it is partial too.
+func OpenFileBlockStore(dir string) (*FileBlockStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &FileBlockStore{dir: dir, offsets: make(map[string]blockLocation), meta: make(map[string][]byte)}
+
+	var err error
+	s.data, err = os.OpenFile(filepath.Join(dir, blockFileName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.index, err = os.OpenFile(filepath.Join(dir, indexFileName), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		s.data.Close()
+		return nil, err
+	}
+	if err = s.load(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads the index and the metadata and recovers unindexed blocks
+func (s *FileBlockStore) load() error {
+	indexed, err := s.readIndex()
+	if err != nil {
+		return err
+	}
+	if err := s.recover(indexed); err != nil {
+		return err
+	}
+
+	meta, err := ioutil.ReadFile(filepath.Join(s.dir, metaFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(meta, &s.meta)
+}
+
+// readIndex loads the index, truncating a partially written last record,
+// and returns the end of the last indexed block
+func (s *FileBlockStore) readIndex() (int64, error) {
+	raw, err := ioutil.ReadAll(s.index)
+	if err != nil {
+		return 0, err
+	}
+
+	var end int64
+	r := bytes.NewReader(raw)
+	for r.Len() > 0 {
+		// Records are only cut short at the end of the index
+		start := int64(len(raw) - r.Len())
+		hashLen, _ := r.ReadByte()
+		hash := make([]byte, hashLen)
+		var loc blockLocation
+		_, err := io.ReadFull(r, hash)
+		if err == nil {
+			err = binary.Read(r, binary.BigEndian, &loc)
+		}
+		if err != nil {
+			return end, s.index.Truncate(start)
+		}
+		s.offsets[hex.EncodeToString(hash)] = loc
+		if e := loc.Offset + 4 + int64(loc.Size); e > end {
+			end = e
+		}
+	}
+	return end, nil
+}
+
+// recover indexes the blocks stored after the offset end
+func (s *FileBlockStore) recover(end int64) error {
+	info, err := s.data.Stat()
+	if err != nil {
+		return err
+	}
+	s.size = info.Size()
+
+	for end < s.size {
+		sizeBuf := make([]byte, 4)
+		if _, err := s.data.ReadAt(sizeBuf, end); err != nil {
+			break
+		}
+		size := binary.BigEndian.Uint32(sizeBuf)
+		payload := make([]byte, size)
+		if _, err := s.data.ReadAt(payload, end+4); err != nil {
+			break
+		}
+		block, err := DeserializeBlock(payload)
+		if err != nil {
+			break
+		}
+		loc := blockLocation{Offset: end, Size: size}
+		if err := s.writeIndex(block.Hash, loc); err != nil {
+			return err
+		}
+		end += 4 + int64(size)
+	}
+
+	// Drop a partially written block
+	if end < s.size {
+		if err := s.data.Truncate(end); err != nil {
+			return err
+		}
+		s.size = end
+	}
+	return nil
+}
+
+func (s *FileBlockStore) writeIndex(hash []byte, loc blockLocation) error {
+	var record bytes.Buffer
+	record.WriteByte(byte(len(hash)))
+	record.Write(hash)
+	binary.Write(&record, binary.BigEndian, loc)
+	if _, err := s.index.Write(record.Bytes()); err != nil {
+		return err
+	}
+	s.offsets[hex.EncodeToString(hash)] = loc
+	return nil
+}
+
+// PutBlock appends the block to the data file and indexes it
+func (s *FileBlockStore) PutBlock(block *Block) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.offsets[hex.EncodeToString(block.Hash)]; ok {
+		return nil
+	}
+
+	payload := block.Serialize()
+	record := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(record, uint32(len(payload)))
+	copy(record[4:], payload)
+	if _, err := s.data.WriteAt(record, s.size); err != nil {
+		return err
+	}
+	if err := s.data.Sync(); err != nil {
+		return err
+	}
+
+	loc := blockLocation{Offset: s.size, Size: uint32(len(payload))}
+	s.size += int64(len(record))
+	return s.writeIndex(block.Hash, loc)
+}
+
+// GetBlock reads the block of a given hash from the data file
+func (s *FileBlockStore) GetBlock(hash []byte) (*Block, error) {
+	s.mu.Lock()
+	loc, ok := s.offsets[hex.EncodeToString(hash)]
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	payload := make([]byte, loc.Size)
+	if _, err := s.data.ReadAt(payload, loc.Offset+4); err != nil {
+		return nil, err
+	}
+	return DeserializeBlock(payload)
+}
+
+// HasBlock checks if the block of a given hash is stored
+func (s *FileBlockStore) HasBlock(hash []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.offsets[hex.EncodeToString(hash)]
+	return ok
+}
+
+// PutMeta stores a metadata value, rewriting the metadata file atomically
+func (s *FileBlockStore) PutMeta(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.meta[key] = append([]byte{}, value...)
+
+	raw, err := json.Marshal(s.meta)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.dir, metaFileName+".tmp")
+	if err := ioutil.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.dir, metaFileName))
+}
+
+// GetMeta returns a metadata value
+func (s *FileBlockStore) GetMeta(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.meta[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+// OpenUTXODB opens the UTXO database kept in the directory of the store
+func (s *FileBlockStore) OpenUTXODB() (*UTXODB, error) {
+	return OpenUTXODB(s.dir)
+}
+
+// Close closes the files of the store
+func (s *FileBlockStore) Close() error {
+	errData := s.data.Close()
+	errIndex := s.index.Close()
+	if errData != nil {
+		return errData
+	}
+	return errIndex
+}
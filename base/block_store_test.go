@@ -0,0 +1,137 @@
+package base
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testChainParams() ChainParams {
+	params := DefaultChainParams()
+	params.TargetBits = 8
+	params.CoinbaseMaturity = 0
+	return params
+}
+
+func TestFileBlockStoreReopensBlockchain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blockstore")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	wallet := NewWallet()
+	store, err := OpenFileBlockStore(dir)
+	assert.NoError(t, err)
+	bc, err := NewBlockchain(store, wallet.GetStringAddress(), testChainParams())
+	assert.NoError(t, err)
+	block, err := bc.AddBlock([]*Transaction{NewCoinbaseTX(wallet.GetStringAddress(), "")})
+	assert.NoError(t, err)
+	utxos := bc.FindUTXOSet()
+	assert.NoError(t, bc.Close())
+
+	store, err = OpenFileBlockStore(dir)
+	assert.NoError(t, err)
+	defer store.Close()
+	reopened, err := NewBlockchain(store, NewWallet().GetStringAddress(), DefaultChainParams())
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, reopened.Height())
+	assert.Equal(t, block.Hash, reopened.CurrentBlock().Hash)
+	assert.Equal(t, testChainParams(), reopened.Params())
+	assert.True(t, utxos.Equal(reopened.FindUTXOSet()))
+
+	tx, err := reopened.FindTransaction(block.Transactions[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, block.Transactions[0].ID, tx.ID)
+}
+
+func TestFileBlockStoreRecoversUnindexedBlocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blockstore")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := OpenFileBlockStore(dir)
+	assert.NoError(t, err)
+	bc, err := NewBlockchain(store, NewWallet().GetStringAddress(), testChainParams())
+	assert.NoError(t, err)
+	genesis := bc.GetGenesisBlock()
+	assert.NoError(t, bc.Close())
+
+	// Lose the index and leave a partially written block behind
+	assert.NoError(t, os.Remove(filepath.Join(dir, indexFileName)))
+	f, err := os.OpenFile(filepath.Join(dir, blockFileName), os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	f.Write([]byte{0, 0, 1})
+	f.Close()
+
+	store, err = OpenFileBlockStore(dir)
+	assert.NoError(t, err)
+	defer store.Close()
+	block, err := store.GetBlock(genesis.Hash)
+	assert.NoError(t, err)
+	assert.Equal(t, genesis.Hash, block.Hash)
+}
+
+func TestFileBlockStoreTruncatesPartialIndexRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blockstore")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	address := NewWallet().GetStringAddress()
+	bc, err := OpenBlockchainDir(dir, address, testChainParams())
+	assert.NoError(t, err)
+	tip, err := bc.AddBlock([]*Transaction{NewCoinbaseTX(address, "")})
+	assert.NoError(t, err)
+	assert.NoError(t, bc.Close())
+
+	// Cut the index in the middle of the record of the tip
+	index := filepath.Join(dir, indexFileName)
+	info, err := os.Stat(index)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Truncate(index, info.Size()-5))
+
+	bc, err = OpenBlockchainDir(dir, address, testChainParams())
+	assert.NoError(t, err)
+	assert.Equal(t, tip.Hash, bc.CurrentBlock().Hash)
+	assert.NoError(t, bc.CheckUTXOs())
+	assert.NoError(t, bc.Close())
+
+	// The record of the tip was written again from the data file
+	reindexed, err := os.Stat(index)
+	assert.NoError(t, err)
+	assert.Equal(t, info.Size(), reindexed.Size())
+}
+
+func TestOpenBlockchainDirExtendsTheStoredChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blockstore")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	address := NewWallet().GetStringAddress()
+	bc, err := OpenBlockchainDir(dir, address, testChainParams())
+	assert.NoError(t, err)
+	genesis := bc.GetGenesisBlock()
+	_, err = bc.AddBlock([]*Transaction{NewCoinbaseTX(address, "")})
+	assert.NoError(t, err)
+	tip := bc.CurrentBlock()
+	assert.NoError(t, bc.Close())
+
+	// The second run finds the tip of the first one and extends it
+	bc, err = OpenBlockchainDir(dir, NewWallet().GetStringAddress(), DefaultChainParams())
+	assert.NoError(t, err)
+	assert.Equal(t, genesis.Hash, bc.GetGenesisBlock().Hash)
+	assert.Equal(t, tip.Hash, bc.CurrentBlock().Hash)
+	assert.Equal(t, 1, bc.Height())
+	block, err := bc.AddBlock([]*Transaction{NewCoinbaseTX(address, "")})
+	assert.NoError(t, err)
+	assert.NoError(t, bc.Close())
+
+	bc, err = OpenBlockchainDir(dir, address, testChainParams())
+	assert.NoError(t, err)
+	defer bc.Close()
+	assert.Equal(t, block.Hash, bc.CurrentBlock().Hash)
+	assert.Equal(t, 2, bc.Height())
+	assert.NoError(t, bc.CheckUTXOs())
+}
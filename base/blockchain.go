@@ -4,86 +4,267 @@ import (
 	"bytes"
 	"crypto/ecdsa"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
 )
 
-// Blockchain keeps a sequence of Blocks
+// Blockchain keeps a tree of Blocks in a BlockStore, and the sequence
+// of blocks of its branch with the most work
 type Blockchain struct {
-	blocks []*Block
+	store           BlockStore
+	index           map[string]*blockNode // every known block, by hash
+	leaves          map[string][]byte     // the tips of all branches, by hash
+	nodes           []*blockNode          // the headers of the best chain, by height
+	tip             *Block
+	utxos           *UTXODB // the unspent outputs of the best chain
+	params          ChainParams
+	reorgHandlers   []func(ReorgEvent)
+	connectHandlers []func(*Block)
 }
 
+// Metadata keys of a blockchain in its BlockStore
+const (
+	tipKey    = "tip"
+	leavesKey = "leaves"
+	paramsKey = "params"
+)
+
+// ErrNoBlockchain is returned when opening a store without a blockchain
+var ErrNoBlockchain = errors.New("no blockchain in the store")
+
 // CreateBlockchain creates a new blockchain with genesis Block
 func CreateBlockchain(address string) *Blockchain {
-	inn := TXInput{Txid: []byte{}, OutIdx: -1, Signature: nil, PubKey: []byte(GenesisCoinbaseData)}
-	out := *NewTXOutput(BlockReward, address)
-	tx := Transaction{Vin: []TXInput{inn}, Vout: []TXOutput{out}}
-	tx.ID = tx.Hash()
-	genesisBlock := NewGenesisBlock(&tx)
-	blockchain := Blockchain{blocks: []*Block{genesisBlock}}
+	return CreateBlockchainWithParams(address, DefaultChainParams())
+}
+
+// CreateBlockchainWithParams creates a new in-memory blockchain
+// with genesis Block using the given consensus parameters
+func CreateBlockchainWithParams(address string, params ChainParams) *Blockchain {
+	bc, err := createChainInStore(NewMemoryBlockStore(), address, params)
+	if err != nil {
+		panic(err.Error())
+	}
+	return bc
+}
+
+// NewBlockchain opens the blockchain kept in the store, or creates a new
+// one with genesis Block rewarding address if the store is empty
+func NewBlockchain(store BlockStore, address string, params ChainParams) (*Blockchain, error) {
+	bc, err := OpenBlockchain(store)
+	if err == ErrNoBlockchain {
+		return createChainInStore(store, address, params)
+	}
+	return bc, err
+}
+
+// OpenBlockchainDir opens the blockchain kept in a FileBlockStore in dir,
+// or creates it with genesis Block rewarding address if dir has no chain
+func OpenBlockchainDir(dir, address string, params ChainParams) (*Blockchain, error) {
+	store, err := OpenFileBlockStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	bc, err := NewBlockchain(store, address, params)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	return bc, nil
+}
+
+func createChainInStore(store BlockStore, address string, params ChainParams) (*Blockchain, error) {
+	rawParams, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.PutMeta(paramsKey, rawParams); err != nil {
+		return nil, err
+	}
+
+	tx := NewCoinbaseTXWithReward(address, GenesisCoinbaseData, params.Subsidy(0))
+	genesisBlock := NewGenesisBlock(tx, params.TargetBits, params.PoWAlgorithm)
+	utxos, err := openUTXODB(store)
+	if err != nil {
+		return nil, err
+	}
+	if err := utxos.Reset(); err != nil {
+		return nil, err
+	}
+	blockchain := &Blockchain{store: store, index: make(map[string]*blockNode), leaves: make(map[string][]byte), utxos: utxos, params: params}
+	if err := blockchain.appendBlock(genesisBlock); err != nil {
+		return nil, err
+	}
 
-	return &blockchain
+	return blockchain, nil
 }
 
-// NewBlockchain creates a Blockchain
-func NewBlockchain(address string) *Blockchain {
-	return CreateBlockchain(address)
+// OpenBlockchain opens the blockchain kept in the store, reading the
+// headers of its blocks from the tips back to the genesis
+func OpenBlockchain(store BlockStore) (*Blockchain, error) {
+	tipHash, err := store.GetMeta(tipKey)
+	if err == ErrNotFound {
+		return nil, ErrNoBlockchain
+	}
+	if err != nil {
+		return nil, err
+	}
+	rawParams, err := store.GetMeta(paramsKey)
+	if err != nil {
+		return nil, err
+	}
+	// Parameters missing from older stores keep their default value
+	bc := &Blockchain{store: store, params: DefaultChainParams()}
+	if err := json.Unmarshal(rawParams, &bc.params); err != nil {
+		return nil, err
+	}
+
+	if err := bc.loadIndex(tipHash); err != nil {
+		return nil, err
+	}
+	if bc.tip, err = store.GetBlock(tipHash); err != nil {
+		return nil, err
+	}
+	if bc.utxos, err = openUTXODB(store); err != nil {
+		return nil, err
+	}
+	// The UTXO database lags behind the chain after a crash
+	// between the updates of both
+	if !bytes.Equal(bc.utxos.Tip(), tipHash) {
+		if err := bc.ReindexUTXOs(); err != nil {
+			return nil, err
+		}
+	}
+
+	return bc, nil
 }
 
-// AddBlock saves the block into the blockchain
-func (bc *Blockchain) AddBlock(transactions []*Transaction) *Block {
+// appendBlock stores a block mined on the tip and makes it the new tip
+func (bc *Blockchain) appendBlock(block *Block) error {
+	if err := bc.store.PutBlock(block); err != nil {
+		return err
+	}
+	var parent *blockNode
+	if len(bc.nodes) > 0 {
+		parent = bc.nodes[len(bc.nodes)-1]
+	}
+	node := newBlockNode(block, parent)
+	bc.index[hex.EncodeToString(block.Hash)] = node
+	if err := bc.addLeaf(node); err != nil {
+		return err
+	}
+	return bc.connectTip(block, node)
+}
+
+// AddBlock mines a block with the transactions and saves it into the blockchain
+func (bc *Blockchain) AddBlock(transactions []*Transaction) (*Block, error) {
 	current := bc.CurrentBlock()
-	block := NewBlock(transactions, current.Hash)
-	bc.blocks = append(bc.blocks, block)
-	return block
+	block := NewBlock(transactions, current.Hash, bc.NextTargetBits(), bc.params.PoWAlgorithm)
+	if err := bc.ValidateBlock(block); err != nil {
+		return nil, err
+	}
+	if err := bc.appendBlock(block); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// openUTXODB opens the UTXO database persisted by the store,
+// or creates one in memory if the store does not persist it
+func openUTXODB(store BlockStore) (*UTXODB, error) {
+	if s, ok := store.(UTXOStore); ok {
+		return s.OpenUTXODB()
+	}
+	return NewUTXODB(), nil
+}
+
+// Close closes the store and the UTXO database of the blockchain
+func (bc *Blockchain) Close() error {
+	errUTXOs := bc.utxos.Close()
+	if err := bc.store.Close(); err != nil {
+		return err
+	}
+	return errUTXOs
+}
+
+// Params returns the consensus parameters of the blockchain
+func (bc Blockchain) Params() ChainParams {
+	return bc.params
+}
+
+// Height returns the height of the last block, the genesis being at 0
+func (bc Blockchain) Height() int {
+	return len(bc.nodes) - 1
+}
+
+// UTXOSet returns the unspent outputs of the best chain,
+// which must not be modified by the caller
+func (bc Blockchain) UTXOSet() UTXOSet {
+	return bc.utxos.Set()
+}
+
+// UTXODB returns the UTXO database of the best chain,
+// which must not be modified by the caller
+func (bc Blockchain) UTXODB() *UTXODB {
+	return bc.utxos
 }
 
 // GetGenesisBlock returns the Genesis Block
 func (bc Blockchain) GetGenesisBlock() *Block {
-	return bc.blocks[0]
+	block, err := bc.BlockAt(0)
+	if err != nil {
+		return nil
+	}
+	return block
 }
 
 // CurrentBlock returns the last block
 func (bc Blockchain) CurrentBlock() *Block {
-	return bc.blocks[len(bc.blocks)-1]
+	return bc.tip
+}
+
+// BlockAt returns the block at the given height
+func (bc Blockchain) BlockAt(height int) (*Block, error) {
+	if height < 0 || height >= len(bc.nodes) {
+		return nil, fmt.Errorf("no block at height %d", height)
+	}
+	return bc.store.GetBlock(bc.nodes[height].hash)
 }
 
 // GetBlock returns the block of a given hash
 func (bc Blockchain) GetBlock(hash []byte) (*Block, error) {
-	for i := len(bc.blocks) - 1; i >= 0; i-- {
-		if bytes.Equal(bc.blocks[i].Hash, hash) {
-			return bc.blocks[i], nil
-		}
+	block, err := bc.store.GetBlock(hash)
+	if err == ErrNotFound {
+		return nil, errors.New("no blocks has the given hash")
 	}
-
-	return nil, errors.New("no blocks has the given hash")
+	return block, err
 }
 
-// ValidateBlock validates the a block after mining or
-// before adding it to the blockchain
-func (bc *Blockchain) ValidateBlock(block *Block) bool {
-	// TODO(student)
-	// check if and only if the first tx is coinbase
-	// validates block's Proof-Of-Work
-	if !block.Transactions[0].IsCoinbase() {
-		return false
-	}
-	if bytes.Compare(block.PrevBlockHash, bc.CurrentBlock().Hash) != 0 {
-		return false
+// forEachBlock calls fn with every block and its height from the genesis
+// to the tip, loading a single block at a time from the store
+func (bc Blockchain) forEachBlock(fn func(int, *Block) error) error {
+	for height := range bc.nodes {
+		block, err := bc.BlockAt(height)
+		if err != nil {
+			return err
+		}
+		if err := fn(height, block); err != nil {
+			return err
+		}
 	}
-	pow := NewProofOfWork(block)
-
-	return pow.Validate()
+	return nil
 }
 
-// MineBlock mines a new block with the provided transactions
+// MineBlock mines a new block with the provided transactions,
+// such as a block template of a Mempool
 func (bc *Blockchain) MineBlock(transactions []*Transaction) (*Block, error) {
 	var validTxs []*Transaction
+	created := make(map[string]*Transaction)
 	for _, tx := range transactions {
-		isValid := bc.VerifyTransaction(tx)
-		if isValid {
+		if bc.verifyTransactionIn(tx, created) {
 			validTxs = append(validTxs, tx)
+			created[hex.EncodeToString(tx.ID)] = tx
 		}
 	}
 
@@ -91,16 +272,18 @@ func (bc *Blockchain) MineBlock(transactions []*Transaction) (*Block, error) {
 		return nil, errors.New("there are no valid transactions to be mined")
 	}
 
-	return bc.AddBlock(validTxs), nil
+	return bc.AddBlock(validTxs)
 }
 
 // FindTransaction finds a transaction by its ID in the whole blockchain
 func (bc Blockchain) FindTransaction(ID []byte) (*Transaction, error) {
-	for _, block := range bc.blocks {
-		for _, tx := range block.Transactions {
-			if bytes.Equal(tx.ID, ID) {
-				return tx, nil
-			}
+	for height := len(bc.nodes) - 1; height >= 0; height-- {
+		block, err := bc.BlockAt(height)
+		if err != nil {
+			return nil, err
+		}
+		if tx, err := block.FindTransaction(ID); err == nil {
+			return tx, nil
 		}
 	}
 
@@ -109,14 +292,52 @@ func (bc Blockchain) FindTransaction(ID []byte) (*Transaction, error) {
 
 // FindUTXOSet finds and returns all unspent transaction outputs
 func (bc Blockchain) FindUTXOSet() UTXOSet {
-	UTXO := make(UTXOSet)
-	for _, block := range bc.blocks {
-		UTXO.Update(block.Transactions)
+	UTXO, err := bc.replayUTXOs()
+	if err != nil {
+		panic(err.Error())
 	}
 
 	return UTXO
 }
 
+// replayUTXOs builds the UTXO set of the best chain from the genesis
+func (bc Blockchain) replayUTXOs() (UTXOSet, error) {
+	utxos := make(UTXOSet)
+	err := bc.forEachBlock(func(height int, block *Block) error {
+		if _, err := utxos.Update(block.Transactions, height); err != nil {
+			return fmt.Errorf("block %x: %w", block.Hash, err)
+		}
+		return nil
+	})
+	return utxos, err
+}
+
+// ReindexUTXOs rebuilds the UTXO database from the blocks of the best chain
+func (bc *Blockchain) ReindexUTXOs() error {
+	if err := bc.utxos.Reset(); err != nil {
+		return err
+	}
+	return bc.forEachBlock(func(height int, block *Block) error {
+		return bc.utxos.Connect(block, height)
+	})
+}
+
+// CheckUTXOs checks that the UTXO database matches
+// a replay of the best chain, and that its index is consistent
+func (bc *Blockchain) CheckUTXOs() error {
+	if !bytes.Equal(bc.utxos.Tip(), bc.tip.Hash) {
+		return fmt.Errorf("UTXO database at %x, chain at %x", bc.utxos.Tip(), bc.tip.Hash)
+	}
+	replayed, err := bc.replayUTXOs()
+	if err != nil {
+		return err
+	}
+	if !replayed.Equal(bc.utxos.Set()) {
+		return errors.New("UTXO database differs from a replay of the chain")
+	}
+	return bc.utxos.checkIndex()
+}
+
 // GetInputTXsOf returns a map index by the ID,
 // of all transactions used as inputs in the given transaction
 func (bc *Blockchain) GetInputTXsOf(tx *Transaction) (map[string]*Transaction, error) {
@@ -147,7 +368,7 @@ func (bc *Blockchain) GetInputTXsOf(tx *Transaction) (map[string]*Transaction, e
 	return prevTXs, nil
 }
 
-// SignTransaction signs inputs of a Transaction
+// SignTransaction signs inputs of a Transaction and sets its ID
 func (bc *Blockchain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) {
 	// Get the previous transactions referred in the input of tx
 	// and call Sign for tx.
@@ -157,6 +378,7 @@ func (bc *Blockchain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey)
 		panic(err.Error())
 	}
 	tx.Sign(privKey, prevTXs)
+	tx.ID = tx.Hash()
 }
 
 // VerifyTransaction verifies transaction input signatures
@@ -176,10 +398,32 @@ func (bc *Blockchain) VerifyTransaction(tx *Transaction) bool {
 	return tx.Verify(prevTXs)
 }
 
+// verifyTransactionIn verifies the input signatures of a transaction that
+// may spend the outputs of the created transactions preceding it in a block
+func (bc *Blockchain) verifyTransactionIn(tx *Transaction, created map[string]*Transaction) bool {
+	if tx.IsCoinbase() {
+		return true
+	}
+	prevTXs := make(map[string]*Transaction)
+	utxos := bc.utxos.Set()
+	for _, input := range tx.Vin {
+		key := hex.EncodeToString(input.Txid)
+		if prevTX, ok := created[key]; ok {
+			prevTXs[key] = prevTX
+		} else if output, ok := utxos[NewOutpoint(input.Txid, input.OutIdx)]; ok {
+			addSpentOutput(prevTXs, input, output.TXOutput)
+		} else {
+			return false
+		}
+	}
+	return tx.Verify(prevTXs)
+}
+
 func (bc Blockchain) String() string {
 	var lines []string
-	for _, block := range bc.blocks {
+	bc.forEachBlock(func(_ int, block *Block) error {
 		lines = append(lines, fmt.Sprintf("%v", block))
-	}
+		return nil
+	})
 	return strings.Join(lines, "\n")
 }
@@ -0,0 +1,277 @@
+package base
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// Names of the available coin selection strategies
+const (
+	LargestFirstSelection   = "largest-first"
+	SmallestFirstSelection  = "smallest-first"
+	BranchAndBoundSelection = "branch-and-bound"
+	RandomImproveSelection  = "random-improve"
+)
+
+// DefaultCoinSelection is used by transactions that do not choose a strategy
+const DefaultCoinSelection = LargestFirstSelection
+
+// bnbMaxTries bounds the search of the branch-and-bound strategy
+const bnbMaxTries = 100000
+
+// Coin is an unspent output that a wallet may spend
+type Coin struct {
+	Outpoint Outpoint
+	Value    int
+}
+
+// CoinSelector chooses the coins funding a transaction
+type CoinSelector interface {
+	// Name identifies the strategy
+	Name() string
+	// Select returns coins worth at least target if there are enough of
+	// them. The coins are sorted by outpoint, and rng is the only source
+	// of randomness, so the selection is deterministic given its seed.
+	// An excess of up to costOfChange is worth spending as fee rather
+	// than creating a change output.
+	Select(coins []Coin, target, costOfChange int, rng *rand.Rand) []Coin
+}
+
+var coinSelectors = map[string]CoinSelector{
+	LargestFirstSelection:   largestFirst{},
+	SmallestFirstSelection:  smallestFirst{},
+	BranchAndBoundSelection: branchAndBound{},
+	RandomImproveSelection:  randomImprove{},
+}
+
+// GetCoinSelector returns the coin selection strategy with the given name,
+// the default strategy being used for an empty name
+func GetCoinSelector(name string) (CoinSelector, error) {
+	if name == "" {
+		name = DefaultCoinSelection
+	}
+	selector, ok := coinSelectors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown coin selection strategy %q", name)
+	}
+	return selector, nil
+}
+
+// CoinSelection configures the coin selection of a new transaction
+type CoinSelection struct {
+	Strategy     string // name of the strategy, the default one if empty
+	Seed         int64  // seed of the randomized strategies
+	CostOfChange int    // largest change left to the miner instead of sent back
+}
+
+// CoinSelectionReport describes the coins spent by a new transaction
+type CoinSelectionReport struct {
+	Strategy string
+	Inputs   int // number of coins spent
+	Selected int // value of the coins spent
+	Change   int // value sent back to the wallet
+	Fee      int // value left to the miner
+}
+
+func (r CoinSelectionReport) String() string {
+	return fmt.Sprintf("%s: %d inputs of %d, change %d, fee %d", r.Strategy, r.Inputs, r.Selected, r.Change, r.Fee)
+}
+
+// SummarizeCoinSelection averages the reports of each strategy
+func SummarizeCoinSelection(reports []*CoinSelectionReport) []CoinSelectionReport {
+	var names []string
+	sums := make(map[string]*CoinSelectionReport)
+	counts := make(map[string]int)
+	for _, r := range reports {
+		sum, ok := sums[r.Strategy]
+		if !ok {
+			sum = &CoinSelectionReport{Strategy: r.Strategy}
+			sums[r.Strategy] = sum
+			names = append(names, r.Strategy)
+		}
+		sum.Inputs += r.Inputs
+		sum.Selected += r.Selected
+		sum.Change += r.Change
+		sum.Fee += r.Fee
+		counts[r.Strategy]++
+	}
+	sort.Strings(names)
+
+	summary := make([]CoinSelectionReport, 0, len(names))
+	for _, name := range names {
+		sum, n := sums[name], counts[name]
+		summary = append(summary, CoinSelectionReport{
+			Strategy: name,
+			Inputs:   sum.Inputs / n,
+			Selected: sum.Selected / n,
+			Change:   sum.Change / n,
+			Fee:      sum.Fee / n,
+		})
+	}
+	return summary
+}
+
+// Select chooses coins worth at least target with the configured strategy,
+// and returns them with the name of the strategy
+func (s CoinSelection) Select(coins []Coin, target int) ([]Coin, string, error) {
+	selector, err := GetCoinSelector(s.Strategy)
+	if err != nil {
+		return nil, "", err
+	}
+	rng := rand.New(rand.NewSource(s.Seed))
+	return selector.Select(coins, target, s.CostOfChange, rng), selector.Name(), nil
+}
+
+// sortedCoins returns the outputs as coins sorted by outpoint
+func sortedCoins(outputs map[Outpoint]UTXO) []Coin {
+	coins := make([]Coin, 0, len(outputs))
+	for op, output := range outputs {
+		coins = append(coins, Coin{Outpoint: op, Value: output.Value})
+	}
+	sort.Slice(coins, func(i, j int) bool {
+		if coins[i].Outpoint.Txid != coins[j].Outpoint.Txid {
+			return coins[i].Outpoint.Txid < coins[j].Outpoint.Txid
+		}
+		return coins[i].Outpoint.Index < coins[j].Outpoint.Index
+	})
+	return coins
+}
+
+// coinsValue returns the value of the coins
+func coinsValue(coins []Coin) int {
+	sum := 0
+	for _, coin := range coins {
+		sum += coin.Value
+	}
+	return sum
+}
+
+// accumulate selects coins in order until their value reaches target
+func accumulate(coins []Coin, target int) []Coin {
+	var selected []Coin
+	sum := 0
+	for _, coin := range coins {
+		if sum >= target {
+			break
+		}
+		selected = append(selected, coin)
+		sum += coin.Value
+	}
+	return selected
+}
+
+// largestFirst spends the largest coins, using few inputs
+type largestFirst struct{}
+
+func (largestFirst) Name() string { return LargestFirstSelection }
+
+func (largestFirst) Select(coins []Coin, target, costOfChange int, rng *rand.Rand) []Coin {
+	sorted := append([]Coin{}, coins...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Value > sorted[j].Value })
+	return accumulate(sorted, target)
+}
+
+// smallestFirst spends the smallest coins, consolidating the wallet
+type smallestFirst struct{}
+
+func (smallestFirst) Name() string { return SmallestFirstSelection }
+
+func (smallestFirst) Select(coins []Coin, target, costOfChange int, rng *rand.Rand) []Coin {
+	sorted := append([]Coin{}, coins...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Value < sorted[j].Value })
+	return accumulate(sorted, target)
+}
+
+// branchAndBound searches for coins worth between target and
+// target+costOfChange, so that no change output is needed, and falls
+// back to largest-first when there are none
+type branchAndBound struct{}
+
+func (branchAndBound) Name() string { return BranchAndBoundSelection }
+
+func (branchAndBound) Select(coins []Coin, target, costOfChange int, rng *rand.Rand) []Coin {
+	sorted := append([]Coin{}, coins...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Value > sorted[j].Value })
+	// remaining[i] is the value of the coins from i on
+	remaining := make([]int, len(sorted)+1)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + sorted[i].Value
+	}
+
+	// Depth-first search over the inclusion of each coin, the largest
+	// first, keeping the match with the least excess
+	var best []int
+	bestExcess := -1
+	included := make([]int, 0, len(sorted))
+	tries := 0
+	var search func(i, sum int)
+	search = func(i, sum int) {
+		tries++
+		if tries > bnbMaxTries || bestExcess == 0 {
+			return
+		}
+		if sum > target+costOfChange || sum+remaining[i] < target {
+			return
+		}
+		if sum >= target {
+			if excess := sum - target; bestExcess < 0 || excess < bestExcess {
+				best, bestExcess = append([]int{}, included...), excess
+			}
+			return
+		}
+		if i == len(sorted) {
+			return
+		}
+		included = append(included, i)
+		search(i+1, sum+sorted[i].Value)
+		included = included[:len(included)-1]
+		search(i+1, sum)
+	}
+	search(0, 0)
+
+	if bestExcess < 0 {
+		return largestFirst{}.Select(coins, target, costOfChange, rng)
+	}
+	selected := make([]Coin, 0, len(best))
+	for _, i := range best {
+		selected = append(selected, sorted[i])
+	}
+	return selected
+}
+
+// randomImprove selects random coins until they reach target, then adds
+// random coins bringing their value closer to twice the target, without
+// exceeding three times the target, so that the change outputs resemble
+// the payments
+type randomImprove struct{}
+
+func (randomImprove) Name() string { return RandomImproveSelection }
+
+func (randomImprove) Select(coins []Coin, target, costOfChange int, rng *rand.Rand) []Coin {
+	shuffled := append([]Coin{}, coins...)
+	rng.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	selected := accumulate(shuffled, target)
+	sum := coinsValue(selected)
+	if sum < target {
+		return selected
+	}
+
+	ideal, limit := 2*target, 3*target
+	for _, coin := range shuffled[len(selected):] {
+		next := sum + coin.Value
+		if next > limit || abs(ideal-next) >= abs(ideal-sum) {
+			continue
+		}
+		selected = append(selected, coin)
+		sum = next
+	}
+	return selected
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
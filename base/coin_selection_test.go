@@ -0,0 +1,112 @@
+package base
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testCoins returns coins of the given values with distinct outpoints
+func testCoins(values ...int) []Coin {
+	coins := make([]Coin, len(values))
+	for i, value := range values {
+		coins[i] = Coin{Outpoint: Outpoint{Txid: "00", Index: i}, Value: value}
+	}
+	return coins
+}
+
+func coinValues(coins []Coin) []int {
+	var values []int
+	for _, coin := range coins {
+		values = append(values, coin.Value)
+	}
+	return values
+}
+
+func TestCoinSelectorsOrderCoins(t *testing.T) {
+	coins := testCoins(5, 1, 8, 3)
+	rng := rand.New(rand.NewSource(1))
+
+	assert.Equal(t, []int{8, 5}, coinValues(largestFirst{}.Select(coins, 9, 0, rng)))
+	assert.Equal(t, []int{1, 3, 5}, coinValues(smallestFirst{}.Select(coins, 9, 0, rng)))
+	assert.Equal(t, 17, coinsValue(largestFirst{}.Select(coins, 100, 0, rng)), "all the coins are short of the target")
+
+	_, err := GetCoinSelector("unknown")
+	assert.Error(t, err)
+	selector, err := GetCoinSelector("")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultCoinSelection, selector.Name())
+}
+
+func TestBranchAndBoundAvoidsChange(t *testing.T) {
+	coins := testCoins(10, 7, 5, 3)
+	rng := rand.New(rand.NewSource(1))
+
+	assert.Equal(t, []int{7, 5}, coinValues(branchAndBound{}.Select(coins, 12, 0, rng)))
+	assert.Equal(t, []int{10, 3}, coinValues(branchAndBound{}.Select(coins, 13, 0, rng)))
+	// An excess within the cost of change is accepted
+	assert.Equal(t, 15, coinsValue(branchAndBound{}.Select(coins, 14, 2, rng)))
+	// Without a match, the largest coins are spent
+	assert.Equal(t, []int{10, 7}, coinValues(branchAndBound{}.Select(coins, 14, 0, rng)))
+}
+
+func TestRandomImproveIsDeterministicGivenASeed(t *testing.T) {
+	coins := testCoins(1, 2, 3, 4, 5, 6, 7, 8, 9, 10)
+	first := randomImprove{}.Select(coins, 6, 0, rand.New(rand.NewSource(42)))
+	again := randomImprove{}.Select(coins, 6, 0, rand.New(rand.NewSource(42)))
+	assert.Equal(t, first, again)
+
+	sum := coinsValue(first)
+	assert.True(t, sum >= 6 && sum <= 18, "selected %d", sum)
+}
+
+func TestNewUTXOTransactionWithSelectionReportsCoins(t *testing.T) {
+	from, to := NewWallet(), NewWallet()
+	address := from.GetStringAddress()
+	bc := CreateBlockchainWithParams(address, testChainParams())
+	split, err := NewUTXOTransaction(from, address, 3, bc.UTXOSet(), bc)
+	assert.NoError(t, err)
+	_, err = bc.AddBlock([]*Transaction{NewCoinbaseTX(address, ""), split})
+	assert.NoError(t, err)
+
+	// The wallet owns coins of 3, 7 and 10
+	tx, report, err := NewUTXOTransactionWithSelection(from, to.GetStringAddress(), 13, 0,
+		CoinSelection{Strategy: BranchAndBoundSelection}, bc.UTXODB(), bc)
+	assert.NoError(t, err)
+	assert.Equal(t, CoinSelectionReport{Strategy: BranchAndBoundSelection, Inputs: 2, Selected: 13}, *report)
+	assert.Len(t, tx.Vout, 1)
+
+	tx, report, err = NewUTXOTransactionWithSelection(from, to.GetStringAddress(), 13, 0,
+		CoinSelection{Strategy: LargestFirstSelection}, bc.UTXODB(), bc)
+	assert.NoError(t, err)
+	assert.Equal(t, CoinSelectionReport{Strategy: LargestFirstSelection, Inputs: 2, Selected: 17, Change: 4}, *report)
+	assert.Len(t, tx.Vout, 2)
+
+	// A change within the cost of change goes to the miner
+	_, report, err = NewUTXOTransactionWithSelection(from, to.GetStringAddress(), 9, 0,
+		CoinSelection{Strategy: SmallestFirstSelection, CostOfChange: 1}, bc.UTXODB(), bc)
+	assert.NoError(t, err)
+	assert.Equal(t, CoinSelectionReport{Strategy: SmallestFirstSelection, Inputs: 2, Selected: 10, Fee: 1}, *report)
+
+	summary := SummarizeCoinSelection([]*CoinSelectionReport{
+		{Strategy: "b", Inputs: 2, Fee: 4}, {Strategy: "a", Inputs: 1}, {Strategy: "b", Inputs: 4},
+	})
+	assert.Equal(t, []CoinSelectionReport{{Strategy: "a", Inputs: 1}, {Strategy: "b", Inputs: 3, Fee: 2}}, summary)
+}
+
+func TestNewUTXOTransactionSignsFromTheSpentOutputs(t *testing.T) {
+	from := NewWallet()
+	address := from.GetStringAddress()
+	bc := CreateBlockchainWithParams(address, testChainParams())
+
+	// The output of a transaction the chain does not know
+	// is spent without looking the transaction up
+	op := NewOutpoint([]byte("unknown"), 1)
+	utxos := UTXOSet{op: UTXO{TXOutput: *NewTXOutput(10, address)}}
+	tx, err := NewUTXOTransactionWithFee(from, NewWallet().GetStringAddress(), 5, 0, utxos, bc)
+	assert.NoError(t, err)
+	prevTXs := make(map[string]*Transaction)
+	addSpentOutput(prevTXs, tx.Vin[0], utxos[op].TXOutput)
+	assert.NoError(t, tx.VerifyInputs(prevTXs))
+}
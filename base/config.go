@@ -1,8 +1,46 @@
 package base
 
-// BlockReward represents the reward given by mining a new block
+// BlockReward represents the reward given by mining a new block,
+// before any halving of the default chain parameters
 const BlockReward = 10
 
 // GenesisCoinbaseData contains the message of the genesis transaction.
 // Historically: https://en.bitcoin.it/wiki/File:Jonny1000thetimes.png
 const GenesisCoinbaseData = "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks"
+
+// TARGETBITS define the initial mining difficulty of a new chain
+const TARGETBITS = 20
+
+// Limits of the difficulty, expressed in leading zero bits of the block hash
+const (
+	MinTargetBits = 1
+	MaxTargetBits = 255
+)
+
+// ChainParams holds the consensus parameters of a blockchain
+type ChainParams struct {
+	TargetBits          int    // difficulty of the genesis block
+	RetargetInterval    int    // number of blocks between difficulty adjustments
+	TargetBlockInterval int64  // desired time between blocks, in seconds
+	MaxRetargetStep     int    // maximum change of target bits per adjustment
+	PoWAlgorithm        string // name of the proof-of-work algorithm
+	MaxBlockSize        int    // maximum size of a serialized block, in bytes
+	InitialReward       int    // subsidy of the first blocks
+	HalvingInterval     int    // number of blocks between halvings of the subsidy, 0 for none
+	CoinbaseMaturity    int    // confirmations before a coinbase output may be spent
+}
+
+// DefaultChainParams returns the parameters used by CreateBlockchain
+func DefaultChainParams() ChainParams {
+	return ChainParams{
+		TargetBits:          TARGETBITS,
+		RetargetInterval:    10,
+		TargetBlockInterval: 10,
+		MaxRetargetStep:     2,
+		PoWAlgorithm:        DefaultPoWAlgorithm,
+		MaxBlockSize:        1 << 20,
+		InitialReward:       BlockReward,
+		HalvingInterval:     0,
+		CoinbaseMaturity:    100,
+	}
+}
@@ -0,0 +1,73 @@
+package base
+
+import (
+	"math"
+)
+
+// TargetBitsAt returns the difficulty that the retarget rule expects
+// for the block at the given height of the best chain. The blocks above
+// the tip are expected at the difficulty of the next block.
+func (bc Blockchain) TargetBitsAt(height int) int {
+	if height <= 0 || len(bc.nodes) == 0 {
+		return bc.params.TargetBits
+	}
+	if height > len(bc.nodes) {
+		height = len(bc.nodes)
+	}
+	return bc.nextTargetBits(bc.nodes[height-1])
+}
+
+// NextTargetBits returns the difficulty expected for the next block
+func (bc Blockchain) NextTargetBits() int {
+	return bc.TargetBitsAt(len(bc.nodes))
+}
+
+// nextTargetBits returns the difficulty expected for a child of prev,
+// following the branch of prev
+func (bc Blockchain) nextTargetBits(prev *blockNode) int {
+	if prev == nil {
+		return bc.params.TargetBits
+	}
+	height := prev.height + 1
+	interval := bc.params.RetargetInterval
+	if interval <= 0 || height%interval != 0 {
+		return prev.targetBits
+	}
+
+	// Measure the time spent on the last interval, starting from the
+	// block right before the window when it exists
+	first := height - interval - 1
+	if first < 0 {
+		first = 0
+	}
+	gaps := int64(height - 1 - first)
+	if gaps < 1 {
+		return prev.targetBits
+	}
+	actual := prev.timestamp - prev.ancestor(first).timestamp
+	expected := gaps * bc.params.TargetBlockInterval
+
+	return bc.params.retarget(prev.targetBits, actual, expected)
+}
+
+// retarget adjusts the target bits by the base-2 logarithm of the ratio
+// between the expected and the observed time of an interval
+func (p ChainParams) retarget(bits int, actual, expected int64) int {
+	if actual < 1 {
+		actual = 1
+	}
+	step := int(math.Round(math.Log2(float64(expected) / float64(actual))))
+	if step > p.MaxRetargetStep {
+		step = p.MaxRetargetStep
+	} else if step < -p.MaxRetargetStep {
+		step = -p.MaxRetargetStep
+	}
+
+	bits += step
+	if bits < MinTargetBits {
+		bits = MinTargetBits
+	} else if bits > MaxTargetBits {
+		bits = MaxTargetBits
+	}
+	return bits
+}
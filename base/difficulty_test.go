@@ -0,0 +1,69 @@
+package base
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func chainWithIntervals(params ChainParams, n int, interval int64) *Blockchain {
+	bc := &Blockchain{params: params}
+	timestamp := int64(1600000000)
+	var parent *blockNode
+	for i := 0; i < n; i++ {
+		block := &Block{Timestamp: timestamp, TargetBits: bc.NextTargetBits()}
+		parent = newBlockNode(block, parent)
+		bc.nodes = append(bc.nodes, parent)
+		timestamp += interval
+	}
+	return bc
+}
+
+func TestTargetBitsAtGenesis(t *testing.T) {
+	params := DefaultChainParams()
+	bc := &Blockchain{params: params}
+
+	assert.Equal(t, params.TargetBits, bc.NextTargetBits())
+}
+
+func TestTargetBitsUnchangedBetweenRetargets(t *testing.T) {
+	params := DefaultChainParams()
+	bc := chainWithIntervals(params, params.RetargetInterval-1, 1)
+
+	assert.Equal(t, params.TargetBits, bc.NextTargetBits())
+}
+
+func TestTargetBitsOnTargetInterval(t *testing.T) {
+	params := DefaultChainParams()
+	bc := chainWithIntervals(params, params.RetargetInterval, params.TargetBlockInterval)
+
+	assert.Equal(t, params.TargetBits, bc.NextTargetBits())
+}
+
+func TestTargetBitsIncreaseWhenBlocksAreFast(t *testing.T) {
+	params := DefaultChainParams()
+	bc := chainWithIntervals(params, params.RetargetInterval, params.TargetBlockInterval/2)
+
+	assert.Equal(t, params.TargetBits+1, bc.NextTargetBits())
+}
+
+func TestTargetBitsDecreaseWhenBlocksAreSlow(t *testing.T) {
+	params := DefaultChainParams()
+	bc := chainWithIntervals(params, params.RetargetInterval, params.TargetBlockInterval*2)
+
+	assert.Equal(t, params.TargetBits-1, bc.NextTargetBits())
+}
+
+func TestTargetBitsStepIsLimited(t *testing.T) {
+	params := DefaultChainParams()
+	bc := chainWithIntervals(params, params.RetargetInterval, 0)
+
+	assert.Equal(t, params.TargetBits+params.MaxRetargetStep, bc.NextTargetBits())
+}
+
+func TestTargetBitsAtAboveTheTip(t *testing.T) {
+	params := DefaultChainParams()
+	bc := chainWithIntervals(params, params.RetargetInterval, params.TargetBlockInterval/2)
+
+	assert.Equal(t, bc.NextTargetBits(), bc.TargetBitsAt(len(bc.nodes)+5))
+}
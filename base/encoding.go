@@ -0,0 +1,490 @@
+package base
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// Versions of the binary encodings
+const (
+	TransactionEncodingVersion = 1
+	BlockEncodingVersion       = 1
+	UTXOEncodingVersion        = 1
+)
+
+// ErrMalformedEncoding is returned when decoding invalid or truncated data
+var ErrMalformedEncoding = errors.New("malformed encoding")
+
+// The binary encodings are built of varints, zigzag encoded when signed,
+// and of byte strings prefixed with their length as an unsigned varint.
+//
+// A transaction is encoded as its version byte, the number of its inputs,
+// each input as its Txid, OutIdx, ScriptSig and Sequence, the number of
+// its outputs, each output as its Value and ScriptPubKey, and its
+// LockTime. The ID is the hash of the encoding, so it is not encoded.
+//
+// A block is encoded as its version byte, its PrevBlockHash, Hash,
+// Timestamp, Nonce, TargetBits and Algorithm, the number of its
+// transactions and each transaction as a byte string.
+//
+// A snapshot of a UTXO database is encoded as its version byte, its Seq
+// and Tip, the number of its outputs, each as its outpoint and unspent
+// output, and the number of its undo records, each as the hash of its
+// block and the record. A record of the journal is encoded as its version
+// byte, its Seq, Hash, Tip and Disconnect flag, a flag followed by its
+// undo record when it has one, and the number of its created outputs,
+// each as its outpoint and unspent output.
+//
+// An outpoint is encoded as its Txid, decoded from hex, and its Index, an
+// unspent output as its TXOutput, Height and Coinbase flag, and an undo
+// record as its Height, the number of its spent outputs, each as its
+// outpoint and unspent output, and the number of its created outpoints.
+
+// encoder appends the fields of an encoding to a buffer
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) uvarint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	e.buf = append(e.buf, tmp[:binary.PutUvarint(tmp[:], v)]...)
+}
+
+func (e *encoder) varint(v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	e.buf = append(e.buf, tmp[:binary.PutVarint(tmp[:], v)]...)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// decoder reads the fields of an encoding, keeping the first error
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) fail(format string, args ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: %s", ErrMalformedEncoding, fmt.Sprintf(format, args...))
+	}
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data) == 0 {
+		d.fail("truncated data")
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.fail("invalid varint")
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.fail("invalid varint")
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+// count reads the number of items of a list, each taking at least
+// minSize bytes, so that a corrupted count cannot allocate much
+func (d *decoder) count(minSize int) int {
+	n := d.uvarint()
+	if d.err == nil && n > uint64(len(d.data)/minSize) {
+		d.fail("%d items in %d bytes", n, len(d.data))
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.count(1)
+	if d.err != nil || n == 0 {
+		return nil
+	}
+	b := append([]byte{}, d.data[:n]...)
+	d.data = d.data[n:]
+	return b
+}
+
+func (e *encoder) bool(b bool) {
+	if b {
+		e.buf = append(e.buf, 1)
+	} else {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (d *decoder) bool() bool {
+	switch b := d.byte(); b {
+	case 0:
+		return false
+	case 1:
+		return true
+	default:
+		d.fail("flag %d", b)
+		return false
+	}
+}
+
+func (d *decoder) finish() error {
+	if d.err == nil && len(d.data) > 0 {
+		d.fail("%d trailing bytes", len(d.data))
+	}
+	return d.err
+}
+
+// uvarintSize returns the size of an unsigned varint
+func uvarintSize(v uint64) int {
+	n := 1
+	for ; v >= 0x80; v >>= 7 {
+		n++
+	}
+	return n
+}
+
+// varintSize returns the size of a zigzag encoded varint
+func varintSize(v int64) int {
+	return uvarintSize(uint64(v<<1) ^ uint64(v>>63))
+}
+
+// bytesSize returns the size of a length-prefixed byte string
+func bytesSize(b []byte) int {
+	return uvarintSize(uint64(len(b))) + len(b)
+}
+
+func (in TXInput) encode(e *encoder) {
+	e.bytes(in.Txid)
+	e.varint(int64(in.OutIdx))
+	e.bytes(in.ScriptSig)
+	e.uvarint(uint64(in.Sequence))
+}
+
+func (in *TXInput) decode(d *decoder) {
+	in.Txid = d.bytes()
+	in.OutIdx = int(d.varint())
+	in.ScriptSig = d.bytes()
+	sequence := d.uvarint()
+	if sequence > SequenceFinal {
+		d.fail("sequence %d", sequence)
+	}
+	in.Sequence = uint32(sequence)
+}
+
+// Size returns the size of the encoding of the input in bytes
+func (in TXInput) Size() int {
+	return bytesSize(in.Txid) + varintSize(int64(in.OutIdx)) + bytesSize(in.ScriptSig) + uvarintSize(uint64(in.Sequence))
+}
+
+func (out TXOutput) encode(e *encoder) {
+	e.varint(int64(out.Value))
+	e.bytes(out.ScriptPubKey)
+}
+
+func (out *TXOutput) decode(d *decoder) {
+	out.Value = int(d.varint())
+	out.ScriptPubKey = d.bytes()
+}
+
+// Size returns the size of the encoding of the output in bytes
+func (out TXOutput) Size() int {
+	return varintSize(int64(out.Value)) + bytesSize(out.ScriptPubKey)
+}
+
+// Minimum sizes of encoded inputs and outputs, those of empty fields
+const (
+	minInputSize  = 4
+	minOutputSize = 2
+)
+
+// DeserializeTransaction decodes a serialized Transaction and sets its ID
+func DeserializeTransaction(data []byte) (*Transaction, error) {
+	d := &decoder{data: data}
+	tx := decodeTransaction(d)
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+func decodeTransaction(d *decoder) *Transaction {
+	tx := &Transaction{}
+	if version := d.byte(); d.err == nil && version != TransactionEncodingVersion {
+		d.fail("transaction version %d", version)
+	}
+	if n := d.count(minInputSize); n > 0 {
+		tx.Vin = make([]TXInput, n)
+		for i := range tx.Vin {
+			tx.Vin[i].decode(d)
+		}
+	}
+	if n := d.count(minOutputSize); n > 0 {
+		tx.Vout = make([]TXOutput, n)
+		for i := range tx.Vout {
+			tx.Vout[i].decode(d)
+		}
+	}
+	tx.LockTime = d.varint()
+	if d.err == nil {
+		tx.ID = tx.Hash()
+	}
+	return tx
+}
+
+// Size returns the size of the serialized Transaction in bytes
+func (tx Transaction) Size() int {
+	size := 1 + uvarintSize(uint64(len(tx.Vin))) + uvarintSize(uint64(len(tx.Vout))) + varintSize(tx.LockTime)
+	for _, in := range tx.Vin {
+		size += in.Size()
+	}
+	for _, out := range tx.Vout {
+		size += out.Size()
+	}
+	return size
+}
+
+func (tx Transaction) encode(e *encoder) {
+	e.buf = append(e.buf, TransactionEncodingVersion)
+	e.uvarint(uint64(len(tx.Vin)))
+	for _, in := range tx.Vin {
+		in.encode(e)
+	}
+	e.uvarint(uint64(len(tx.Vout)))
+	for _, out := range tx.Vout {
+		out.encode(e)
+	}
+	e.varint(tx.LockTime)
+}
+
+func (b *Block) encode(e *encoder) {
+	e.buf = append(e.buf, BlockEncodingVersion)
+	e.bytes(b.PrevBlockHash)
+	e.bytes(b.Hash)
+	e.varint(b.Timestamp)
+	e.varint(int64(b.Nonce))
+	e.varint(int64(b.TargetBits))
+	e.bytes([]byte(b.Algorithm))
+	e.uvarint(uint64(len(b.Transactions)))
+	for _, tx := range b.Transactions {
+		e.bytes(tx.Serialize())
+	}
+}
+
+func decodeBlock(d *decoder) *Block {
+	b := &Block{}
+	if version := d.byte(); d.err == nil && version != BlockEncodingVersion {
+		d.fail("block version %d", version)
+	}
+	b.PrevBlockHash = d.bytes()
+	b.Hash = d.bytes()
+	b.Timestamp = d.varint()
+	b.Nonce = int(d.varint())
+	b.TargetBits = int(d.varint())
+	b.Algorithm = string(d.bytes())
+	if n := d.count(1); n > 0 {
+		b.Transactions = make([]*Transaction, n)
+		for i := range b.Transactions {
+			payload := d.bytes()
+			if d.err != nil {
+				break
+			}
+			tx, err := DeserializeTransaction(payload)
+			if err != nil {
+				d.err = fmt.Errorf("transaction %d: %w", i, err)
+				break
+			}
+			b.Transactions[i] = tx
+		}
+	}
+	return b
+}
+
+// Size returns the size of the serialized block in bytes
+func (b *Block) Size() int {
+	size := 1 + bytesSize(b.PrevBlockHash) + bytesSize(b.Hash) + varintSize(b.Timestamp) +
+		varintSize(int64(b.Nonce)) + varintSize(int64(b.TargetBits)) + bytesSize([]byte(b.Algorithm)) +
+		uvarintSize(uint64(len(b.Transactions)))
+	for _, tx := range b.Transactions {
+		txSize := tx.Size()
+		size += uvarintSize(uint64(txSize)) + txSize
+	}
+	return size
+}
+
+// Minimum sizes of encoded outpoints, unspent outputs and undo records
+const (
+	minOutpointSize = 2
+	minUTXOSize     = minOutputSize + 2
+	minUndoSize     = 3
+)
+
+func (op Outpoint) encode(e *encoder) {
+	txid, _ := hex.DecodeString(op.Txid) // hex encoded by NewOutpoint
+	e.bytes(txid)
+	e.varint(int64(op.Index))
+}
+
+func (op *Outpoint) decode(d *decoder) {
+	op.Txid = hex.EncodeToString(d.bytes())
+	op.Index = int(d.varint())
+}
+
+func (u UTXO) encode(e *encoder) {
+	u.TXOutput.encode(e)
+	e.varint(int64(u.Height))
+	e.bool(u.Coinbase)
+}
+
+func (u *UTXO) decode(d *decoder) {
+	u.TXOutput.decode(d)
+	u.Height = int(d.varint())
+	u.Coinbase = d.bool()
+}
+
+func (undo *BlockUndo) encode(e *encoder) {
+	e.varint(int64(undo.Height))
+	e.uvarint(uint64(len(undo.Spent)))
+	for _, spent := range undo.Spent {
+		spent.Outpoint.encode(e)
+		spent.Output.encode(e)
+	}
+	e.uvarint(uint64(len(undo.Created)))
+	for _, op := range undo.Created {
+		op.encode(e)
+	}
+}
+
+func decodeBlockUndo(d *decoder) *BlockUndo {
+	undo := &BlockUndo{Height: int(d.varint())}
+	if n := d.count(minOutpointSize + minUTXOSize); n > 0 {
+		undo.Spent = make([]SpentOutput, n)
+		for i := range undo.Spent {
+			undo.Spent[i].Outpoint.decode(d)
+			undo.Spent[i].Output.decode(d)
+		}
+	}
+	if n := d.count(minOutpointSize); n > 0 {
+		undo.Created = make([]Outpoint, n)
+		for i := range undo.Created {
+			undo.Created[i].decode(d)
+		}
+	}
+	return undo
+}
+
+func encodeUTXOEntries(e *encoder, entries []utxoEntry) {
+	e.uvarint(uint64(len(entries)))
+	for _, entry := range entries {
+		entry.Outpoint.encode(e)
+		entry.Output.encode(e)
+	}
+}
+
+func decodeUTXOEntries(d *decoder) []utxoEntry {
+	n := d.count(minOutpointSize + minUTXOSize)
+	if n == 0 {
+		return nil
+	}
+	entries := make([]utxoEntry, n)
+	for i := range entries {
+		entries[i].Outpoint.decode(d)
+		entries[i].Output.decode(d)
+	}
+	return entries
+}
+
+// serialize returns the binary encoding of a snapshot
+func (s utxoSnapshot) serialize() []byte {
+	e := &encoder{}
+	e.buf = append(e.buf, UTXOEncodingVersion)
+	e.uvarint(s.Seq)
+	e.bytes(s.Tip)
+	encodeUTXOEntries(e, s.Outputs)
+	e.uvarint(uint64(len(s.Undos)))
+	for key, undo := range s.Undos {
+		hash, _ := hex.DecodeString(key)
+		e.bytes(hash)
+		undo.encode(e)
+	}
+	return e.buf
+}
+
+// deserializeUTXOSnapshot decodes a serialized snapshot
+func deserializeUTXOSnapshot(data []byte) (*utxoSnapshot, error) {
+	d := &decoder{data: data}
+	if version := d.byte(); d.err == nil && version != UTXOEncodingVersion {
+		d.fail("UTXO snapshot version %d", version)
+	}
+	s := &utxoSnapshot{Seq: d.uvarint(), Tip: d.bytes(), Outputs: decodeUTXOEntries(d)}
+	if n := d.count(1 + minUndoSize); n > 0 {
+		s.Undos = make(map[string]*BlockUndo, n)
+		for i := 0; i < n && d.err == nil; i++ {
+			key := hex.EncodeToString(d.bytes())
+			s.Undos[key] = decodeBlockUndo(d)
+		}
+	}
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// serialize returns the binary encoding of a journal record
+func (r utxoRecord) serialize() []byte {
+	e := &encoder{}
+	e.buf = append(e.buf, UTXOEncodingVersion)
+	e.uvarint(r.Seq)
+	e.bytes(r.Hash)
+	e.bytes(r.Tip)
+	e.bool(r.Disconnect)
+	e.bool(r.Undo != nil)
+	if r.Undo != nil {
+		r.Undo.encode(e)
+	}
+	encodeUTXOEntries(e, r.Created)
+	return e.buf
+}
+
+// deserializeUTXORecord decodes a serialized journal record
+func deserializeUTXORecord(data []byte) (*utxoRecord, error) {
+	d := &decoder{data: data}
+	if version := d.byte(); d.err == nil && version != UTXOEncodingVersion {
+		d.fail("UTXO record version %d", version)
+	}
+	r := &utxoRecord{Seq: d.uvarint(), Hash: d.bytes(), Tip: d.bytes(), Disconnect: d.bool()}
+	if d.bool() {
+		r.Undo = decodeBlockUndo(d)
+	}
+	r.Created = decodeUTXOEntries(d)
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
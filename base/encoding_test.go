@@ -0,0 +1,123 @@
+package base
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransactionEncodingRoundTrip(t *testing.T) {
+	from, to := NewWallet(), NewWallet()
+	bc := CreateBlockchainWithParams(from.GetStringAddress(), testChainParams())
+	tx := lockedTransaction(t, bc, from, to.GetStringAddress(), 4, 7, RelativeHeightLock(1))
+
+	data := tx.Serialize()
+	assert.Equal(t, tx.Size(), len(data))
+	for _, in := range tx.Vin {
+		e := &encoder{}
+		in.encode(e)
+		assert.Equal(t, in.Size(), len(e.buf))
+	}
+	for _, out := range tx.Vout {
+		e := &encoder{}
+		out.encode(e)
+		assert.Equal(t, out.Size(), len(e.buf))
+	}
+
+	decoded, err := DeserializeTransaction(data)
+	assert.NoError(t, err)
+	assert.Equal(t, tx.ID, decoded.ID)
+	assert.Equal(t, tx.LockTime, decoded.LockTime)
+	assert.Equal(t, tx.Vin, decoded.Vin)
+	assert.Equal(t, tx.Vout, decoded.Vout)
+	assert.Equal(t, data, decoded.Serialize())
+
+	coinbase := NewCoinbaseTX(from.GetStringAddress(), "")
+	decoded, err = DeserializeTransaction(coinbase.Serialize())
+	assert.NoError(t, err)
+	assert.Equal(t, coinbase.ID, decoded.ID)
+}
+
+func TestBlockEncodingRoundTrip(t *testing.T) {
+	from, to := NewWallet(), NewWallet()
+	address := from.GetStringAddress()
+	bc := CreateBlockchainWithParams(address, testChainParams())
+	tx := lockedTransaction(t, bc, from, to.GetStringAddress(), 4, 0, 0)
+	block, err := bc.AddBlock([]*Transaction{NewCoinbaseTX(address, ""), tx})
+	assert.NoError(t, err)
+
+	data := block.Serialize()
+	assert.Equal(t, block.Size(), len(data))
+	decoded, err := DeserializeBlock(data)
+	assert.NoError(t, err)
+	assert.Equal(t, block.Hash, decoded.Hash)
+	assert.Equal(t, block.PrevBlockHash, decoded.PrevBlockHash)
+	assert.Equal(t, block.Timestamp, decoded.Timestamp)
+	assert.Equal(t, block.Nonce, decoded.Nonce)
+	assert.Equal(t, block.Algorithm, decoded.Algorithm)
+	assert.Len(t, decoded.Transactions, 2)
+	assert.Equal(t, tx.ID, decoded.Transactions[1].ID)
+	assert.Equal(t, data, decoded.Serialize())
+
+	// Blocks sent to the slaves are decoded the same way
+	message := MarshalBlock(*block)
+	assert.Equal(t, "POW", string(message[:3]))
+	decoded, err = DeserializeBlock(message[3:])
+	assert.NoError(t, err)
+	assert.Equal(t, block.Hash, decoded.Hash)
+}
+
+func TestDecodingRejectsMalformedData(t *testing.T) {
+	from := NewWallet()
+	tx := NewCoinbaseTX(from.GetStringAddress(), "")
+	data := tx.Serialize()
+
+	malformed := map[string][]byte{
+		"empty":     {},
+		"truncated": data[:len(data)-1],
+		"trailing":  append(append([]byte{}, data...), 0),
+		"version":   append([]byte{TransactionEncodingVersion + 1}, data[1:]...),
+		"count":     {TransactionEncodingVersion, 0xff, 0xff, 0xff, 0xff, 0x0f},
+	}
+	for name, data := range malformed {
+		_, err := DeserializeTransaction(data)
+		assert.True(t, errors.Is(err, ErrMalformedEncoding), name)
+	}
+
+	block := &Block{Transactions: []*Transaction{tx}, Algorithm: DefaultPoWAlgorithm}
+	data = block.Serialize()
+	_, err := DeserializeBlock(data[:len(data)-1])
+	assert.True(t, errors.Is(err, ErrMalformedEncoding))
+}
+
+func TestUTXOEncodingRoundTrip(t *testing.T) {
+	address := NewWallet().GetStringAddress()
+	spent := NewOutpoint([]byte{1, 2, 3}, 1)
+	created := NewOutpoint([]byte{4, 5, 6}, 0)
+	output := UTXO{TXOutput: *NewTXOutput(10, address), Height: 7, Coinbase: true}
+	undo := &BlockUndo{Height: 8, Spent: []SpentOutput{{Outpoint: spent, Output: output}}, Created: []Outpoint{created}}
+
+	snapshot := utxoSnapshot{
+		Seq:     3,
+		Tip:     []byte{7, 8},
+		Outputs: []utxoEntry{{Outpoint: created, Output: output}},
+		Undos:   map[string]*BlockUndo{"0708": undo},
+	}
+	decoded, err := deserializeUTXOSnapshot(snapshot.serialize())
+	assert.NoError(t, err)
+	assert.Equal(t, snapshot, *decoded)
+
+	record := utxoRecord{Seq: 4, Hash: []byte{9}, Tip: []byte{7, 8}, Undo: undo, Created: snapshot.Outputs}
+	data := record.serialize()
+	decodedRecord, err := deserializeUTXORecord(data)
+	assert.NoError(t, err)
+	assert.Equal(t, record, *decodedRecord)
+
+	_, err = deserializeUTXORecord(data[:len(data)-1])
+	assert.True(t, errors.Is(err, ErrMalformedEncoding))
+	disconnect := utxoRecord{Seq: 5, Hash: []byte{9}, Tip: []byte{7}, Disconnect: true}
+	decodedRecord, err = deserializeUTXORecord(disconnect.serialize())
+	assert.NoError(t, err)
+	assert.Equal(t, disconnect, *decodedRecord)
+}
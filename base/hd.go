@@ -0,0 +1,320 @@
+package base
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// HardenedKeyStart is the index of the first hardened child key, which
+// can only be derived from the private key of its parent
+const HardenedKeyStart = 0x80000000
+
+// Versions of the serialized extended keys, those of BIP32 although
+// the keys are of P-256
+const (
+	ExtendedPrivateVersion = 0x0488ade4
+	ExtendedPublicVersion  = 0x0488b21e
+)
+
+// masterKeySalt is the HMAC key deriving the master key of a seed,
+// following SLIP-10 for P-256
+var masterKeySalt = []byte("Nist256p1 seed")
+
+// Errors returned when deriving and parsing extended keys
+var (
+	ErrInvalidSeed        = errors.New("invalid seed")
+	ErrHardenedFromPublic = errors.New("hardened key derived from a public key")
+	ErrDeriveDepth        = errors.New("maximum derivation depth reached")
+	ErrNotPrivate         = errors.New("extended key is not private")
+	ErrInvalidExtendedKey = errors.New("invalid extended key")
+	ErrInvalidPath        = errors.New("invalid derivation path")
+)
+
+const (
+	minSeedLen        = 16
+	maxSeedLen        = 64
+	chainCodeLen      = 32
+	fingerprintLen    = 4
+	extendedKeyLen    = 78
+	privateScalarLen  = 32
+	extendedKeyLenB58 = extendedKeyLen + addressChecksumLen
+)
+
+// ExtendedKey is a private or public key of a tree of keys derived from
+// a seed, with the chain code deriving its children
+type ExtendedKey struct {
+	key         []byte // private scalar, or compressed public key
+	chainCode   []byte
+	depth       byte
+	fingerprint []byte // of the parent key
+	index       uint32
+	private     bool
+}
+
+// NewMasterKey derives the root of the tree of keys of a seed
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	if len(seed) < minSeedLen || len(seed) > maxSeedLen {
+		return nil, fmt.Errorf("%w: %d bytes", ErrInvalidSeed, len(seed))
+	}
+	sum := hmacSHA512(masterKeySalt, seed)
+	for !validScalar(sum[:privateScalarLen]) {
+		sum = hmacSHA512(masterKeySalt, sum)
+	}
+	return &ExtendedKey{
+		key:         sum[:privateScalarLen],
+		chainCode:   sum[privateScalarLen:],
+		fingerprint: make([]byte, fingerprintLen),
+		private:     true,
+	}, nil
+}
+
+// appendUint32 appends the big endian encoding of v
+func appendUint32(b []byte, v uint32) []byte {
+	var tmp [4]byte
+	binary.BigEndian.PutUint32(tmp[:], v)
+	return append(b, tmp[:]...)
+}
+
+func hmacSHA512(key, data []byte) []byte {
+	mac := hmac.New(sha512.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// validScalar checks if a scalar is a valid private key
+func validScalar(scalar []byte) bool {
+	k := new(big.Int).SetBytes(scalar)
+	return k.Sign() > 0 && k.Cmp(curve.Params().N) < 0
+}
+
+// IsPrivate checks if the extended key holds a private key
+func (k *ExtendedKey) IsPrivate() bool {
+	return k.private
+}
+
+// Depth returns the number of derivations from the master key
+func (k *ExtendedKey) Depth() int {
+	return int(k.depth)
+}
+
+// Index returns the index of the key among the children of its parent
+func (k *ExtendedKey) Index() uint32 {
+	return k.index
+}
+
+// PublicKey returns the compressed public key
+func (k *ExtendedKey) PublicKey() []byte {
+	if !k.private {
+		return k.key
+	}
+	x, y := curve.ScalarBaseMult(k.key)
+	return SerializePubKey(ecdsa.PublicKey{Curve: curve, X: x, Y: y})
+}
+
+// Fingerprint returns the identifier of the key in the serialization
+// of its children
+func (k *ExtendedKey) Fingerprint() []byte {
+	return HashPubKey(k.PublicKey())[:fingerprintLen]
+}
+
+// Address returns the pay-to-pubkey-hash address of the key
+func (k *ExtendedKey) Address() string {
+	return string(encodeAddress(version, HashPubKey(k.PublicKey())))
+}
+
+// Neuter returns the public extended key, which derives
+// the public keys of the non-hardened children only
+func (k *ExtendedKey) Neuter() *ExtendedKey {
+	if !k.private {
+		return k
+	}
+	public := *k
+	public.key = k.PublicKey()
+	public.private = false
+	return &public
+}
+
+// Wallet returns the wallet of the private key
+func (k *ExtendedKey) Wallet() (*Wallet, error) {
+	if !k.private {
+		return nil, ErrNotPrivate
+	}
+	privKey, err := privateKeyFromScalar(k.key)
+	if err != nil {
+		return nil, err
+	}
+	return CreateWallet(privKey, &privKey.PublicKey), nil
+}
+
+// Child derives the child key of the given index, hardened from
+// HardenedKeyStart on. An index deriving an invalid key derives
+// the next candidate as SLIP-10 does.
+func (k *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	if index >= HardenedKeyStart && !k.private {
+		return nil, ErrHardenedFromPublic
+	}
+	if k.depth == 255 {
+		return nil, ErrDeriveDepth
+	}
+
+	var data []byte
+	if index >= HardenedKeyStart {
+		data = append([]byte{0}, k.key...)
+	} else {
+		data = append([]byte{}, k.PublicKey()...)
+	}
+	child := &ExtendedKey{
+		depth:       k.depth + 1,
+		fingerprint: k.Fingerprint(),
+		index:       index,
+		private:     k.private,
+	}
+	for {
+		sum := hmacSHA512(k.chainCode, appendUint32(data, index))
+		tweak, chainCode := sum[:privateScalarLen], sum[privateScalarLen:]
+		if key := k.childKey(tweak); key != nil {
+			child.key, child.chainCode = key, chainCode
+			return child, nil
+		}
+		data = append([]byte{1}, chainCode...)
+	}
+}
+
+// childKey adds the tweak to the key, and returns nil
+// when the tweak or the result is not a valid key
+func (k *ExtendedKey) childKey(tweak []byte) []byte {
+	if !validScalar(tweak) {
+		return nil
+	}
+	if k.private {
+		sum := new(big.Int).Add(new(big.Int).SetBytes(tweak), new(big.Int).SetBytes(k.key))
+		sum.Mod(sum, curve.Params().N)
+		if sum.Sign() == 0 {
+			return nil
+		}
+		return sum.FillBytes(make([]byte, privateScalarLen))
+	}
+	parent, err := ParsePubKey(k.key)
+	if err != nil {
+		return nil
+	}
+	tx, ty := curve.ScalarBaseMult(tweak)
+	x, y := curve.Add(tx, ty, parent.X, parent.Y)
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return nil
+	}
+	return SerializePubKey(ecdsa.PublicKey{Curve: curve, X: x, Y: y})
+}
+
+// Derive derives the key of a path relative to the key
+func (k *ExtendedKey) Derive(path []uint32) (*ExtendedKey, error) {
+	key := k
+	for _, index := range path {
+		var err error
+		if key, err = key.Child(index); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// ParsePath parses a derivation path such as m/44'/1'/0'/0/5,
+// the hardened indexes being marked by ' or h
+func ParsePath(path string) ([]uint32, error) {
+	parts := strings.Split(path, "/")
+	if parts[0] != "m" {
+		return nil, fmt.Errorf("%w: %q does not start with m", ErrInvalidPath, path)
+	}
+	var indexes []uint32
+	for _, part := range parts[1:] {
+		offset := uint32(0)
+		if strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h") {
+			part, offset = part[:len(part)-1], HardenedKeyStart
+		}
+		index, err := strconv.ParseUint(part, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidPath, path)
+		}
+		indexes = append(indexes, uint32(index)+offset)
+	}
+	return indexes, nil
+}
+
+// FormatPath returns the string of a derivation path
+func FormatPath(path []uint32) string {
+	parts := []string{"m"}
+	for _, index := range path {
+		if index >= HardenedKeyStart {
+			parts = append(parts, strconv.FormatUint(uint64(index-HardenedKeyStart), 10)+"'")
+		} else {
+			parts = append(parts, strconv.FormatUint(uint64(index), 10))
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// String returns the Base58 serialization of the extended key,
+// the xprv or xpub string of BIP32
+func (k *ExtendedKey) String() string {
+	payload := make([]byte, 0, extendedKeyLenB58)
+	versionBytes := ExtendedPublicVersion
+	if k.private {
+		versionBytes = ExtendedPrivateVersion
+	}
+	payload = appendUint32(payload, uint32(versionBytes))
+	payload = append(payload, k.depth)
+	payload = append(payload, k.fingerprint...)
+	payload = appendUint32(payload, k.index)
+	payload = append(payload, k.chainCode...)
+	if k.private {
+		payload = append(payload, 0)
+	}
+	payload = append(payload, k.key...)
+	return string(Base58Encode(append(payload, checksum(payload)...)))
+}
+
+// ParseExtendedKey decodes the Base58 serialization of an extended key
+func ParseExtendedKey(s string) (*ExtendedKey, error) {
+	decoded := Base58Decode([]byte(s))
+	if len(decoded) != extendedKeyLenB58 {
+		return nil, fmt.Errorf("%w: %d bytes", ErrInvalidExtendedKey, len(decoded))
+	}
+	payload := decoded[:extendedKeyLen]
+	if !bytes.Equal(checksum(payload), decoded[extendedKeyLen:]) {
+		return nil, fmt.Errorf("%w: bad checksum", ErrInvalidExtendedKey)
+	}
+
+	k := &ExtendedKey{
+		depth:       payload[4],
+		fingerprint: append([]byte{}, payload[5:9]...),
+		index:       binary.BigEndian.Uint32(payload[9:13]),
+		chainCode:   append([]byte{}, payload[13:13+chainCodeLen]...),
+	}
+	key := payload[13+chainCodeLen:]
+	switch binary.BigEndian.Uint32(payload[:4]) {
+	case ExtendedPrivateVersion:
+		if key[0] != 0 || !validScalar(key[1:]) {
+			return nil, fmt.Errorf("%w: private key out of range", ErrInvalidExtendedKey)
+		}
+		k.key, k.private = append([]byte{}, key[1:]...), true
+	case ExtendedPublicVersion:
+		if _, err := ParsePubKey(key); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidExtendedKey, err)
+		}
+		k.key = append([]byte{}, key...)
+	default:
+		return nil, fmt.Errorf("%w: unknown version", ErrInvalidExtendedKey)
+	}
+	if k.depth == 0 && (k.index != 0 || !bytes.Equal(k.fingerprint, make([]byte, fingerprintLen))) {
+		return nil, fmt.Errorf("%w: master key with a parent", ErrInvalidExtendedKey)
+	}
+	return k, nil
+}
@@ -0,0 +1,225 @@
+package base
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMnemonicVectors(t *testing.T) {
+	assert.Len(t, mnemonicWords, 2048)
+	assert.Equal(t, "abandon", mnemonicWords[0])
+	assert.Equal(t, "zoo", mnemonicWords[2047])
+
+	// Test vectors of BIP39, with the passphrase TREZOR
+	vectors := []struct{ entropy, mnemonic, seed string }{
+		{
+			"00000000000000000000000000000000",
+			"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+			"c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04",
+		},
+		{
+			"80808080808080808080808080808080",
+			"letter advice cage absurd amount doctor acoustic avoid letter advice cage above",
+			"d71de856f81a8acc65e6fc851a38d4d7ec216fd0796d0a6827a3ad6ed5511a30fa280f12eb2e47ed2ac03b5c462a0358d18d69fe4f985ec81778c1b370b652a8",
+		},
+		{
+			"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+			"legal winner thank year wave sausage worth useful legal winner thank year wave sausage worth useful legal will",
+			"f2b94508732bcbacbcc020faefecfc89feafa6649a5491b8c952cede496c214a0c7b3c392d168748f2d4a612bada0753b52a1c7ac53c1e93abd5c6320b9e95dd",
+		},
+		{
+			"ffffffffffffffffffffffffffffffffffffffffffffffff",
+			"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo when",
+			"0cd6e5d827bb62eb8fc1e262254223817fd068a74b5b449cc2f667c3f1f985a76379b43348d952e2265b4cd129090758b3e3c2c49103b5051aac2eaeb890a528",
+		},
+	}
+	for _, v := range vectors {
+		entropy, _ := hex.DecodeString(v.entropy)
+		mnemonic, err := NewMnemonic(entropy)
+		assert.NoError(t, err)
+		assert.Equal(t, v.mnemonic, mnemonic)
+		decoded, err := MnemonicEntropy(mnemonic)
+		assert.NoError(t, err)
+		assert.Equal(t, entropy, decoded)
+		seed, err := MnemonicSeed(mnemonic, "TREZOR")
+		assert.NoError(t, err)
+		assert.Equal(t, v.seed, hex.EncodeToString(seed))
+	}
+
+	generated, err := GenerateMnemonic(32)
+	assert.NoError(t, err)
+	assert.Len(t, strings.Fields(generated), 24)
+	_, err = MnemonicEntropy(generated)
+	assert.NoError(t, err)
+
+	for _, invalid := range []string{
+		strings.Repeat("abandon ", 12),
+		strings.Repeat("abandon ", 11) + "bitcoins",
+		strings.Repeat("abandon ", 10) + "about",
+	} {
+		_, err := MnemonicSeed(invalid, "")
+		assert.True(t, errors.Is(err, ErrInvalidMnemonic), invalid)
+	}
+	_, err = NewMnemonic(make([]byte, 15))
+	assert.True(t, errors.Is(err, ErrInvalidMnemonic))
+}
+
+func TestExtendedKeyDerivation(t *testing.T) {
+	// Test vector 1 of SLIP-10 for P-256
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	master, err := NewMasterKey(seed)
+	assert.NoError(t, err)
+	assert.Equal(t, "612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2", hex.EncodeToString(master.key))
+	assert.Equal(t, "beeb672fe4621673f722f38529c07392fecaa61015c80c34f29ce8b41b3cb6ea", hex.EncodeToString(master.chainCode))
+	assert.Equal(t, "0266874dc6ade47b3ecd096745ca09bcd29638dd52c2c12117b11ed3e458cfa9e8", hex.EncodeToString(master.PublicKey()))
+
+	child, err := master.Child(HardenedKeyStart)
+	assert.NoError(t, err)
+	assert.Equal(t, "be6105b5", hex.EncodeToString(child.fingerprint))
+	assert.Equal(t, "6939694369114c67917a182c59ddb8cafc3004e63ca5d3b84403ba8613debc0c", hex.EncodeToString(child.key))
+	assert.Equal(t, "3460cea53e6a6bb5fb391eeef3237ffd8724bf0a40e94943c98b83825342ee11", hex.EncodeToString(child.chainCode))
+	assert.Equal(t, "0384610f5ecffe8fda089363a41f56a5c7ffc1d81b59a612d0d649b2d22355590c", hex.EncodeToString(child.PublicKey()))
+
+	// The public key derives the public keys of the non-hardened children
+	private, err := child.Derive([]uint32{1, 7})
+	assert.NoError(t, err)
+	public, err := child.Neuter().Derive([]uint32{1, 7})
+	assert.NoError(t, err)
+	assert.False(t, public.IsPrivate())
+	assert.Equal(t, private.PublicKey(), public.PublicKey())
+	assert.Equal(t, private.Address(), public.Address())
+	_, err = public.Child(HardenedKeyStart)
+	assert.Equal(t, ErrHardenedFromPublic, err)
+	_, err = public.Wallet()
+	assert.Equal(t, ErrNotPrivate, err)
+	wallet, err := private.Wallet()
+	assert.NoError(t, err)
+	assert.Equal(t, private.Address(), wallet.GetStringAddress())
+
+	_, err = NewMasterKey(seed[:15])
+	assert.True(t, errors.Is(err, ErrInvalidSeed))
+}
+
+func TestExtendedKeySerialization(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	master, err := NewMasterKey(seed)
+	assert.NoError(t, err)
+	key, err := master.Derive([]uint32{HardenedKeyStart + 44, 3})
+	assert.NoError(t, err)
+
+	for _, k := range []*ExtendedKey{master, key, key.Neuter()} {
+		s := k.String()
+		if k.IsPrivate() {
+			assert.True(t, strings.HasPrefix(s, "xprv"), s)
+		} else {
+			assert.True(t, strings.HasPrefix(s, "xpub"), s)
+		}
+		parsed, err := ParseExtendedKey(s)
+		assert.NoError(t, err)
+		assert.Equal(t, k, parsed)
+	}
+
+	s := []byte(key.String())
+	s[len(s)-1]++
+	_, err = ParseExtendedKey(string(s))
+	assert.True(t, errors.Is(err, ErrInvalidExtendedKey))
+	_, err = ParseExtendedKey("xpub")
+	assert.True(t, errors.Is(err, ErrInvalidExtendedKey))
+
+	path, err := ParsePath("m/44'/1h/0'/1/20")
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{HardenedKeyStart + 44, HardenedKeyStart + 1, HardenedKeyStart, 1, 20}, path)
+	assert.Equal(t, "m/44'/1'/0'/1/20", FormatPath(path))
+	for _, invalid := range []string{"", "44'/0", "m/x", "m/2147483648", "m//1"} {
+		_, err := ParsePath(invalid)
+		assert.True(t, errors.Is(err, ErrInvalidPath), invalid)
+	}
+}
+
+func TestHDWalletIsReproducible(t *testing.T) {
+	mnemonic, err := GenerateMnemonic(16)
+	assert.NoError(t, err)
+	w1, err := NewHDWalletFromMnemonic(mnemonic, "", DefaultAccountPath)
+	assert.NoError(t, err)
+	w2, err := NewHDWalletFromMnemonic(mnemonic, "", DefaultAccountPath)
+	assert.NoError(t, err)
+	other, err := NewHDWalletFromMnemonic(mnemonic, "passphrase", DefaultAccountPath)
+	assert.NoError(t, err)
+	xpub, err := ParseExtendedKey(w1.AccountKey().Neuter().String())
+	assert.NoError(t, err)
+	watchOnly, err := NewWatchOnlyHDWallet(xpub)
+	assert.NoError(t, err)
+
+	for _, chain := range []int{ExternalChain, ChangeChain} {
+		for index := uint32(0); index < 3; index++ {
+			wallet1, err := w1.Wallet(chain, index)
+			assert.NoError(t, err)
+			wallet2, err := w2.Wallet(chain, index)
+			assert.NoError(t, err)
+			assert.Equal(t, wallet1.PrivateKey.D, wallet2.PrivateKey.D)
+			address, err := watchOnly.Address(chain, index)
+			assert.NoError(t, err)
+			assert.Equal(t, wallet1.GetStringAddress(), address)
+			address, err = other.Address(chain, index)
+			assert.NoError(t, err)
+			assert.NotEqual(t, wallet1.GetStringAddress(), address)
+		}
+	}
+	_, err = watchOnly.Wallet(ExternalChain, 0)
+	assert.Equal(t, ErrNotPrivate, err)
+	_, err = w1.Address(2, 0)
+	assert.True(t, errors.Is(err, ErrInvalidPath))
+}
+
+func TestHDWalletScanHonoursGapLimit(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	master, err := NewMasterKey(seed)
+	assert.NoError(t, err)
+	w, err := NewHDWallet(master, DefaultAccountPath)
+	assert.NoError(t, err)
+	w.GapLimit = 3
+	assert.Equal(t, "m/44'/1'/0'", FormatPath(w.Path))
+
+	bc := CreateBlockchainWithParams(NewWallet().GetStringAddress(), testChainParams())
+	receive := func(chain int, index uint32) {
+		address, err := w.Address(chain, index)
+		assert.NoError(t, err)
+		_, err = bc.AddBlock([]*Transaction{NewCoinbaseTX(address, "")})
+		assert.NoError(t, err)
+	}
+	// The address 9 is past 3 unused addresses
+	receive(ExternalChain, 0)
+	receive(ExternalChain, 2)
+	receive(ExternalChain, 5)
+	receive(ExternalChain, 9)
+	receive(ChangeChain, 1)
+
+	unspent, err := w.Scan(bc)
+	assert.NoError(t, err)
+	assert.Len(t, unspent, 4)
+	assert.Equal(t, uint32(6), w.NextIndex(ExternalChain))
+	assert.Equal(t, uint32(2), w.NextIndex(ChangeChain))
+	address, err := w.NextAddress(ExternalChain)
+	assert.NoError(t, err)
+	expected, err := w.Address(ExternalChain, 6)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, address)
+
+	// A watch-only wallet finds the same outputs
+	watchOnly, err := NewWatchOnlyHDWallet(w.AccountKey())
+	assert.NoError(t, err)
+	watchOnly.GapLimit = 3
+	found, err := watchOnly.Scan(bc)
+	assert.NoError(t, err)
+	assert.Equal(t, unspent, found)
+
+	w.GapLimit = 4
+	unspent, err = w.Scan(bc)
+	assert.NoError(t, err)
+	assert.Len(t, unspent, 5)
+	assert.Equal(t, uint32(10), w.NextIndex(ExternalChain))
+}
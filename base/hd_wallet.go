@@ -0,0 +1,175 @@
+package base
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// DefaultAccountPath is the derivation path of the first account
+const DefaultAccountPath = "m/44'/1'/0'"
+
+// DefaultGapLimit is the number of unused addresses after which
+// a scan stops deriving the addresses of a chain
+const DefaultGapLimit = 20
+
+// Chains of addresses of an account
+const (
+	ExternalChain = 0 // addresses given out to receive payments
+	ChangeChain   = 1 // addresses receiving the change of the account
+)
+
+// HDWallet derives the addresses of an account from its extended key,
+// the private one or, for a watch-only wallet, the public one
+type HDWallet struct {
+	Path     []uint32 // derivation path of the account, nil when unknown
+	GapLimit int      // unused addresses ending a scan of a chain
+	account  *ExtendedKey
+	chains   [2]*ExtendedKey
+	next     [2]uint32 // index of the first unused address of each chain
+}
+
+// NewHDWallet returns the wallet of the account of the given
+// derivation path from the master key
+func NewHDWallet(master *ExtendedKey, path string) (*HDWallet, error) {
+	indexes, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	account, err := master.Derive(indexes)
+	if err != nil {
+		return nil, err
+	}
+	w, err := newHDWallet(account)
+	if err != nil {
+		return nil, err
+	}
+	w.Path = indexes
+	return w, nil
+}
+
+// NewHDWalletFromMnemonic returns the wallet of the account of the
+// given derivation path from the seed of a mnemonic
+func NewHDWalletFromMnemonic(mnemonic, passphrase, path string) (*HDWallet, error) {
+	seed, err := MnemonicSeed(mnemonic, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+	return NewHDWallet(master, path)
+}
+
+// NewWatchOnlyHDWallet returns the wallet of the public key of an
+// account, which derives its addresses but cannot sign
+func NewWatchOnlyHDWallet(account *ExtendedKey) (*HDWallet, error) {
+	return newHDWallet(account.Neuter())
+}
+
+func newHDWallet(account *ExtendedKey) (*HDWallet, error) {
+	w := &HDWallet{GapLimit: DefaultGapLimit, account: account}
+	for chain := range w.chains {
+		var err error
+		if w.chains[chain], err = account.Child(uint32(chain)); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// AccountKey returns the extended key of the account
+func (w *HDWallet) AccountKey() *ExtendedKey {
+	return w.account
+}
+
+// Key returns the extended key of an address
+func (w *HDWallet) Key(chain int, index uint32) (*ExtendedKey, error) {
+	if chain != ExternalChain && chain != ChangeChain {
+		return nil, fmt.Errorf("%w: chain %d", ErrInvalidPath, chain)
+	}
+	if index >= HardenedKeyStart {
+		return nil, fmt.Errorf("%w: hardened address %d", ErrInvalidPath, index)
+	}
+	return w.chains[chain].Child(index)
+}
+
+// Address returns the address of the given chain and index
+func (w *HDWallet) Address(chain int, index uint32) (string, error) {
+	key, err := w.Key(chain, index)
+	if err != nil {
+		return "", err
+	}
+	return key.Address(), nil
+}
+
+// Wallet returns the wallet of the private key of an address
+func (w *HDWallet) Wallet(chain int, index uint32) (*Wallet, error) {
+	key, err := w.Key(chain, index)
+	if err != nil {
+		return nil, err
+	}
+	return key.Wallet()
+}
+
+// NextAddress returns the first unused address of a chain,
+// which is then considered used
+func (w *HDWallet) NextAddress(chain int) (string, error) {
+	address, err := w.Address(chain, w.next[chain])
+	if err != nil {
+		return "", err
+	}
+	w.next[chain]++
+	return address, nil
+}
+
+// NextIndex returns the index of the first unused address of a chain
+func (w *HDWallet) NextIndex(chain int) uint32 {
+	return w.next[chain]
+}
+
+// Scan looks for the outputs locked to the addresses of the wallet in the
+// main chain. The addresses of each chain are derived in order until
+// GapLimit of them in a row have never received an output, the next
+// addresses starting after the last used one. It returns the unspent
+// outputs of the wallet.
+func (w *HDWallet) Scan(bc *Blockchain) (map[Outpoint]UTXO, error) {
+	received := make(map[string]bool)
+	for height := 0; height <= bc.Height(); height++ {
+		block, err := bc.BlockAt(height)
+		if err != nil {
+			return nil, err
+		}
+		for _, tx := range block.Transactions {
+			for _, out := range tx.Vout {
+				if hash := out.ScriptPubKey.AddressHash(); hash != nil {
+					received[hex.EncodeToString(hash)] = true
+				}
+			}
+		}
+	}
+
+	unspent := make(map[Outpoint]UTXO)
+	for chain := range w.chains {
+		next := uint32(0)
+		for index, gap := uint32(0), 0; gap < w.GapLimit; index++ {
+			key, err := w.Key(chain, index)
+			if err != nil {
+				return nil, err
+			}
+			hash := HashPubKey(key.PublicKey())
+			if !received[hex.EncodeToString(hash)] {
+				gap++
+				continue
+			}
+			next, gap = index+1, 0
+			for op, output := range bc.UTXODB().Outputs(hash) {
+				unspent[op] = output
+			}
+		}
+		if next > w.next[chain] {
+			w.next[chain] = next
+		}
+	}
+	return unspent, nil
+}
@@ -0,0 +1,235 @@
+package base
+
+import (
+	"bytes"
+	"encoding/hex"
+)
+
+// LedgerEntry is a transaction of the history of a wallet
+type LedgerEntry struct {
+	TxID          []byte
+	BlockHash     []byte // nil while the transaction is unconfirmed
+	Height        int    // height of the block, -1 while unconfirmed
+	Timestamp     int64  // timestamp of the block, 0 while unconfirmed
+	Received      int    // value of the outputs paying the wallet
+	Sent          int    // value of the outputs of the wallet it spends
+	Confirmations int    // number of blocks from its block to the tip
+}
+
+// Amount returns the change of the balance of the wallet by the transaction
+func (e LedgerEntry) Amount() int {
+	return e.Received - e.Sent
+}
+
+// LedgerBalance is the balance of a wallet
+type LedgerBalance struct {
+	Confirmed   int // value of the unspent outputs of the main chain
+	Unconfirmed int // change of the balance by the pending transactions
+}
+
+// Total returns the balance once the pending transactions are confirmed
+func (b LedgerBalance) Total() int {
+	return b.Confirmed + b.Unconfirmed
+}
+
+// ledgerBlock records the changes a block of the main chain made to
+// the ledger, to undo them when the block is disconnected
+type ledgerBlock struct {
+	hash    []byte
+	entries []LedgerEntry
+	created []Outpoint
+	spent   map[Outpoint]int // outputs of the wallet it spends, with their value
+}
+
+// Ledger keeps the history of the transactions paying or spending the
+// keys of a wallet. It follows the main chain, applying the blocks as they
+// are connected and rewinding those a reorganization disconnects, and
+// takes the transactions pending in the mempool as unconfirmed.
+type Ledger struct {
+	chain   *Blockchain
+	mempool *Mempool // nil when the pending transactions are ignored
+	keys    map[string]bool
+	blocks  []ledgerBlock    // applied blocks, by height
+	outputs map[Outpoint]int // value of the unspent outputs of the wallet
+	balance int              // value of outputs, kept as they change
+}
+
+// NewLedger returns the ledger of the wallet owning the given public key
+// hashes, scanning the main chain of bc. The mempool may be nil.
+func NewLedger(bc *Blockchain, mp *Mempool, pubKeyHashes ...[]byte) (*Ledger, error) {
+	l := &Ledger{
+		chain:   bc,
+		mempool: mp,
+		keys:    make(map[string]bool),
+		outputs: make(map[Outpoint]int),
+	}
+	for _, hash := range pubKeyHashes {
+		l.keys[hex.EncodeToString(hash)] = true
+	}
+	if err := l.Update(); err != nil {
+		return nil, err
+	}
+	bc.OnConnect(func(*Block) { l.Update() })
+	bc.OnReorg(func(ReorgEvent) { l.Update() })
+	return l, nil
+}
+
+// Update brings the ledger in line with the main chain, rewinding the
+// blocks no longer part of it and applying those connected since. It runs
+// whenever the tip changes; a block that could not be read is applied by
+// the next update.
+func (l *Ledger) Update() error {
+	for len(l.blocks) > 0 && !l.chain.IsMainChain(l.blocks[len(l.blocks)-1].hash) {
+		l.undo()
+	}
+	for height := len(l.blocks); height <= l.chain.Height(); height++ {
+		block, err := l.chain.BlockAt(height)
+		if err != nil {
+			return err
+		}
+		l.apply(block)
+	}
+	return nil
+}
+
+// Height returns the height of the last block applied to the ledger
+func (l *Ledger) Height() int {
+	return len(l.blocks) - 1
+}
+
+// owns checks if an output is locked to a key of the wallet
+func (l *Ledger) owns(out TXOutput) bool {
+	hash := out.ScriptPubKey.AddressHash()
+	return hash != nil && l.keys[hex.EncodeToString(hash)]
+}
+
+// apply records the transactions of the block at the next height
+func (l *Ledger) apply(block *Block) {
+	lb := ledgerBlock{hash: block.Hash, spent: make(map[Outpoint]int)}
+	for _, tx := range block.Transactions {
+		entry := LedgerEntry{TxID: tx.ID, BlockHash: block.Hash, Height: len(l.blocks), Timestamp: block.Timestamp}
+		if !tx.IsCoinbase() {
+			for _, in := range tx.Vin {
+				op := NewOutpoint(in.Txid, in.OutIdx)
+				if value, ok := l.outputs[op]; ok {
+					entry.Sent += value
+					l.balance -= value
+					delete(l.outputs, op)
+					lb.spent[op] = value
+				}
+			}
+		}
+		for idx, out := range tx.Vout {
+			if l.owns(out) {
+				op := NewOutpoint(tx.ID, idx)
+				entry.Received += out.Value
+				l.outputs[op] = out.Value
+				l.balance += out.Value
+				lb.created = append(lb.created, op)
+			}
+		}
+		if entry.Received > 0 || entry.Sent > 0 {
+			lb.entries = append(lb.entries, entry)
+		}
+	}
+	l.blocks = append(l.blocks, lb)
+}
+
+// undo reverts the changes of the last applied block. The spent outputs
+// are restored first, as some may have been created by the block itself.
+func (l *Ledger) undo() {
+	lb := l.blocks[len(l.blocks)-1]
+	for op, value := range lb.spent {
+		l.outputs[op] = value
+		l.balance += value
+	}
+	for _, op := range lb.created {
+		l.balance -= l.outputs[op]
+		delete(l.outputs, op)
+	}
+	l.blocks = l.blocks[:len(l.blocks)-1]
+}
+
+// pending returns the entries of the transactions of the mempool
+// paying or spending the wallet
+func (l *Ledger) pending() []LedgerEntry {
+	if l.mempool == nil {
+		return nil
+	}
+	txs := l.mempool.Transactions()
+	created := make(map[Outpoint]int)
+	for _, tx := range txs {
+		for idx, out := range tx.Vout {
+			if l.owns(out) {
+				created[NewOutpoint(tx.ID, idx)] = out.Value
+			}
+		}
+	}
+
+	var entries []LedgerEntry
+	for _, tx := range txs {
+		entry := LedgerEntry{TxID: tx.ID, Height: -1}
+		for _, in := range tx.Vin {
+			op := NewOutpoint(in.Txid, in.OutIdx)
+			if value, ok := l.outputs[op]; ok {
+				entry.Sent += value
+			} else if value, ok := created[op]; ok {
+				entry.Sent += value
+			}
+		}
+		for _, out := range tx.Vout {
+			if l.owns(out) {
+				entry.Received += out.Value
+			}
+		}
+		if entry.Received > 0 || entry.Sent > 0 {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// History returns the transactions of the wallet, the confirmed ones from
+// the oldest block followed by the pending ones
+func (l *Ledger) History() []LedgerEntry {
+	var history []LedgerEntry
+	for _, lb := range l.blocks {
+		for _, entry := range lb.entries {
+			entry.Confirmations = l.Height() - entry.Height + 1
+			history = append(history, entry)
+		}
+	}
+	return append(history, l.pending()...)
+}
+
+// Transaction returns the entry of a transaction of the wallet
+func (l *Ledger) Transaction(txid []byte) (LedgerEntry, bool) {
+	for _, entry := range l.History() {
+		if bytes.Equal(entry.TxID, txid) {
+			return entry, true
+		}
+	}
+	return LedgerEntry{}, false
+}
+
+// Balance returns the confirmed balance of the wallet and its change
+// by the pending transactions
+func (l *Ledger) Balance() LedgerBalance {
+	balance := LedgerBalance{Confirmed: l.balance}
+	for _, entry := range l.pending() {
+		balance.Unconfirmed += entry.Amount()
+	}
+	return balance
+}
+
+// Totals returns the value received and sent by the confirmed
+// transactions of the wallet
+func (l *Ledger) Totals() (received, sent int) {
+	for _, lb := range l.blocks {
+		for _, entry := range lb.entries {
+			received += entry.Received
+			sent += entry.Sent
+		}
+	}
+	return received, sent
+}
@@ -0,0 +1,135 @@
+package base
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newLedger returns the ledger of a wallet following the chain of mp
+func newLedger(t *testing.T, bc *Blockchain, mp *Mempool, wallet *Wallet) *Ledger {
+	l, err := NewLedger(bc, mp, HashPubKey(wallet.PublicKey))
+	assert.NoError(t, err)
+	return l
+}
+
+func TestLedgerTracksSentAndReceived(t *testing.T) {
+	bc, wallets := fundedWallets(t, 1)
+	mp := NewMempool(bc)
+	from, to, miner := wallets[0], NewWallet(), NewWallet().GetStringAddress()
+	fromLedger := newLedger(t, bc, mp, from)
+	toLedger := newLedger(t, bc, mp, to)
+	reward := bc.GetGenesisBlock().Transactions[0].Vout[0].Value
+
+	assert.Equal(t, LedgerBalance{Confirmed: reward}, fromLedger.Balance())
+	history := fromLedger.History()
+	assert.Len(t, history, 1)
+	assert.Equal(t, 1, history[0].Confirmations)
+	assert.Empty(t, toLedger.History())
+
+	tx, err := NewUTXOTransaction(from, to.GetStringAddress(), 4, bc.UTXOSet(), bc)
+	assert.NoError(t, err)
+	assert.NoError(t, mp.Add(tx))
+	change := 0
+	for _, out := range tx.Vout {
+		if out.IsLockedWithKey(HashPubKey(from.PublicKey)) {
+			change += out.Value
+		}
+	}
+
+	// A pending transaction only changes the unconfirmed balance
+	assert.Equal(t, LedgerBalance{Unconfirmed: 4}, toLedger.Balance())
+	assert.Equal(t, LedgerBalance{Confirmed: reward, Unconfirmed: change - reward}, fromLedger.Balance())
+	entry, ok := toLedger.Transaction(tx.ID)
+	assert.True(t, ok)
+	assert.Equal(t, -1, entry.Height)
+	assert.Nil(t, entry.BlockHash)
+	assert.Equal(t, 0, entry.Confirmations)
+
+	block, err := bc.MineBlock(mp.BlockTemplate(miner, ""))
+	assert.NoError(t, err)
+	assert.Equal(t, LedgerBalance{Confirmed: 4}, toLedger.Balance())
+	assert.Equal(t, LedgerBalance{Confirmed: change}, fromLedger.Balance())
+	entry, ok = fromLedger.Transaction(tx.ID)
+	assert.True(t, ok)
+	assert.Equal(t, block.Hash, entry.BlockHash)
+	assert.Equal(t, 1, entry.Height)
+	assert.Equal(t, reward, entry.Sent)
+	assert.Equal(t, change, entry.Received)
+	assert.Equal(t, change-reward, entry.Amount())
+
+	// Confirmations grow with the chain
+	_, err = bc.AddBlock([]*Transaction{NewCoinbaseTX(miner, "")})
+	assert.NoError(t, err)
+	entry, _ = toLedger.Transaction(tx.ID)
+	assert.Equal(t, 2, entry.Confirmations)
+	assert.Equal(t, bc.Height(), toLedger.Height())
+	received, sent := fromLedger.Totals()
+	assert.Equal(t, reward+change, received)
+	assert.Equal(t, reward, sent)
+}
+
+func TestLedgerRewindsDisconnectedBlocks(t *testing.T) {
+	bc, wallets := fundedWallets(t, 1)
+	mp := NewMempool(bc)
+	from, to, miner := wallets[0], NewWallet(), NewWallet()
+	fromLedger := newLedger(t, bc, mp, from)
+	toLedger := newLedger(t, bc, mp, to)
+	minerLedger := newLedger(t, bc, mp, miner)
+	fork := bc.CurrentBlock()
+
+	tx, err := NewUTXOTransaction(from, to.GetStringAddress(), 4, bc.UTXOSet(), bc)
+	assert.NoError(t, err)
+	assert.NoError(t, mp.Add(tx))
+	_, err = bc.MineBlock(mp.BlockTemplate(miner.GetStringAddress(), ""))
+	assert.NoError(t, err)
+	assert.Equal(t, LedgerBalance{Confirmed: 4}, toLedger.Balance())
+	assert.Len(t, minerLedger.History(), 1)
+
+	// The transaction returns to the mempool when its block is disconnected
+	address := NewWallet().GetStringAddress()
+	b1 := mineOn(fork, address, "b1")
+	assert.NoError(t, bc.ProcessBlock(b1))
+	assert.NoError(t, bc.ProcessBlock(mineOn(b1, address, "b2")))
+	assert.Equal(t, LedgerBalance{Unconfirmed: 4}, toLedger.Balance())
+	assert.Equal(t, LedgerBalance{}, minerLedger.Balance())
+	assert.Empty(t, minerLedger.History())
+	assert.Equal(t, bc.Height(), fromLedger.Height())
+	entry, ok := fromLedger.Transaction(tx.ID)
+	assert.True(t, ok)
+	assert.Equal(t, -1, entry.Height)
+
+	// The rewound ledger matches one scanning the new chain
+	assert.Equal(t, newLedger(t, bc, mp, from).History(), fromLedger.History())
+	assert.Equal(t, newLedger(t, bc, mp, to).Balance(), toLedger.Balance())
+}
+
+func TestLedgerPrunesSpentOutputs(t *testing.T) {
+	bc, wallets := fundedWallets(t, 1)
+	mp := NewMempool(bc)
+	from, to, miner := wallets[0], NewWallet(), NewWallet().GetStringAddress()
+	toLedger := newLedger(t, bc, mp, to)
+	fork := bc.CurrentBlock()
+
+	// The output paying to is created and spent by the same block
+	tx, err := NewUTXOTransaction(from, to.GetStringAddress(), 4, bc.UTXOSet(), bc)
+	assert.NoError(t, err)
+	assert.NoError(t, mp.Add(tx))
+	assert.NoError(t, mp.Add(spendPending(to, tx, from.GetStringAddress(), 4)))
+	_, err = bc.MineBlock(mp.BlockTemplate(miner, ""))
+	assert.NoError(t, err)
+	assert.Equal(t, LedgerBalance{}, toLedger.Balance())
+	assert.Empty(t, toLedger.outputs)
+	received, sent := toLedger.Totals()
+	assert.Equal(t, 4, received)
+	assert.Equal(t, 4, sent)
+
+	// Once the block is disconnected, both transactions are pending again
+	b1 := mineOn(fork, miner, "b1")
+	assert.NoError(t, bc.ProcessBlock(b1))
+	assert.NoError(t, bc.ProcessBlock(mineOn(b1, miner, "b2")))
+	assert.Empty(t, toLedger.outputs)
+	assert.Equal(t, LedgerBalance{}, toLedger.Balance())
+	assert.Equal(t, newLedger(t, bc, mp, to).Balance(), toLedger.Balance())
+	assert.Len(t, toLedger.History(), 2)
+}
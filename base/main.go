@@ -1,77 +1,266 @@
 package base
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 )
 
 var (
 	chain          Blockchain
-	txBuffer       []*Transaction
+	mempool        *Mempool
 	wallet1        *Wallet
 	wallet1Address string
 	wallet2        *Wallet
 	wallet2Address string
-	utxos          UTXOSet
+	ledger1        *Ledger
+	ledger2        *Ledger
+	utxos          *UTXODB
 	verbose        bool
 	slave1         *net.UDPAddr
 	slave2         *net.UDPAddr
 	conn           *net.UDPConn
-	slave1Score    int
-	slave2Score    int
+	reorgs         int
+	slaveHashRates map[int]float64 // last hash rate reported by each slave
+	hashRateLog    [][]int64       // hash rate of each slave at every block
 )
 
 const nRoutines = 6
 const fileName = "data16.csv"
 
+// chainDir is the directory of the experiment chain, which later runs
+// extend instead of starting from a new genesis block, empty for a chain
+// kept in memory and started anew on every run
+var chainDir = "chaindata"
+
+// powAlgorithm is the proof-of-work algorithm of the experiment chain
+var powAlgorithm = DefaultPoWAlgorithm
+
+// targetBits is the difficulty of the genesis block of the experiment chain
+var targetBits = TARGETBITS
+
+// retargetInterval is the number of blocks between difficulty adjustments
+// of the experiment chain, 0 to keep the difficulty of the genesis block so
+// that the measured delays are comparable
+var retargetInterval = 0
+
+// TargetBitsEnv and RetargetIntervalEnv are the environment variables
+// overriding the difficulty and the retarget interval of the experiment
+// chain, so that they can be changed without a rebuild
+const (
+	TargetBitsEnv       = "DAT650_TARGET_BITS"
+	RetargetIntervalEnv = "DAT650_RETARGET_INTERVAL"
+)
+
+// intFromEnv returns the integer of an environment variable,
+// or value when it is not set
+func intFromEnv(name string, value int) int {
+	s := os.Getenv(name)
+	if s == "" {
+		return value
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		panic(fmt.Sprintf("%s: %v", name, err))
+	}
+	return n
+}
+
+// halvingInterval is the number of blocks between halvings
+// of the subsidy of the experiment chain, 0 for none
+var halvingInterval = 0
+
+// feeRate is the fee per 1000 serialized bytes paid by the experiment transactions
+var feeRate = 0
+
+// coinSelection chooses the coins of the experiment transactions,
+// the seed of each transaction being offset by the transactions before it
+var coinSelection = CoinSelection{Strategy: DefaultCoinSelection, Seed: 1}
+
+// selectionReports describes the coins spent by the experiment transactions
+var selectionReports []*CoinSelectionReport
+
 // MainMethod func
 func MainMethod() {
 	resolveAddresses()
+	defer chain.Close()
 	defer printScores()
 	fmt.Println("MainMethod")
 	verbose = false
 	t := [][]int64{} // Time vector
 	t = append(t, runTest1(2000))
-	writeToFile(t)
+	writeToFile(resultFileName(), t)
+	writeToFile("hashrate_"+resultFileName(), hashRateLog)
 }
 
 func printScores() {
-	fmt.Println("ID 0:", slave2Score)
-	fmt.Println("ID 1:", slave1Score)
+	scores, stale := countBlocks()
+	fmt.Println("ID 0:", scores[0], "stale:", stale[0], averageHashRate(0), "H/s")
+	fmt.Println("ID 1:", scores[1], "stale:", stale[1], averageHashRate(1), "H/s")
+	fmt.Println("Reorganizations:", reorgs)
+	if supply, err := chain.Supply(chain.Height()); err == nil {
+		fmt.Println("Supply:", supply, "of", chain.Params().TotalSubsidy(chain.Height()))
+	}
+	for _, average := range SummarizeCoinSelection(selectionReports) {
+		fmt.Println("Coin selection, average of", average)
+	}
+}
+
+// averageHashRate returns the mean hash rate logged for a slave
+func averageHashRate(id int) int64 {
+	if id >= len(hashRateLog) || len(hashRateLog[id]) == 0 {
+		return 0
+	}
+	var sum int64
+	for _, rate := range hashRateLog[id] {
+		sum += rate
+	}
+	return sum / int64(len(hashRateLog[id]))
+}
+
+// walletsFile holds the wallets of the experiment across runs, when the
+// passphrase is given by the WalletPassphraseEnv environment variable
+var walletsFile = DefaultWalletsFile
+
+// WalletMnemonicEnv is the environment variable holding the mnemonic
+// of the experiment wallets, which are then the same on every run
+const WalletMnemonicEnv = "DAT650_WALLET_MNEMONIC"
+
+// loadWallets returns the wallets of the given names: the receiving
+// addresses of the account of the mnemonic when one is given, else the
+// wallets of the wallets file, created on first use, when a passphrase
+// is given. Throwaway wallets are only used with an in-memory chain, as
+// the coins of a persistent chain would be lost with them.
+func loadWallets(names ...string) []*Wallet {
+	var wallets []*Wallet
+	if mnemonic := os.Getenv(WalletMnemonicEnv); mnemonic != "" {
+		hd, err := NewHDWalletFromMnemonic(mnemonic, "", DefaultAccountPath)
+		if err != nil {
+			panic(err.Error())
+		}
+		for i := range names {
+			wallet, err := hd.Wallet(ExternalChain, uint32(i))
+			if err != nil {
+				panic(err.Error())
+			}
+			wallets = append(wallets, wallet)
+		}
+		return wallets
+	}
+
+	passphrase := os.Getenv(WalletPassphraseEnv)
+	if passphrase == "" {
+		if chainDir != "" {
+			panic(fmt.Sprintf("the chain of %s needs persistent wallets, set %s or %s", chainDir, WalletPassphraseEnv, WalletMnemonicEnv))
+		}
+		for range names {
+			wallets = append(wallets, NewWallet())
+		}
+		return wallets
+	}
 
+	ws, err := OpenWallets(walletsFile, passphrase)
+	if os.IsNotExist(err) {
+		ws, err = CreateWallets(walletsFile, passphrase)
+	}
+	if err != nil {
+		panic(err.Error())
+	}
+	for _, name := range names {
+		wallet, err := ws.Get(name)
+		if errors.Is(err, ErrUnknownWallet) {
+			wallet, err = ws.Create(name)
+		}
+		if err != nil {
+			panic(err.Error())
+		}
+		wallets = append(wallets, wallet)
+	}
+	return wallets
 }
 
-// createBlockchain will create new wallets and blockchain
+// createBlockchain will load the wallets and create a new blockchain
 func createBlockchain() {
-	wallet1 = NewWallet()
+	wallets := loadWallets("wallet1", "wallet2")
+	wallet1, wallet2 = wallets[0], wallets[1]
 	wallet1Address = wallet1.GetStringAddress()
-	wallet2 = NewWallet()
 	wallet2Address = wallet2.GetStringAddress()
 
-	chain = *CreateBlockchain(wallet1.GetStringAddress())
-	utxos = chain.FindUTXOSet()
-	txBuffer = []*Transaction{}
+	params := DefaultChainParams()
+	params.PoWAlgorithm = powAlgorithm
+	params.TargetBits = intFromEnv(TargetBitsEnv, targetBits)
+	if !validTargetBits(params.TargetBits) {
+		panic(fmt.Sprintf("%s: target bits %d outside [%d, %d]", TargetBitsEnv, params.TargetBits, MinTargetBits, MaxTargetBits))
+	}
+	params.RetargetInterval = intFromEnv(RetargetIntervalEnv, retargetInterval)
+	params.HalvingInterval = halvingInterval
+	params.MaxBlockSize = MaxMessageBlockSize
+	var bc *Blockchain
+	var err error
+	if chainDir == "" {
+		bc = CreateBlockchainWithParams(wallet1Address, params)
+	} else if bc, err = OpenBlockchainDir(chainDir, wallet1Address, params); err != nil {
+		panic(err.Error())
+	}
+	// A stored chain keeps its parameters, which must be the ones
+	// the results are labelled with
+	if stored := bc.Params(); stored != params {
+		bc.Close()
+		panic(fmt.Sprintf("%s holds a chain of parameters %+v, not %+v", chainDir, stored, params))
+	}
+	chain = *bc
+	utxos = chain.UTXODB()
+	chain.OnReorg(func(event ReorgEvent) {
+		reorgs++
+		if verbose {
+			fmt.Printf("Reorg at fork %x: %d blocks disconnected, %d connected\n", event.Fork, len(event.Disconnected), len(event.Connected))
+		}
+	})
+	mempool = NewMempool(&chain)
+	if ledger1, err = NewLedger(&chain, mempool, HashPubKey(wallet1.PublicKey)); err != nil {
+		panic(err.Error())
+	}
+	if ledger2, err = NewLedger(&chain, mempool, HashPubKey(wallet2.PublicKey)); err != nil {
+		panic(err.Error())
+	}
+	slaveHashRates = make(map[int]float64)
+	hashRateLog = make([][]int64, 2)
 }
 
 func newTransaction(amount int) {
-	tx, err := NewUTXOTransaction(wallet1, wallet2Address, amount, utxos, &chain)
+	selection := coinSelection
+	selection.Seed += int64(len(selectionReports))
+	tx, report, err := NewUTXOTransactionWithSelection(wallet1, wallet2Address, amount, feeRate, selection, utxos, &chain)
 	if err != nil {
 		fmt.Printf("%v\n", err)
 		return
 	}
-	txBuffer = append(txBuffer, tx)
+	selectionReports = append(selectionReports, report)
+	if err := mempool.Add(tx); err != nil {
+		fmt.Printf("%v\n", err)
+	}
 }
 
-// prepareTXs will create a coinbase tx and append the buffer
+// prepareTXs will create a block template from the mempool
 func prepareTXs() []*Transaction {
-	coinbaseTX := NewCoinbaseTX(wallet1Address, GenesisCoinbaseData)
-	return append([]*Transaction{coinbaseTX}, txBuffer...)
+	return mempool.BlockTemplate(wallet1Address, GenesisCoinbaseData)
 }
 
-func writeToFile(result [][]int64) {
-	f, err := os.Create(fileName)
+// resultFileName returns the file of the block delays, which is suffixed
+// with the algorithm name of the chain when it is not the default one
+func resultFileName() string {
+	algorithm := chain.Params().PoWAlgorithm
+	if algorithm == DefaultPoWAlgorithm {
+		return fileName
+	}
+	return strings.TrimSuffix(fileName, ".csv") + "_" + algorithm + ".csv"
+}
+
+func writeToFile(name string, result [][]int64) {
+	f, err := os.Create(name)
 	defer f.Close()
 	if err != nil {
 		fmt.Println(err.Error())
@@ -112,3 +301,34 @@ func resolveAddresses() {
 	slave2 = addr2
 	conn = connection
 }
+
+// ReindexChain rebuilds the UTXO database of the chain stored in dir
+func ReindexChain(dir string) error {
+	store, err := OpenFileBlockStore(dir)
+	if err != nil {
+		return err
+	}
+	bc, err := OpenBlockchain(store)
+	if err != nil {
+		store.Close()
+		return err
+	}
+	defer bc.Close()
+	return bc.ReindexUTXOs()
+}
+
+// CheckChain checks the UTXO database of the chain stored in dir
+// against a replay of the chain
+func CheckChain(dir string) error {
+	store, err := OpenFileBlockStore(dir)
+	if err != nil {
+		return err
+	}
+	bc, err := OpenBlockchain(store)
+	if err != nil {
+		store.Close()
+		return err
+	}
+	defer bc.Close()
+	return bc.CheckUTXOs()
+}
@@ -0,0 +1,312 @@
+package base
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Default limits of a Mempool
+const (
+	DefaultMempoolMaxSize = 1 << 22 // bytes of serialized transactions
+	DefaultMempoolMaxAge  = 24 * time.Hour
+)
+
+// blockTemplateReserve is the part of the block size
+// left to the header and the coinbase of a block template
+const blockTemplateReserve = 1 << 10
+
+// Errors of the transactions rejected by a Mempool
+var (
+	ErrInvalidTransaction = errors.New("invalid transaction")
+	ErrTxInMempool        = errors.New("transaction already in the mempool")
+	ErrTxConflict         = errors.New("transaction spends an output spent by a pending transaction")
+	ErrMempoolFull        = errors.New("mempool is full")
+)
+
+// mempoolEntry is a pending transaction with its fee and size
+type mempoolEntry struct {
+	tx    *Transaction
+	fee   int
+	size  int
+	added time.Time
+}
+
+// hasHigherFeeRate checks if the entry pays more per byte than other,
+// the oldest entry coming first when they pay the same
+func (e *mempoolEntry) hasHigherFeeRate(other *mempoolEntry) bool {
+	a, b := e.fee*other.size, other.fee*e.size
+	if a != b {
+		return a > b
+	}
+	return e.added.Before(other.added)
+}
+
+// Mempool keeps the valid transactions waiting to be mined on the best
+// chain of a Blockchain. A transaction may spend the outputs of the
+// pending transactions, but no two of them may spend the same output.
+type Mempool struct {
+	MaxSize int           // total size of the pending transactions, in bytes
+	MaxAge  time.Duration // how long a transaction may wait to be mined
+
+	chain   *Blockchain
+	entries map[string]*mempoolEntry // by transaction ID
+	spends  map[Outpoint]string      // the transaction spending each outpoint
+	size    int
+}
+
+// NewMempool creates an empty Mempool for the blockchain, which removes
+// the transactions of every block connected to the chain
+func NewMempool(bc *Blockchain) *Mempool {
+	mp := &Mempool{
+		MaxSize: DefaultMempoolMaxSize,
+		MaxAge:  DefaultMempoolMaxAge,
+		chain:   bc,
+		entries: make(map[string]*mempoolEntry),
+		spends:  make(map[Outpoint]string),
+	}
+	bc.OnConnect(mp.removeConfirmed)
+	bc.OnReorg(mp.reorganize)
+	return mp
+}
+
+// Count returns the number of pending transactions
+func (mp *Mempool) Count() int {
+	return len(mp.entries)
+}
+
+// Size returns the total size of the pending transactions in bytes
+func (mp *Mempool) Size() int {
+	return mp.size
+}
+
+// Has checks if the transaction of the given ID is pending
+func (mp *Mempool) Has(ID []byte) bool {
+	_, ok := mp.entries[hex.EncodeToString(ID)]
+	return ok
+}
+
+// Transactions returns the pending transactions by decreasing fee rate
+func (mp *Mempool) Transactions() []*Transaction {
+	entries := mp.sortedEntries()
+	txs := make([]*Transaction, len(entries))
+	for i, entry := range entries {
+		txs[i] = entry.tx
+	}
+	return txs
+}
+
+// Add validates a transaction against the unspent outputs of the chain and
+// the pending transactions, and adds it to the pool.
+// Expired transactions are evicted first, then the transactions paying the
+// lowest fee rate while the pool exceeds its size limit.
+func (mp *Mempool) Add(tx *Transaction) error {
+	return mp.add(tx, time.Now())
+}
+
+func (mp *Mempool) add(tx *Transaction, added time.Time) error {
+	// Expire first, so that tx is not validated against expired parents
+	mp.expire()
+	key := hex.EncodeToString(tx.ID)
+	if _, ok := mp.entries[key]; ok {
+		return ErrTxInMempool
+	}
+	if tx.IsCoinbase() {
+		return fmt.Errorf("%w %x: coinbase outside of a block", ErrInvalidTransaction, tx.ID)
+	}
+	if hash := tx.Hash(); !bytes.Equal(hash, tx.ID) {
+		return fmt.Errorf("%w %x: hash is %x", ErrInvalidTransaction, tx.ID, hash)
+	}
+	size := tx.Size()
+	if limit := mp.chain.params.MaxBlockSize - blockTemplateReserve; size > limit {
+		return fmt.Errorf("%w %x: %d bytes, limit is %d", ErrInvalidTransaction, tx.ID, size, limit)
+	}
+	for _, input := range tx.Vin {
+		if _, ok := mp.spends[NewOutpoint(input.Txid, input.OutIdx)]; ok {
+			return ErrTxConflict
+		}
+	}
+
+	pending := make(map[string]*Transaction, len(mp.entries))
+	for k, entry := range mp.entries {
+		pending[k] = entry.tx
+	}
+	fee, err := mp.chain.checkSpend(tx, len(mp.chain.nodes), mp.chain.utxos.Set(), pending, make(map[Outpoint]bool))
+	if verr, ok := err.(*ValidationError); ok {
+		return fmt.Errorf("%w %x: %s: %s", ErrInvalidTransaction, tx.ID, verr.Rule, verr.Reason)
+	}
+	if err != nil {
+		return err
+	}
+
+	mp.entries[key] = &mempoolEntry{tx: tx, fee: fee, size: size, added: added}
+	for _, input := range tx.Vin {
+		mp.spends[NewOutpoint(input.Txid, input.OutIdx)] = key
+	}
+	mp.size += size
+	mp.evict()
+	if _, ok := mp.entries[key]; !ok {
+		return ErrMempoolFull
+	}
+	return nil
+}
+
+// remove removes a pending transaction, and the ones spending its outputs
+// if withDescendants is set
+func (mp *Mempool) remove(key string, withDescendants bool) {
+	entry, ok := mp.entries[key]
+	if !ok {
+		return
+	}
+	delete(mp.entries, key)
+	mp.size -= entry.size
+	for _, input := range entry.tx.Vin {
+		delete(mp.spends, NewOutpoint(input.Txid, input.OutIdx))
+	}
+	if !withDescendants {
+		return
+	}
+	for i := range entry.tx.Vout {
+		if spender, ok := mp.spends[Outpoint{Txid: key, Index: i}]; ok {
+			mp.remove(spender, true)
+		}
+	}
+}
+
+// expire removes the transactions older than MaxAge
+func (mp *Mempool) expire() {
+	deadline := time.Now().Add(-mp.MaxAge)
+	for key, entry := range mp.entries {
+		if entry.added.Before(deadline) {
+			mp.remove(key, true)
+		}
+	}
+}
+
+// evict removes the transactions paying the lowest fee rate,
+// and those spending their outputs, until the pool fits MaxSize
+func (mp *Mempool) evict() {
+	for mp.size > mp.MaxSize {
+		var lowest string
+		for key, entry := range mp.entries {
+			if lowest == "" || mp.entries[lowest].hasHigherFeeRate(entry) {
+				lowest = key
+			}
+		}
+		mp.remove(lowest, true)
+	}
+}
+
+// sortedEntries returns the pending transactions by decreasing fee rate
+func (mp *Mempool) sortedEntries() []*mempoolEntry {
+	entries := make([]*mempoolEntry, 0, len(mp.entries))
+	for _, entry := range mp.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].hasHigherFeeRate(entries[j]) })
+	return entries
+}
+
+// BlockTemplate returns the transactions of a block extending the best
+// chain: a coinbase paying the subsidy and the fees to address,
+// followed by the pending transactions of the highest fee rates that fit
+// in a block. A transaction always follows the pending ones it spends, and
+// transactions whose locks are not satisfied yet are held back.
+func (mp *Mempool) BlockTemplate(address, data string) []*Transaction {
+	entries := mp.sortedEntries()
+	included := make(map[string]bool)
+	created := make(map[string]*Transaction)
+	height, now := len(mp.chain.nodes), time.Now().Unix()
+	var txs []*Transaction
+	fees, size := 0, 0
+	limit := mp.chain.params.MaxBlockSize - blockTemplateReserve
+	for found := true; found; {
+		found = false
+		for _, entry := range entries {
+			key := hex.EncodeToString(entry.tx.ID)
+			if included[key] || size+entry.size > limit || !mp.parentsIncluded(entry.tx, included) {
+				continue
+			}
+			// Transactions still locked are held back until a later block
+			if mp.chain.checkLocks(entry.tx, height, now, mp.chain.utxos.Set(), created) != nil {
+				continue
+			}
+			included[key] = true
+			created[key] = entry.tx
+			txs = append(txs, entry.tx)
+			fees += entry.fee
+			size += entry.size
+			// Restart from the highest fee rate, as the transactions
+			// spending this one may now be included
+			found = true
+			break
+		}
+	}
+
+	coinbase := NewCoinbaseTXWithReward(address, data, mp.chain.NextSubsidy()+fees)
+	return append([]*Transaction{coinbase}, txs...)
+}
+
+// parentsIncluded checks that every pending transaction spent by tx is included
+func (mp *Mempool) parentsIncluded(tx *Transaction, included map[string]bool) bool {
+	for _, input := range tx.Vin {
+		key := hex.EncodeToString(input.Txid)
+		if _, ok := mp.entries[key]; ok && !included[key] {
+			return false
+		}
+	}
+	return true
+}
+
+// removeConfirmed removes the transactions of a block connected to the
+// chain, and the pending transactions conflicting with them
+func (mp *Mempool) removeConfirmed(block *Block) {
+	for _, tx := range block.Transactions {
+		key := hex.EncodeToString(tx.ID)
+		mp.remove(key, false)
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, input := range tx.Vin {
+			if spender, ok := mp.spends[NewOutpoint(input.Txid, input.OutIdx)]; ok && spender != key {
+				mp.remove(spender, true)
+			}
+		}
+	}
+}
+
+// reorganize returns the transactions of the disconnected blocks to the
+// pool, and validates again the pending ones against the new best chain
+func (mp *Mempool) reorganize(event ReorgEvent) {
+	pending := mp.sortedEntries()
+	sort.SliceStable(pending, func(i, j int) bool { return pending[i].added.Before(pending[j].added) })
+	mp.entries = make(map[string]*mempoolEntry)
+	mp.spends = make(map[Outpoint]string)
+	mp.size = 0
+
+	now := time.Now()
+	for i := len(event.Disconnected) - 1; i >= 0; i-- {
+		for _, tx := range event.Disconnected[i].Transactions {
+			if !tx.IsCoinbase() {
+				mp.add(tx, now)
+			}
+		}
+	}
+	// A transaction spending pending ones can only be added after them,
+	// so retry the failed ones while others are added
+	for len(pending) > 0 {
+		var failed []*mempoolEntry
+		for _, entry := range pending {
+			if err := mp.add(entry.tx, entry.added); err != nil {
+				failed = append(failed, entry)
+			}
+		}
+		if len(failed) == len(pending) {
+			return
+		}
+		pending = failed
+	}
+}
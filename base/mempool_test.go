@@ -0,0 +1,218 @@
+package base
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fundedWallets creates a blockchain where each of the n wallets
+// owns the output of a coinbase
+func fundedWallets(t *testing.T, n int) (*Blockchain, []*Wallet) {
+	wallets := []*Wallet{NewWallet()}
+	bc := CreateBlockchainWithParams(wallets[0].GetStringAddress(), testChainParams())
+	for i := 1; i < n; i++ {
+		wallets = append(wallets, NewWallet())
+		_, err := bc.AddBlock([]*Transaction{NewCoinbaseTX(wallets[i].GetStringAddress(), "")})
+		assert.NoError(t, err)
+	}
+	return bc, wallets
+}
+
+// spendPending creates a transaction spending the first output of parent,
+// which must be owned by wallet
+func spendPending(wallet *Wallet, parent *Transaction, to string, amount int) *Transaction {
+	tx := &Transaction{
+		Vin:  []TXInput{{Txid: parent.ID, OutIdx: 0}},
+		Vout: []TXOutput{*NewTXOutput(amount, to)},
+	}
+	tx.Sign(wallet.PrivateKey, map[string]*Transaction{hex.EncodeToString(parent.ID): parent})
+	tx.ID = tx.Hash()
+	return tx
+}
+
+func TestMempoolRejectsInvalidAndConflictingTransactions(t *testing.T) {
+	bc, wallets := fundedWallets(t, 2)
+	mp := NewMempool(bc)
+	to := wallets[1].GetStringAddress()
+
+	tx1, err := NewUTXOTransaction(wallets[0], to, 4, bc.UTXOSet(), bc)
+	assert.NoError(t, err)
+	tx2, err := NewUTXOTransaction(wallets[0], to, 5, bc.UTXOSet(), bc)
+	assert.NoError(t, err)
+
+	assert.NoError(t, mp.Add(tx1))
+	assert.Equal(t, ErrTxInMempool, mp.Add(tx1))
+	assert.Equal(t, ErrTxConflict, mp.Add(tx2))
+
+	tampered, err := NewUTXOTransaction(wallets[1], to, 4, bc.UTXOSet(), bc)
+	assert.NoError(t, err)
+	tampered.Vout[0].Value = 10
+	tampered.ID = tampered.Hash()
+	assert.True(t, errors.Is(mp.Add(tampered), ErrInvalidTransaction))
+	assert.True(t, errors.Is(mp.Add(NewCoinbaseTX(to, "")), ErrInvalidTransaction))
+	assert.Equal(t, 1, mp.Count())
+	assert.Equal(t, tx1.Size(), mp.Size())
+}
+
+func TestMempoolBlockTemplateOrdersByFeeRate(t *testing.T) {
+	bc, wallets := fundedWallets(t, 3)
+	mp := NewMempool(bc)
+	to := NewWallet().GetStringAddress()
+
+	var txs []*Transaction
+	for i, fee := range []int{1, 3, 2} {
+		tx, err := NewUTXOTransactionWithFee(wallets[i], to, 4, fee, bc.UTXOSet(), bc)
+		assert.NoError(t, err)
+		assert.NoError(t, mp.Add(tx))
+		txs = append(txs, tx)
+	}
+
+	template := mp.BlockTemplate(wallets[0].GetStringAddress(), "")
+	assert.Equal(t, []*Transaction{txs[1], txs[2], txs[0]}, template[1:])
+	assert.Equal(t, BlockReward+6, template[0].Vout[0].Value)
+
+	_, err := bc.MineBlock(template)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, mp.Count())
+	assert.Equal(t, 0, mp.Size())
+}
+
+func TestMempoolBlockTemplateKeepsParentsFirst(t *testing.T) {
+	bc, wallets := fundedWallets(t, 2)
+	mp := NewMempool(bc)
+	from, to := wallets[0], NewWallet()
+
+	parent, err := NewUTXOTransaction(from, to.GetStringAddress(), 4, bc.UTXOSet(), bc)
+	assert.NoError(t, err)
+	other, err := NewUTXOTransactionWithFee(wallets[1], to.GetStringAddress(), 4, 1, bc.UTXOSet(), bc)
+	assert.NoError(t, err)
+	child := spendPending(to, parent, from.GetStringAddress(), 2)
+	assert.True(t, errors.Is(mp.Add(child), ErrInvalidTransaction))
+
+	assert.NoError(t, mp.Add(parent))
+	assert.NoError(t, mp.Add(other))
+	assert.NoError(t, mp.Add(child))
+
+	template := mp.BlockTemplate(from.GetStringAddress(), "")
+	assert.Equal(t, []*Transaction{other, parent, child}, template[1:])
+	_, err = bc.MineBlock(template)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, mp.Count())
+}
+
+func TestMempoolEvictsBySizeAndAge(t *testing.T) {
+	bc, wallets := fundedWallets(t, 3)
+	mp := NewMempool(bc)
+	to := NewWallet().GetStringAddress()
+
+	cheap, err := NewUTXOTransaction(wallets[0], to, 4, bc.UTXOSet(), bc)
+	assert.NoError(t, err)
+	dear, err := NewUTXOTransactionWithFee(wallets[1], to, 4, 2, bc.UTXOSet(), bc)
+	assert.NoError(t, err)
+
+	// DER signatures vary in size, the pool holds either transaction only
+	mp.MaxSize = cheap.Size()
+	if dear.Size() > mp.MaxSize {
+		mp.MaxSize = dear.Size()
+	}
+	assert.NoError(t, mp.Add(cheap))
+	assert.NoError(t, mp.Add(dear))
+	assert.False(t, mp.Has(cheap.ID))
+	assert.True(t, mp.Has(dear.ID))
+	assert.Equal(t, ErrMempoolFull, mp.Add(cheap))
+
+	mp.MaxSize = DefaultMempoolMaxSize
+	mp.MaxAge = time.Millisecond
+	time.Sleep(2 * mp.MaxAge)
+	assert.NoError(t, mp.Add(cheap))
+	assert.False(t, mp.Has(dear.ID))
+	assert.True(t, mp.Has(cheap.ID))
+}
+
+func TestMempoolRejectsChildrenOfExpiredParents(t *testing.T) {
+	bc, wallets := fundedWallets(t, 1)
+	mp := NewMempool(bc)
+	from, to := wallets[0], NewWallet()
+
+	parent, err := NewUTXOTransaction(from, from.GetStringAddress(), 4, bc.UTXOSet(), bc)
+	assert.NoError(t, err)
+	assert.NoError(t, mp.add(parent, time.Now().Add(-2*mp.MaxAge)))
+	child := spendPending(from, parent, to.GetStringAddress(), 4)
+
+	// The parent expires before the child is validated against it
+	assert.True(t, errors.Is(mp.Add(child), ErrInvalidTransaction))
+	assert.False(t, mp.Has(parent.ID))
+	assert.False(t, mp.Has(child.ID))
+	template := mp.BlockTemplate(from.GetStringAddress(), "")
+	assert.Len(t, template, 1)
+	_, err = bc.MineBlock(template)
+	assert.NoError(t, err)
+}
+
+func TestMempoolRemovesConflictsOfConnectedBlocks(t *testing.T) {
+	bc, wallets := fundedWallets(t, 2)
+	mp := NewMempool(bc)
+	from, to := wallets[0], NewWallet()
+
+	pending, err := NewUTXOTransaction(from, to.GetStringAddress(), 4, bc.UTXOSet(), bc)
+	assert.NoError(t, err)
+	assert.NoError(t, mp.Add(pending))
+	child := spendPending(to, pending, from.GetStringAddress(), 4)
+	assert.NoError(t, mp.Add(child))
+
+	confirmed, err := NewUTXOTransaction(from, to.GetStringAddress(), 5, bc.UTXOSet(), bc)
+	assert.NoError(t, err)
+	_, err = bc.AddBlock([]*Transaction{NewCoinbaseTX(from.GetStringAddress(), ""), confirmed})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, mp.Count())
+}
+
+func TestMempoolRestoresTransactionsOfDisconnectedBlocks(t *testing.T) {
+	bc, wallets := fundedWallets(t, 1)
+	mp := NewMempool(bc)
+	from, to := wallets[0], NewWallet()
+	address := from.GetStringAddress()
+	fork := bc.CurrentBlock()
+
+	tx, err := NewUTXOTransaction(from, to.GetStringAddress(), 4, bc.UTXOSet(), bc)
+	assert.NoError(t, err)
+	assert.NoError(t, mp.Add(tx))
+	_, err = bc.MineBlock(mp.BlockTemplate(address, ""))
+	assert.NoError(t, err)
+	assert.False(t, mp.Has(tx.ID))
+
+	b1 := mineOn(fork, address, "b1")
+	assert.NoError(t, bc.ProcessBlock(b1))
+	assert.NoError(t, bc.ProcessBlock(mineOn(b1, address, "b2")))
+	assert.True(t, mp.Has(tx.ID))
+}
+
+func TestMempoolKeepsTransactionsOfFailedReorganizations(t *testing.T) {
+	bc, wallets := fundedWallets(t, 1)
+	mp := NewMempool(bc)
+	from, to := wallets[0], NewWallet()
+	address := from.GetStringAddress()
+	fork := bc.CurrentBlock()
+
+	tx, err := NewUTXOTransaction(from, to.GetStringAddress(), 4, bc.UTXOSet(), bc)
+	assert.NoError(t, err)
+	assert.NoError(t, mp.Add(tx))
+	a1 := mineOn(fork, address, "a1")
+	assert.NoError(t, bc.ProcessBlock(a1))
+
+	// The branch confirms tx in b1, but b2 spends an unknown output
+	b1 := NewBlock([]*Transaction{NewCoinbaseTX(address, "b1"), tx}, fork.Hash, fork.TargetBits, fork.Algorithm)
+	assert.NoError(t, bc.ProcessBlock(b1))
+	phantom := NewCoinbaseTX(address, "phantom")
+	invalid := spendPending(from, phantom, to.GetStringAddress(), 1)
+	b2 := NewBlock([]*Transaction{NewCoinbaseTX(address, "b2"), invalid}, b1.Hash, b1.TargetBits, b1.Algorithm)
+	assertRule(t, RuleMissingInput, bc.ProcessBlock(b2))
+
+	assert.Equal(t, a1.Hash, bc.CurrentBlock().Hash)
+	assert.True(t, mp.Has(tx.ID))
+	assert.Equal(t, 1, mp.Count())
+}
@@ -0,0 +1,159 @@
+package base
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// defaultProgressInterval is used when a Miner has no ProgressInterval
+const defaultProgressInterval = time.Second
+
+// Miner mines blocks on its share of the nonce space
+type Miner struct {
+	ID       int // index of this miner among NSlaves
+	NSlaves  int // number of miners sharing the nonce space
+	Routines int // number of goroutines, nRoutines if zero
+
+	// Progress is called periodically by every goroutine when not nil,
+	// so it must be safe for concurrent use
+	Progress         func(MiningProgress)
+	ProgressInterval time.Duration
+}
+
+// MiningProgress is a snapshot of the work of one mining goroutine
+type MiningProgress struct {
+	Worker   int           // index of the goroutine in the miner
+	Hashes   uint64        // hashes computed by the goroutine
+	Nonce    int           // the nonce being tried
+	Elapsed  time.Duration // time since mining started
+	HashRate float64       // hashes per second of the goroutine
+	// ETA is the expected time for the miner to find a solution,
+	// assuming all its goroutines hash at HashRate
+	ETA time.Duration
+}
+
+// Mine calculates and sets the block hash and nonce.
+// The nonce space is shared by NSlaves miners and each miner splits its share
+// among its goroutines. When the nonce space is exhausted the block is rolled
+// and searched again, so Mine only fails when ctx is done, with
+// ErrMiningCancelled, or when the block cannot be rolled.
+// Mine returns only after all goroutines have stopped.
+func (m Miner) Mine(ctx context.Context, b *Block) (MiningStats, error) {
+	if m.NSlaves < 1 {
+		m.NSlaves = 1
+	}
+	if m.Routines < 1 {
+		m.Routines = nRoutines
+	}
+	if m.ProgressInterval <= 0 {
+		m.ProgressInterval = defaultProgressInterval
+	}
+
+	var stats MiningStats
+	t0 := time.Now()
+	workerHashes := make([]uint64, m.Routines)
+	for {
+		hashes, err := m.mineRound(ctx, b, t0, workerHashes)
+		stats.Hashes += hashes
+		stats.Elapsed = time.Since(t0)
+		if err == nil {
+			return stats, nil
+		}
+		if err != ErrNonceSpaceExhausted || !b.roll() {
+			return stats, err
+		}
+	}
+}
+
+// mineRound searches the nonce space once for the current header and
+// returns the number of hashes computed. workerHashes holds the hashes of
+// each goroutine in the previous rounds and is updated with this one.
+func (m Miner) mineRound(ctx context.Context, b *Block, t0 time.Time, workerHashes []uint64) (uint64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		nh  NonceHash
+		err error
+	}
+	pow := NewProofOfWork(b)
+	if pow.algorithm == nil {
+		return 0, ErrUnknownPoWAlgorithm
+	}
+	if pow.target == nil {
+		return 0, ErrInvalidTargetBits
+	}
+	step := m.Routines * m.NSlaves
+	results := make(chan result, m.Routines)
+	var wg sync.WaitGroup
+	for w := 0; w < m.Routines; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			start := m.ID + w*m.NSlaves
+			nh, err := pow.runRange(ctx, start, step, maxNonce, m.reporter(w, b.TargetBits, t0, workerHashes))
+			results <- result{nh, err}
+		}(w)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	err := ErrNonceSpaceExhausted
+	found := false
+	for r := range results {
+		switch {
+		case r.err == nil && !found:
+			found = true
+			b.Nonce = r.nh.Nonce
+			b.Hash = r.nh.Hash
+			cancel()
+		case r.err == ErrMiningCancelled:
+			err = r.err
+		}
+	}
+	if !found {
+		return pow.Hashes(), err
+	}
+	return pow.Hashes(), nil
+}
+
+// reporter returns the runRange callback of goroutine w, which keeps
+// workerHashes[w] up to date and calls m.Progress every ProgressInterval
+func (m Miner) reporter(w, targetBits int, t0 time.Time, workerHashes []uint64) func(uint64, int) {
+	previous := workerHashes[w]
+	last := time.Now()
+	return func(hashes uint64, nonce int) {
+		workerHashes[w] = previous + hashes
+		if m.Progress == nil || time.Since(last) < m.ProgressInterval {
+			return
+		}
+		last = time.Now()
+
+		progress := MiningProgress{
+			Worker:  w,
+			Hashes:  workerHashes[w],
+			Nonce:   nonce,
+			Elapsed: time.Since(t0),
+		}
+		progress.HashRate = float64(progress.Hashes) / progress.Elapsed.Seconds()
+		progress.ETA = EstimateTimeToSolution(targetBits, progress.HashRate*float64(m.Routines))
+		m.Progress(progress)
+	}
+}
+
+// EstimateTimeToSolution returns the expected time to find a hash with
+// targetBits leading zero bits at the given number of hashes per second
+func EstimateTimeToSolution(targetBits int, hashRate float64) time.Duration {
+	if hashRate <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	seconds := math.Exp2(float64(targetBits)) / hashRate
+	if seconds >= math.MaxInt64/float64(time.Second) {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
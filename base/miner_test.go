@@ -0,0 +1,160 @@
+package base
+
+import (
+	"context"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// unsolvedBlock returns a block whose target is out of reach of a test
+func unsolvedBlock() *Block {
+	coinbase := NewCoinbaseTX(NewWallet().GetStringAddress(), "")
+	return &Block{Timestamp: time.Now().Unix(), Transactions: []*Transaction{coinbase}, TargetBits: 64}
+}
+
+func TestMineStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	block := unsolvedBlock()
+
+	t0 := time.Now()
+	stats, err := Miner{Routines: 4}.Mine(ctx, block)
+	assert.Equal(t, ErrMiningCancelled, err)
+	assert.Error(t, ctx.Err())
+	assert.Less(t, int64(time.Since(t0)), int64(5*time.Second))
+	assert.NotZero(t, stats.Hashes)
+	assert.Nil(t, block.Hash)
+
+	// A context already done stops the miner before the first solution
+	done, cancel := context.WithCancel(context.Background())
+	cancel()
+	block = unsolvedBlock()
+	block.TargetBits = 1
+	_, _, err = block.Mine(done, 0, 1)
+	assert.Equal(t, ErrMiningCancelled, err)
+}
+
+func TestMineFindsASolution(t *testing.T) {
+	block := unsolvedBlock()
+	block.TargetBits = 8
+	stats, err := Miner{ID: 1, NSlaves: 2}.Mine(context.Background(), block)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, block.Nonce%2, "the nonces of the second slave are odd")
+	assert.True(t, NewProofOfWork(block).Validate())
+	assert.NotZero(t, stats.Hashes)
+}
+
+// withMaxNonce bounds the nonce space for the duration of the test
+func withMaxNonce(t *testing.T, n int) {
+	old := maxNonce
+	maxNonce = n
+	t.Cleanup(func() { maxNonce = old })
+}
+
+func TestRunExhaustsNonceSpace(t *testing.T) {
+	withMaxNonce(t, 100)
+	pow := NewProofOfWork(unsolvedBlock())
+
+	_, err := pow.Run(context.Background(), 0, 1)
+	assert.Equal(t, ErrNonceSpaceExhausted, err)
+	assert.Equal(t, uint64(100), pow.Hashes())
+
+	// A block that cannot be rolled fails once its nonces are exhausted
+	block := unsolvedBlock()
+	coinbase := block.Transactions[0]
+	coinbase.Vin[0].ScriptSig = []byte{1}
+	coinbase.ID = coinbase.Hash()
+	block.Timestamp = time.Now().Unix() + 3600
+	_, err = Miner{Routines: 2}.Mine(context.Background(), block)
+	assert.Equal(t, ErrNonceSpaceExhausted, err)
+}
+
+func TestRollChangesTheHeader(t *testing.T) {
+	block := unsolvedBlock()
+	block.Timestamp = 1
+	assert.True(t, block.roll())
+	assert.InDelta(t, time.Now().Unix(), block.Timestamp, 1)
+
+	// Without a later timestamp the extra nonce of the coinbase is rolled
+	block.Timestamp = time.Now().Unix() + 3600
+	extraNonce := block.Transactions[0].ExtraNonce()
+	root := block.HashTransactions()
+	assert.True(t, block.roll())
+	coinbase := block.Transactions[0]
+	assert.Equal(t, extraNonce+1, coinbase.ExtraNonce())
+	assert.Equal(t, coinbase.Hash(), coinbase.ID)
+	assert.NotEqual(t, root, block.HashTransactions())
+}
+
+func TestMineRollsWhenNonceSpaceIsExhausted(t *testing.T) {
+	// Each round tries the single nonce 0, the block timestamp being
+	// in the future so that the extra nonce is rolled
+	withMaxNonce(t, 1)
+	block := unsolvedBlock()
+	block.TargetBits = 4
+	block.Timestamp = time.Now().Unix() + 3600
+	for {
+		if _, err := NewProofOfWork(block).Run(context.Background(), 0, 1); err == ErrNonceSpaceExhausted {
+			break
+		}
+		assert.True(t, block.roll())
+	}
+	extraNonce := block.Transactions[0].ExtraNonce()
+
+	stats, err := Miner{Routines: 1}.Mine(context.Background(), block)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, block.Nonce)
+	assert.True(t, NewProofOfWork(block).Validate())
+	rounds := block.Transactions[0].ExtraNonce() - extraNonce + 1
+	assert.Greater(t, rounds, int64(1))
+	assert.Equal(t, uint64(rounds), stats.Hashes)
+}
+
+func TestMineReportsProgress(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	var mu sync.Mutex
+	var reports []MiningProgress
+	miner := Miner{
+		Routines:         2,
+		ProgressInterval: time.Millisecond,
+		Progress: func(p MiningProgress) {
+			mu.Lock()
+			defer mu.Unlock()
+			reports = append(reports, p)
+		},
+	}
+
+	stats, err := miner.Mine(ctx, unsolvedBlock())
+	assert.Equal(t, ErrMiningCancelled, err)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.NotEmpty(t, reports)
+	last := make(map[int]uint64)
+	total := uint64(0)
+	for _, p := range reports {
+		assert.True(t, p.Worker == 0 || p.Worker == 1, p.Worker)
+		assert.GreaterOrEqual(t, p.Hashes, last[p.Worker], "hashes of a worker only grow")
+		total += p.Hashes - last[p.Worker]
+		last[p.Worker] = p.Hashes
+		assert.Equal(t, p.Worker, p.Nonce%2, "each worker has its own nonces")
+		if p.Hashes > 0 {
+			assert.Greater(t, p.HashRate, 0.0)
+			assert.Equal(t, EstimateTimeToSolution(64, 2*p.HashRate), p.ETA)
+		}
+	}
+	assert.LessOrEqual(t, total, stats.Hashes)
+	assert.Greater(t, stats.HashRate(), 0.0)
+}
+
+func TestEstimateTimeToSolution(t *testing.T) {
+	assert.Equal(t, time.Second, EstimateTimeToSolution(10, 1024))
+	assert.Equal(t, 4*time.Second, EstimateTimeToSolution(12, 1024))
+	assert.Equal(t, 500*time.Millisecond, EstimateTimeToSolution(10, 2048))
+	assert.Equal(t, time.Duration(math.MaxInt64), EstimateTimeToSolution(10, 0))
+	assert.Equal(t, time.Duration(math.MaxInt64), EstimateTimeToSolution(MaxTargetBits, 1e9))
+}
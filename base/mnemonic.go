@@ -0,0 +1,102 @@
+package base
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// ErrInvalidMnemonic is returned for mnemonics of unknown words,
+// of an invalid length or with a wrong checksum
+var ErrInvalidMnemonic = errors.New("invalid mnemonic")
+
+const (
+	mnemonicWordBits   = 11
+	mnemonicIterations = 2048
+	mnemonicSeedLen    = 64
+)
+
+// mnemonicIndexes maps the words of the mnemonics to their index
+var mnemonicIndexes = func() map[string]int {
+	indexes := make(map[string]int, len(mnemonicWords))
+	for i, word := range mnemonicWords {
+		indexes[word] = i
+	}
+	return indexes
+}()
+
+// NewMnemonic encodes entropy of 16 to 32 bytes, a multiple of 4, as the
+// words of a BIP39 mnemonic, its last bits being a checksum
+func NewMnemonic(entropy []byte) (string, error) {
+	if len(entropy) < 16 || len(entropy) > 32 || len(entropy)%4 != 0 {
+		return "", fmt.Errorf("%w: %d bytes of entropy", ErrInvalidMnemonic, len(entropy))
+	}
+	checksumBits := uint(len(entropy) / 4)
+	sum := sha256.Sum256(entropy)
+	bits := new(big.Int).SetBytes(entropy)
+	bits.Lsh(bits, checksumBits)
+	bits.Or(bits, big.NewInt(int64(sum[0]>>(8-checksumBits))))
+
+	n := (len(entropy)*8 + int(checksumBits)) / mnemonicWordBits
+	words := make([]string, n)
+	mask := big.NewInt(1<<mnemonicWordBits - 1)
+	for i := n - 1; i >= 0; i-- {
+		words[i] = mnemonicWords[new(big.Int).And(bits, mask).Int64()]
+		bits.Rsh(bits, mnemonicWordBits)
+	}
+	return strings.Join(words, " "), nil
+}
+
+// GenerateMnemonic returns the mnemonic of random entropy of the given size
+func GenerateMnemonic(entropyBytes int) (string, error) {
+	entropy := make([]byte, entropyBytes)
+	if _, err := io.ReadFull(rand.Reader, entropy); err != nil {
+		return "", err
+	}
+	return NewMnemonic(entropy)
+}
+
+// MnemonicEntropy decodes a mnemonic, checking its words and checksum
+func MnemonicEntropy(mnemonic string) ([]byte, error) {
+	words := strings.Fields(mnemonic)
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, fmt.Errorf("%w: %d words", ErrInvalidMnemonic, len(words))
+	}
+	bits := new(big.Int)
+	for _, word := range words {
+		index, ok := mnemonicIndexes[word]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown word %q", ErrInvalidMnemonic, word)
+		}
+		bits.Lsh(bits, mnemonicWordBits)
+		bits.Or(bits, big.NewInt(int64(index)))
+	}
+
+	checksumBits := uint(len(words) / 3)
+	checksum := new(big.Int).And(bits, big.NewInt(1<<checksumBits-1))
+	bits.Rsh(bits, checksumBits)
+	entropy := bits.FillBytes(make([]byte, len(words)*4/3))
+	sum := sha256.Sum256(entropy)
+	if checksum.Int64() != int64(sum[0]>>(8-checksumBits)) {
+		return nil, fmt.Errorf("%w: wrong checksum", ErrInvalidMnemonic)
+	}
+	return entropy, nil
+}
+
+// MnemonicSeed returns the seed of a mnemonic protected by an optional
+// passphrase, as BIP39 derives it. The words and the passphrase are used
+// as given, without Unicode normalization.
+func MnemonicSeed(mnemonic, passphrase string) ([]byte, error) {
+	if _, err := MnemonicEntropy(mnemonic); err != nil {
+		return nil, err
+	}
+	normalized := strings.Join(strings.Fields(mnemonic), " ")
+	return pbkdf2.Key([]byte(normalized), []byte("mnemonic"+passphrase), mnemonicIterations, mnemonicSeedLen, sha512.New), nil
+}
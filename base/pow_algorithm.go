@@ -0,0 +1,167 @@
+package base
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Names of the available proof-of-work algorithms
+const (
+	SHA256Algorithm       = "sha256"
+	DoubleSHA256Algorithm = "sha256d"
+	ScryptAlgorithm       = "scrypt"
+)
+
+// DefaultPoWAlgorithm is used by chains and blocks that do not choose one
+const DefaultPoWAlgorithm = SHA256Algorithm
+
+// PoWAlgorithm is a hash function used for the proof-of-work
+type PoWAlgorithm interface {
+	// Name identifies the algorithm in blocks and chain parameters
+	Name() string
+	// Hash returns the hash of a serialized header, nonce included
+	Hash(header []byte) []byte
+	// MeetsTarget checks if the hash satisfies the difficulty
+	MeetsTarget(hash []byte, targetBits int) bool
+	// Validate checks if hash is the hash of header and meets the target
+	Validate(header, hash []byte, targetBits int) bool
+}
+
+var powAlgorithms = map[string]PoWAlgorithm{
+	SHA256Algorithm:       sha256PoW{},
+	DoubleSHA256Algorithm: doubleSHA256PoW{},
+	ScryptAlgorithm:       scryptPoW{N: 1024, r: 1, p: 1},
+}
+
+// GetPoWAlgorithm returns the proof-of-work algorithm with the given name,
+// the default algorithm being used for an empty name
+func GetPoWAlgorithm(name string) (PoWAlgorithm, error) {
+	if name == "" {
+		name = DefaultPoWAlgorithm
+	}
+	algo, ok := powAlgorithms[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown proof-of-work algorithm %q", name)
+	}
+	return algo, nil
+}
+
+// validateHash implements PoWAlgorithm.Validate on top of Hash and MeetsTarget
+func validateHash(algo PoWAlgorithm, header, hash []byte, targetBits int) bool {
+	return bytes.Equal(algo.Hash(header), hash) && algo.MeetsTarget(hash, targetBits)
+}
+
+// sha256PoW is a single SHA-256 of the header
+type sha256PoW struct{}
+
+func (sha256PoW) Name() string { return SHA256Algorithm }
+
+func (sha256PoW) Hash(header []byte) []byte {
+	sum := sha256.Sum256(header)
+	return sum[:]
+}
+
+func (sha256PoW) MeetsTarget(hash []byte, targetBits int) bool {
+	return meetsTarget(hash, targetBits)
+}
+
+func (a sha256PoW) Validate(header, hash []byte, targetBits int) bool {
+	return validateHash(a, header, hash, targetBits)
+}
+
+func (sha256PoW) newNonceHasher(header []byte) nonceHasher {
+	return newHeaderHasher(header)
+}
+
+// doubleSHA256PoW is SHA-256 applied twice to the header, as in Bitcoin
+type doubleSHA256PoW struct{}
+
+func (doubleSHA256PoW) Name() string { return DoubleSHA256Algorithm }
+
+func (doubleSHA256PoW) Hash(header []byte) []byte {
+	sum := sha256.Sum256(header)
+	sum = sha256.Sum256(sum[:])
+	return sum[:]
+}
+
+func (doubleSHA256PoW) MeetsTarget(hash []byte, targetBits int) bool {
+	return meetsTarget(hash, targetBits)
+}
+
+func (a doubleSHA256PoW) Validate(header, hash []byte, targetBits int) bool {
+	return validateHash(a, header, hash, targetBits)
+}
+
+func (doubleSHA256PoW) newNonceHasher(header []byte) nonceHasher {
+	return doubleHasher{newHeaderHasher(header)}
+}
+
+// scryptPoW is the memory-hard scrypt function, using the header as
+// both password and salt like Litecoin
+type scryptPoW struct {
+	N, r, p int
+}
+
+func (scryptPoW) Name() string { return ScryptAlgorithm }
+
+func (a scryptPoW) Hash(header []byte) []byte {
+	sum, err := scrypt.Key(header, header, a.N, a.r, a.p, sha256.Size)
+	if err != nil {
+		panic(err.Error())
+	}
+	return sum
+}
+
+func (scryptPoW) MeetsTarget(hash []byte, targetBits int) bool {
+	return meetsTarget(hash, targetBits)
+}
+
+func (a scryptPoW) Validate(header, hash []byte, targetBits int) bool {
+	return validateHash(a, header, hash, targetBits)
+}
+
+// nonceHasher hashes a fixed header for many nonces
+type nonceHasher interface {
+	hash(nonce int, sum *[sha256.Size]byte)
+}
+
+// newNonceHasher returns the fastest nonceHasher available for algo
+func newNonceHasher(algo PoWAlgorithm, header []byte) nonceHasher {
+	if h, ok := algo.(interface {
+		newNonceHasher(header []byte) nonceHasher
+	}); ok {
+		return h.newNonceHasher(header)
+	}
+	return newBufferHasher(algo, header)
+}
+
+// bufferHasher serializes the header once and writes each nonce in place
+type bufferHasher struct {
+	algo   PoWAlgorithm
+	header []byte
+}
+
+func newBufferHasher(algo PoWAlgorithm, header []byte) *bufferHasher {
+	buf := make([]byte, len(header)+nonceLen)
+	copy(buf, header)
+	return &bufferHasher{algo: algo, header: buf}
+}
+
+func (h *bufferHasher) hash(nonce int, sum *[sha256.Size]byte) {
+	binary.BigEndian.PutUint64(h.header[len(h.header)-nonceLen:], uint64(nonce))
+	copy(sum[:], h.algo.Hash(h.header))
+}
+
+// doubleHasher hashes again the result of a midstate hasher
+type doubleHasher struct {
+	inner *headerHasher
+}
+
+func (h doubleHasher) hash(nonce int, sum *[sha256.Size]byte) {
+	h.inner.hash(nonce, sum)
+	*sum = sha256.Sum256(sum[:])
+}
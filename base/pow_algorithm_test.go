@@ -0,0 +1,72 @@
+package base
+
+import (
+	"context"
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNonceHashersMatchAlgorithms(t *testing.T) {
+	block := unsolvedBlock()
+	block.PrevBlockHash = make([]byte, sha256.Size)
+	headers := [][]byte{
+		NewProofOfWork(block).setupHeader(),
+		make([]byte, sha256.BlockSize),
+		make([]byte, 2*sha256.BlockSize-nonceLen),
+		[]byte("short header"),
+	}
+
+	for _, name := range []string{SHA256Algorithm, DoubleSHA256Algorithm, ScryptAlgorithm} {
+		algo, err := GetPoWAlgorithm(name)
+		assert.NoError(t, err)
+		for _, header := range headers {
+			hasher := newNonceHasher(algo, header)
+			for _, nonce := range []int{0, 1, 1 << 20, maxNonce} {
+				var sum [sha256.Size]byte
+				hasher.hash(nonce, &sum)
+				full := addNonce(nonce, append([]byte{}, header...))
+				assert.Equal(t, algo.Hash(full), sum[:], "%s, %d bytes, nonce %d", name, len(header), nonce)
+			}
+		}
+	}
+
+	// The SHA-256 based algorithms hash from the midstate
+	sha, _ := GetPoWAlgorithm(SHA256Algorithm)
+	assert.IsType(t, &headerHasher{}, newNonceHasher(sha, headers[0]))
+	double, _ := GetPoWAlgorithm(DoubleSHA256Algorithm)
+	assert.IsType(t, doubleHasher{}, newNonceHasher(double, headers[0]))
+}
+
+func TestUnknownAlgorithmIsRejected(t *testing.T) {
+	_, err := GetPoWAlgorithm("x11")
+	assert.Error(t, err)
+
+	block := unsolvedBlock()
+	block.Algorithm = "x11"
+	_, _, err = block.Mine(context.Background(), 0, 1)
+	assert.Equal(t, ErrUnknownPoWAlgorithm, err)
+	assert.False(t, NewProofOfWork(block).Validate())
+
+	address := NewWallet().GetStringAddress()
+	bc := CreateBlockchainWithParams(address, testChainParams())
+	tip := bc.CurrentBlock()
+	block = &Block{
+		Timestamp:     time.Now().Unix(),
+		Transactions:  []*Transaction{NewCoinbaseTX(address, "")},
+		PrevBlockHash: tip.Hash,
+		TargetBits:    tip.TargetBits,
+		Algorithm:     "x11",
+		Hash:          make([]byte, sha256.Size),
+	}
+	assertRule(t, RuleAlgorithm, bc.ProcessBlock(block))
+
+	// A valid proof of work of another algorithm is rejected as well
+	block.Algorithm = DoubleSHA256Algorithm
+	_, _, err = block.Mine(context.Background(), 0, 1)
+	assert.NoError(t, err)
+	assert.True(t, NewProofOfWork(block).Validate())
+	assertRule(t, RuleAlgorithm, bc.ProcessBlock(block))
+}
@@ -1,23 +1,50 @@
 package base
 
 import (
-	"bytes"
+	"context"
 	"crypto/sha256"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"hash"
 	"math"
 	"math/big"
+	"sync/atomic"
+	"time"
 )
 
+// maxNonce bounds the nonce like the 32 bit nonce of a Bitcoin header,
+// once it is exhausted the miner must roll the rest of the header
 var (
-	maxNonce = math.MaxInt64
+	maxNonce = math.MaxUint32
 )
 
-// TARGETBITS define the mining difficulty
-const TARGETBITS = 20
+// cancelCheckMask sets how often Run checks if it has been cancelled
+// and publishes the number of hashes it has computed
+const cancelCheckMask = 1<<10 - 1
+
+// nonceLen is the size of the nonce at the end of the header
+const nonceLen = 8
+
+var (
+	// ErrMiningCancelled is returned when mining stops before a solution is found
+	ErrMiningCancelled = errors.New("mining cancelled")
+	// ErrNonceSpaceExhausted is returned when no nonce satisfies the target
+	ErrNonceSpaceExhausted = errors.New("nonce space exhausted")
+	// ErrUnknownPoWAlgorithm is returned when mining a block whose
+	// proof-of-work algorithm is not registered
+	ErrUnknownPoWAlgorithm = errors.New("unknown proof-of-work algorithm")
+	// ErrInvalidTargetBits is returned when mining a block whose target bits
+	// are outside [MinTargetBits, MaxTargetBits]
+	ErrInvalidTargetBits = errors.New("target bits out of range")
+)
 
 // ProofOfWork represents a block mined with a target difficulty
 type ProofOfWork struct {
-	block  *Block
-	target *big.Int // 2 ** (256 - targetbits)
+	hashes    uint64 // hashes computed by Run, updated atomically
+	block     *Block
+	target    *big.Int     // 2 ** (256 - block.TargetBits), nil if out of range
+	algorithm PoWAlgorithm // nil if the block algorithm is unknown
 }
 
 // NonceHash contains a nonce and the hash
@@ -26,12 +53,36 @@ type NonceHash struct {
 	Hash  []byte
 }
 
+// MiningStats summarizes the work done while mining a block
+type MiningStats struct {
+	Hashes  uint64        // hashes computed by all goroutines
+	Elapsed time.Duration // wall-clock time spent mining
+}
+
+// HashRate returns the number of hashes computed per second
+func (s MiningStats) HashRate() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Hashes) / s.Elapsed.Seconds()
+}
+
 // NewProofOfWork builds a ProofOfWork
 func NewProofOfWork(block *Block) *ProofOfWork {
 	// TODO(student)
-	x := *big.NewInt(0)
-	x.SetBit(&x, 256-TARGETBITS, 1)
-	return &ProofOfWork{block, &x}
+	pow := &ProofOfWork{block: block}
+	pow.algorithm, _ = GetPoWAlgorithm(block.Algorithm)
+	if validTargetBits(block.TargetBits) {
+		x := *big.NewInt(0)
+		x.SetBit(&x, 256-block.TargetBits, 1)
+		pow.target = &x
+	}
+	return pow
+}
+
+// validTargetBits checks if a difficulty is within the limits of the chains
+func validTargetBits(targetBits int) bool {
+	return targetBits >= MinTargetBits && targetBits <= MaxTargetBits
 }
 
 // setupHeader prepare the header of the block
@@ -40,8 +91,9 @@ func (pow *ProofOfWork) setupHeader() []byte {
 	var header []byte
 	header = append(header, pow.block.PrevBlockHash...)
 	header = append(header, pow.block.HashTransactions()...)
+	header = append(header, pow.block.HashWitnesses()...)
 	header = append(header, IntToHex(pow.block.Timestamp)...)
-	header = append(header, IntToHex(TARGETBITS)...)
+	header = append(header, IntToHex(int64(pow.block.TargetBits))...)
 
 	return header
 }
@@ -52,44 +104,126 @@ func addNonce(nonce int, header []byte) []byte {
 	return append(header, IntToHex(int64(nonce))...)
 }
 
-// Run performs the proof-of-work
-func (pow *ProofOfWork) Run(start, nRoutines int, notifyChan chan NonceHash) {
-	num := big.NewInt(0)
-	header := pow.setupHeader()
-	for nonce := start; nonce < maxNonce; nonce += nRoutines {
-		sum := sha256.Sum256(addNonce(nonce, header))
-		num.SetBytes(sum[:])
-
-		select {
-		case <-notifyChan:
-			return
-		default:
-			if num.Cmp(pow.target) == -1 {
-				defer func() {
-					if err := recover(); err != nil {
-					}
-					return
-				}()
-				notifyChan <- NonceHash{nonce, sum[:]}
-				return
+// Run performs the proof-of-work over the nonces start, start+step, ...
+// until it finds a solution, runs out of nonces or ctx is done
+func (pow *ProofOfWork) Run(ctx context.Context, start, step int) (NonceHash, error) {
+	return pow.runRange(ctx, start, step, maxNonce, nil)
+}
+
+// runRange performs the proof-of-work over the nonces below end, calling
+// report if not nil with the hashes computed so far and the current nonce
+func (pow *ProofOfWork) runRange(ctx context.Context, start, step, end int, report func(uint64, int)) (NonceHash, error) {
+	if pow.algorithm == nil {
+		return NonceHash{}, ErrUnknownPoWAlgorithm
+	}
+	if pow.target == nil {
+		return NonceHash{}, ErrInvalidTargetBits
+	}
+	hasher := newNonceHasher(pow.algorithm, pow.setupHeader())
+	targetBits := pow.block.TargetBits
+	var sum [sha256.Size]byte
+
+	// hashes are published to pow.hashes in batches
+	hashed, published := 0, 0
+	nonce := start
+	defer func() {
+		atomic.AddUint64(&pow.hashes, uint64(hashed-published))
+		if report != nil {
+			report(uint64(hashed), nonce)
+		}
+	}()
+	for ; nonce < end; nonce += step {
+		if hashed&cancelCheckMask == 0 {
+			atomic.AddUint64(&pow.hashes, uint64(hashed-published))
+			published = hashed
+			if report != nil {
+				report(uint64(hashed), nonce)
+			}
+			select {
+			case <-ctx.Done():
+				return NonceHash{}, ErrMiningCancelled
+			default:
 			}
 		}
+
+		hasher.hash(nonce, &sum)
+		hashed++
+		if pow.algorithm.MeetsTarget(sum[:], targetBits) {
+			return NonceHash{nonce, append([]byte{}, sum[:]...)}, nil
+		}
+		if nonce > end-step {
+			break // nonce+step would overflow
+		}
 	}
-	notifyChan <- NonceHash{0, []byte{}}
-	close(notifyChan)
+	return NonceHash{}, ErrNonceSpaceExhausted
+}
+
+// Hashes returns the number of hashes computed so far by Run
+func (pow *ProofOfWork) Hashes() uint64 {
+	return atomic.LoadUint64(&pow.hashes)
+}
+
+// headerHasher hashes a block header for many nonces. The header is
+// serialized once and the SHA-256 state after its full 64 byte chunks
+// (the midstate) is reused, so only the tail holding the nonce is
+// hashed for each nonce.
+type headerHasher struct {
+	midstate    []byte // marshaled SHA-256 state after the full chunks
+	tail        []byte // remaining header bytes followed by the nonce
+	digest      hash.Hash
+	unmarshaler encoding.BinaryUnmarshaler
+}
+
+func newHeaderHasher(header []byte) *headerHasher {
+	split := len(header) - len(header)%sha256.BlockSize
+	digest := sha256.New()
+	digest.Write(header[:split])
+	midstate, err := digest.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		panic(err.Error())
+	}
+
+	tail := make([]byte, len(header)-split+nonceLen)
+	copy(tail, header[split:])
+	return &headerHasher{
+		midstate:    midstate,
+		tail:        tail,
+		digest:      digest,
+		unmarshaler: digest.(encoding.BinaryUnmarshaler),
+	}
+}
+
+// hash writes the nonce in place and stores the header hash in sum
+func (h *headerHasher) hash(nonce int, sum *[sha256.Size]byte) {
+	binary.BigEndian.PutUint64(h.tail[len(h.tail)-nonceLen:], uint64(nonce))
+	h.unmarshaler.UnmarshalBinary(h.midstate)
+	h.digest.Write(h.tail)
+	h.digest.Sum(sum[:0])
+}
+
+// meetsTarget checks if the hash starts with targetBits zero bits,
+// which is the same as hash < 2 ** (256 - targetBits)
+func meetsTarget(hash []byte, targetBits int) bool {
+	full := targetBits / 8
+	if full >= len(hash) {
+		return false
+	}
+	for _, b := range hash[:full] {
+		if b != 0 {
+			return false
+		}
+	}
+	rem := uint(targetBits % 8)
+	return rem == 0 || hash[full]>>(8-rem) == 0
 }
 
 // Validate validates block's Proof-Of-Work
 // This function just validates if the block header hash
 // is less than the target.
 func (pow *ProofOfWork) Validate() bool {
-	// TODO(student)
-	num := big.NewInt(0)
-	sum := sha256.Sum256(addNonce(pow.block.Nonce, pow.setupHeader()))
-	num.SetBytes(sum[:])
-
-	isSmaller := num.Cmp(pow.target) == -1
-	correctHash := bytes.Compare(sum[:], pow.block.Hash) == 0
-
-	return isSmaller && correctHash
+	if pow.algorithm == nil || pow.target == nil {
+		return false
+	}
+	header := addNonce(pow.block.Nonce, pow.setupHeader())
+	return pow.algorithm.Validate(header, pow.block.Hash, pow.block.TargetBits)
 }
@@ -0,0 +1,445 @@
+package base
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Opcodes of the script language, a subset of the Bitcoin script
+const (
+	Op0             = 0x00 // pushes an empty item
+	OpPushData1     = 0x4c // pushes the data of a 1-byte length
+	OpPushData2     = 0x4d // pushes the data of a 2-byte length
+	Op1             = 0x51 // Op1 to Op16 push the numbers 1 to 16
+	Op16            = 0x60
+	OpVerify        = 0x69
+	OpDup           = 0x76
+	OpEqual         = 0x87
+	OpEqualVerify   = 0x88
+	OpHash160       = 0xa9
+	OpCheckSig      = 0xac
+	OpCheckMultiSig = 0xae
+)
+
+var opNames = map[byte]string{
+	Op0:             "OP_0",
+	OpVerify:        "OP_VERIFY",
+	OpDup:           "OP_DUP",
+	OpEqual:         "OP_EQUAL",
+	OpEqualVerify:   "OP_EQUALVERIFY",
+	OpHash160:       "OP_HASH160",
+	OpCheckSig:      "OP_CHECKSIG",
+	OpCheckMultiSig: "OP_CHECKMULTISIG",
+}
+
+// Limits of the scripts
+const (
+	MaxScriptSize      = 10000 // bytes of a script
+	MaxScriptItemSize  = 520   // bytes of a pushed item
+	MaxMultisigKeys    = 16    // public keys of a multisig script
+	maxScriptStackSize = 1000
+)
+
+// Errors of the script interpreter
+var (
+	ErrInvalidScript = errors.New("invalid script")
+	ErrScriptFailed  = errors.New("script failed")
+)
+
+// Script is a program of the stack-based script language. Outputs are
+// locked with a script that the script of an input spending them must
+// satisfy: the unlocking script runs first, pushing the signatures and
+// keys onto the stack that the locking script then checks.
+type Script []byte
+
+// scriptOp is a parsed instruction of a script
+type scriptOp struct {
+	code byte
+	data []byte // data pushed by a push instruction
+}
+
+// isPush checks if the instruction only pushes data onto the stack
+func (op scriptOp) isPush() bool {
+	return op.code <= OpPushData2 || (op.code >= Op1 && op.code <= Op16)
+}
+
+// smallInt returns the number pushed by Op0 and Op1 to Op16
+func (op scriptOp) smallInt() (int, bool) {
+	if op.code == Op0 {
+		return 0, true
+	}
+	if op.code >= Op1 && op.code <= Op16 {
+		return int(op.code-Op1) + 1, true
+	}
+	return 0, false
+}
+
+// AddOp appends an opcode to the script
+func (s Script) AddOp(code byte) Script {
+	return append(s, code)
+}
+
+// AddInt appends the opcode pushing a number from 0 to 16
+func (s Script) AddInt(n int) Script {
+	if n == 0 {
+		return append(s, Op0)
+	}
+	return append(s, byte(Op1+n-1))
+}
+
+// AddData appends an instruction pushing data onto the stack
+func (s Script) AddData(data []byte) Script {
+	switch {
+	case len(data) < OpPushData1:
+		s = append(s, byte(len(data)))
+	case len(data) <= 0xff:
+		s = append(s, OpPushData1, byte(len(data)))
+	default:
+		var size [2]byte
+		binary.LittleEndian.PutUint16(size[:], uint16(len(data)))
+		s = append(append(s, OpPushData2), size[:]...)
+	}
+	return append(s, data...)
+}
+
+// parse splits the script into instructions
+func (s Script) parse() ([]scriptOp, error) {
+	if len(s) > MaxScriptSize {
+		return nil, fmt.Errorf("%w: script of %d bytes", ErrInvalidScript, len(s))
+	}
+	var ops []scriptOp
+	for i := 0; i < len(s); {
+		code := s[i]
+		i++
+		size := 0
+		switch {
+		case code > Op0 && code < OpPushData1:
+			size = int(code)
+		case code == OpPushData1:
+			if i+1 > len(s) {
+				return nil, fmt.Errorf("%w: truncated push", ErrInvalidScript)
+			}
+			size = int(s[i])
+			i++
+		case code == OpPushData2:
+			if i+2 > len(s) {
+				return nil, fmt.Errorf("%w: truncated push", ErrInvalidScript)
+			}
+			size = int(binary.LittleEndian.Uint16(s[i:]))
+			i += 2
+		}
+		if i+size > len(s) {
+			return nil, fmt.Errorf("%w: truncated push", ErrInvalidScript)
+		}
+		op := scriptOp{code: code}
+		if code < Op1 {
+			op.data = s[i : i+size]
+		}
+		ops = append(ops, op)
+		i += size
+	}
+	return ops, nil
+}
+
+// IsPushOnly checks if the script only pushes data,
+// as unlocking scripts must
+func (s Script) IsPushOnly() bool {
+	ops, err := s.parse()
+	if err != nil {
+		return false
+	}
+	for _, op := range ops {
+		if !op.isPush() {
+			return false
+		}
+	}
+	return true
+}
+
+// PayToPubKeyHashScript returns the script locking an output to the
+// owner of the public key of the given hash
+func PayToPubKeyHashScript(pubKeyHash []byte) Script {
+	return Script{}.AddOp(OpDup).AddOp(OpHash160).AddData(pubKeyHash).AddOp(OpEqualVerify).AddOp(OpCheckSig)
+}
+
+// PayToScriptHashScript returns the script locking an output to a
+// redeem script of the given hash, revealed by the spending input
+func PayToScriptHashScript(scriptHash []byte) Script {
+	return Script{}.AddOp(OpHash160).AddData(scriptHash).AddOp(OpEqual)
+}
+
+// MultisigScript returns the script requiring m signatures
+// of the given public keys
+func MultisigScript(m int, pubKeys [][]byte) (Script, error) {
+	if len(pubKeys) == 0 || len(pubKeys) > MaxMultisigKeys {
+		return nil, fmt.Errorf("%w: multisig of %d keys", ErrInvalidScript, len(pubKeys))
+	}
+	if m < 1 || m > len(pubKeys) {
+		return nil, fmt.Errorf("%w: %d signatures of %d keys", ErrInvalidScript, m, len(pubKeys))
+	}
+	s := Script{}.AddInt(m)
+	for _, pubKey := range pubKeys {
+		s = s.AddData(pubKey)
+	}
+	return s.AddInt(len(pubKeys)).AddOp(OpCheckMultiSig), nil
+}
+
+// PayToPubKeyHashScriptSig returns the script unlocking
+// a pay-to-pubkey-hash output
+func PayToPubKeyHashScriptSig(signature, pubKey []byte) Script {
+	return Script{}.AddData(signature).AddData(pubKey)
+}
+
+// MultisigScriptSig returns the script unlocking a multisig output with
+// signatures in the order of their public keys. For a pay-to-script-hash
+// output, the multisig script is given as redeem script.
+func MultisigScriptSig(signatures [][]byte, redeemScript Script) Script {
+	s := Script{}
+	for _, signature := range signatures {
+		s = s.AddData(signature)
+	}
+	if redeemScript != nil {
+		s = s.AddData(redeemScript)
+	}
+	return s
+}
+
+// IsPayToPubKeyHash checks if the script is a pay-to-pubkey-hash script
+func (s Script) IsPayToPubKeyHash() bool {
+	return len(s) == 25 && s[0] == OpDup && s[1] == OpHash160 && s[2] == 20 &&
+		s[23] == OpEqualVerify && s[24] == OpCheckSig
+}
+
+// IsPayToScriptHash checks if the script is a pay-to-script-hash script
+func (s Script) IsPayToScriptHash() bool {
+	return len(s) == 23 && s[0] == OpHash160 && s[1] == 20 && s[22] == OpEqual
+}
+
+// AddressHash returns the hash encoded by the address of the script, the
+// public key hash of a pay-to-pubkey-hash script or the script hash of
+// a pay-to-script-hash script, and nil for the other scripts
+func (s Script) AddressHash() []byte {
+	switch {
+	case s.IsPayToPubKeyHash():
+		return s[3:23]
+	case s.IsPayToScriptHash():
+		return s[2:22]
+	}
+	return nil
+}
+
+// String disassembles the script
+func (s Script) String() string {
+	ops, err := s.parse()
+	if err != nil {
+		return fmt.Sprintf("[invalid script %x]", []byte(s))
+	}
+	var words []string
+	for _, op := range ops {
+		if n, ok := op.smallInt(); ok && n > 0 {
+			words = append(words, fmt.Sprintf("OP_%d", n))
+		} else if name, ok := opNames[op.code]; ok {
+			words = append(words, name)
+		} else if op.isPush() {
+			words = append(words, hex.EncodeToString(op.data))
+		} else {
+			words = append(words, fmt.Sprintf("OP_UNKNOWN_%x", op.code))
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// SignatureChecker checks the signature of a public key over
+// the spending transaction, subscript being the script that
+// the signature commits to
+type SignatureChecker func(signature, pubKey []byte, subscript Script) bool
+
+// VerifyScript runs the unlocking script of an input, then the locking
+// script of the output it spends. A pay-to-script-hash output also runs
+// the redeem script pushed last by the unlocking script, on the stack
+// left by the other items.
+func VerifyScript(scriptSig, scriptPubKey Script, check SignatureChecker) error {
+	if !scriptSig.IsPushOnly() {
+		return fmt.Errorf("%w: unlocking script is not push only", ErrInvalidScript)
+	}
+	stack, err := execute(scriptSig, nil, check)
+	if err != nil {
+		return err
+	}
+	redeemStack := append([][]byte{}, stack...)
+	if stack, err = execute(scriptPubKey, stack, check); err != nil {
+		return err
+	}
+	if err := checkTrue(stack); err != nil {
+		return err
+	}
+	if !scriptPubKey.IsPayToScriptHash() {
+		return nil
+	}
+
+	// The locking script has checked the hash of the redeem script
+	redeemScript := Script(redeemStack[len(redeemStack)-1])
+	if stack, err = execute(redeemScript, redeemStack[:len(redeemStack)-1], check); err != nil {
+		return err
+	}
+	return checkTrue(stack)
+}
+
+// checkTrue checks that a script left a true value on the stack
+func checkTrue(stack [][]byte) error {
+	if len(stack) == 0 || !isTrue(stack[len(stack)-1]) {
+		return fmt.Errorf("%w: false result", ErrScriptFailed)
+	}
+	return nil
+}
+
+func isTrue(item []byte) bool {
+	for _, b := range item {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func boolItem(b bool) []byte {
+	if b {
+		return []byte{1}
+	}
+	return []byte{}
+}
+
+// execute runs the script on the stack and returns the resulting stack
+func execute(script Script, stack [][]byte, check SignatureChecker) ([][]byte, error) {
+	ops, err := script.parse()
+	if err != nil {
+		return nil, err
+	}
+	pop := func() ([]byte, error) {
+		if len(stack) == 0 {
+			return nil, fmt.Errorf("%w: empty stack", ErrScriptFailed)
+		}
+		item := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return item, nil
+	}
+	popInt := func() (int, error) {
+		item, err := pop()
+		if err != nil {
+			return 0, err
+		}
+		if len(item) > 1 {
+			return 0, fmt.Errorf("%w: number of %d bytes", ErrScriptFailed, len(item))
+		}
+		if len(item) == 0 {
+			return 0, nil
+		}
+		return int(item[0]), nil
+	}
+
+	for _, op := range ops {
+		if n, ok := op.smallInt(); ok && op.code != Op0 {
+			stack = append(stack, []byte{byte(n)})
+		} else if op.isPush() {
+			if len(op.data) > MaxScriptItemSize {
+				return nil, fmt.Errorf("%w: item of %d bytes", ErrInvalidScript, len(op.data))
+			}
+			stack = append(stack, op.data)
+		} else {
+			switch op.code {
+			case OpDup:
+				item, err := pop()
+				if err != nil {
+					return nil, err
+				}
+				stack = append(stack, item, item)
+			case OpHash160:
+				item, err := pop()
+				if err != nil {
+					return nil, err
+				}
+				stack = append(stack, HashPubKey(item))
+			case OpEqual, OpEqualVerify:
+				a, err := pop()
+				if err != nil {
+					return nil, err
+				}
+				b, err := pop()
+				if err != nil {
+					return nil, err
+				}
+				equal := bytes.Equal(a, b)
+				if op.code == OpEqualVerify && !equal {
+					return nil, fmt.Errorf("%w: OP_EQUALVERIFY", ErrScriptFailed)
+				}
+				if op.code == OpEqual {
+					stack = append(stack, boolItem(equal))
+				}
+			case OpVerify:
+				item, err := pop()
+				if err != nil {
+					return nil, err
+				}
+				if !isTrue(item) {
+					return nil, fmt.Errorf("%w: OP_VERIFY", ErrScriptFailed)
+				}
+			case OpCheckSig:
+				pubKey, err := pop()
+				if err != nil {
+					return nil, err
+				}
+				signature, err := pop()
+				if err != nil {
+					return nil, err
+				}
+				stack = append(stack, boolItem(check(signature, pubKey, script)))
+			case OpCheckMultiSig:
+				n, err := popInt()
+				if err != nil {
+					return nil, err
+				}
+				if n < 1 || n > MaxMultisigKeys || n > len(stack) {
+					return nil, fmt.Errorf("%w: multisig of %d keys", ErrScriptFailed, n)
+				}
+				pubKeys := append([][]byte{}, stack[len(stack)-n:]...)
+				stack = stack[:len(stack)-n]
+				m, err := popInt()
+				if err != nil {
+					return nil, err
+				}
+				if m < 1 || m > n || m > len(stack) {
+					return nil, fmt.Errorf("%w: %d signatures of %d keys", ErrScriptFailed, m, n)
+				}
+				signatures := append([][]byte{}, stack[len(stack)-m:]...)
+				stack = stack[:len(stack)-m]
+				stack = append(stack, boolItem(checkMultisig(signatures, pubKeys, script, check)))
+			default:
+				return nil, fmt.Errorf("%w: unknown opcode %x", ErrInvalidScript, op.code)
+			}
+		}
+		if len(stack) > maxScriptStackSize {
+			return nil, fmt.Errorf("%w: stack overflow", ErrScriptFailed)
+		}
+	}
+	return stack, nil
+}
+
+// checkMultisig checks that the signatures match distinct public keys,
+// in the same order
+func checkMultisig(signatures, pubKeys [][]byte, script Script, check SignatureChecker) bool {
+	k := 0
+	for _, signature := range signatures {
+		for k < len(pubKeys) && !check(signature, pubKeys[k], script) {
+			k++
+		}
+		if k == len(pubKeys) {
+			return false
+		}
+		k++
+	}
+	return true
+}
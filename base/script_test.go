@@ -0,0 +1,120 @@
+package base
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// spendScriptOutput creates an unsigned transaction spending
+// the first output of prev to address
+func spendScriptOutput(prev *Transaction, address string) *Transaction {
+	return &Transaction{
+		Vin:  []TXInput{{Txid: prev.ID, OutIdx: 0}},
+		Vout: []TXOutput{*NewTXOutput(prev.Vout[0].Value, address)},
+	}
+}
+
+func TestPayToPubKeyHashScript(t *testing.T) {
+	wallet := NewWallet()
+	pubKeyHash := HashPubKey(wallet.PublicKey)
+	script := NewTXOutput(1, wallet.GetStringAddress()).ScriptPubKey
+
+	assert.True(t, script.IsPayToPubKeyHash())
+	assert.Equal(t, pubKeyHash, script.AddressHash())
+	assert.Equal(t, fmt.Sprintf("OP_DUP OP_HASH160 %x OP_EQUALVERIFY OP_CHECKSIG", pubKeyHash), script.String())
+
+	coinbase := NewCoinbaseTX(wallet.GetStringAddress(), "")
+	prevTXs := map[string]*Transaction{hex.EncodeToString(coinbase.ID): coinbase}
+	tx := spendScriptOutput(coinbase, NewWallet().GetStringAddress())
+	tx.Sign(wallet.PrivateKey, prevTXs)
+	assert.True(t, tx.Vin[0].UsesKey(pubKeyHash))
+	assert.True(t, tx.Verify(prevTXs))
+
+	// Another key does not match the hash
+	other := NewWallet()
+	tx.Vin[0].ScriptSig = PayToPubKeyHashScriptSig(tx.SignInput(0, other.PrivateKey, script), other.PublicKey)
+	assert.True(t, errors.Is(tx.VerifyInputs(prevTXs), ErrScriptFailed))
+}
+
+func TestMultisigScriptNeedsOrderedSignatures(t *testing.T) {
+	wallets := []*Wallet{NewWallet(), NewWallet(), NewWallet()}
+	script, err := MultisigScript(2, [][]byte{wallets[0].PublicKey, wallets[1].PublicKey, wallets[2].PublicKey})
+	assert.NoError(t, err)
+	_, err = MultisigScript(4, [][]byte{wallets[0].PublicKey})
+	assert.True(t, errors.Is(err, ErrInvalidScript))
+
+	funding := &Transaction{Vout: []TXOutput{*NewScriptTXOutput(10, script)}}
+	funding.ID = funding.Hash()
+	prevTXs := map[string]*Transaction{hex.EncodeToString(funding.ID): funding}
+	tx := spendScriptOutput(funding, NewWallet().GetStringAddress())
+	sign := func(ws ...*Wallet) Script {
+		var sigs [][]byte
+		for _, w := range ws {
+			sigs = append(sigs, tx.SignInput(0, w.PrivateKey, script))
+		}
+		return MultisigScriptSig(sigs, nil)
+	}
+
+	tx.Vin[0].ScriptSig = sign(wallets[0], wallets[2])
+	assert.NoError(t, tx.VerifyInputs(prevTXs))
+	tx.Vin[0].ScriptSig = sign(wallets[2], wallets[0])
+	assert.Error(t, tx.VerifyInputs(prevTXs))
+	tx.Vin[0].ScriptSig = sign(wallets[1], wallets[1])
+	assert.Error(t, tx.VerifyInputs(prevTXs))
+	tx.Vin[0].ScriptSig = sign(wallets[1])
+	assert.Error(t, tx.VerifyInputs(prevTXs))
+}
+
+func TestPayToScriptHashMultisigSpend(t *testing.T) {
+	from, to := NewWallet(), NewWallet()
+	signers := []*Wallet{NewWallet(), NewWallet(), NewWallet()}
+	address, redeemScript, err := NewMultisigAddress(2, signers...)
+	assert.NoError(t, err)
+	assert.True(t, ValidateAddress(address))
+
+	bc := CreateBlockchainWithParams(from.GetStringAddress(), testChainParams())
+	funding, err := NewUTXOTransactionWithFee(from, address, BlockReward, 0, bc.UTXOSet(), bc)
+	assert.NoError(t, err)
+	assert.True(t, funding.Vout[0].ScriptPubKey.IsPayToScriptHash())
+	_, err = bc.AddBlock([]*Transaction{NewCoinbaseTX(from.GetStringAddress(), ""), funding})
+	assert.NoError(t, err)
+	assert.Equal(t, BlockReward, bc.UTXODB().Balance(GetPubKeyHashFromAddress(address)))
+
+	tx := spendScriptOutput(funding, to.GetStringAddress())
+	sigs := [][]byte{
+		tx.SignInput(0, signers[0].PrivateKey, redeemScript),
+		tx.SignInput(0, signers[1].PrivateKey, redeemScript),
+	}
+
+	// A redeem script not matching the hash of the address is rejected
+	other, err := MultisigScript(1, [][]byte{signers[0].PublicKey})
+	assert.NoError(t, err)
+	tx.Vin[0].ScriptSig = MultisigScriptSig(sigs[:1], other)
+	tx.ID = tx.Hash()
+	_, err = bc.AddBlock([]*Transaction{NewCoinbaseTX(from.GetStringAddress(), ""), tx})
+	assertRule(t, RuleSignature, err)
+
+	tx.Vin[0].ScriptSig = MultisigScriptSig(sigs, redeemScript)
+	tx.ID = tx.Hash()
+	_, err = bc.AddBlock([]*Transaction{NewCoinbaseTX(from.GetStringAddress(), ""), tx})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, bc.UTXODB().Balance(GetPubKeyHashFromAddress(address)))
+	assert.Equal(t, BlockReward, bc.UTXODB().Balance(HashPubKey(to.PublicKey)))
+}
+
+func TestScriptRejectsMalformedScripts(t *testing.T) {
+	check := func(sig, pubKey []byte, subscript Script) bool { return true }
+	truncated := Script{OpPushData1, 5, 1, 2}
+	assert.False(t, truncated.IsPushOnly())
+	assert.True(t, errors.Is(VerifyScript(truncated, Script{}.AddInt(1), check), ErrInvalidScript))
+
+	// Unlocking scripts may only push data
+	assert.True(t, errors.Is(VerifyScript(Script{}.AddInt(1).AddOp(OpDup), Script{}.AddOp(OpEqual), check), ErrInvalidScript))
+	assert.NoError(t, VerifyScript(Script{}.AddInt(1).AddInt(1), Script{}.AddOp(OpEqual), check))
+	assert.True(t, errors.Is(VerifyScript(Script{}.AddInt(1).AddInt(2), Script{}.AddOp(OpEqual), check), ErrScriptFailed))
+	assert.True(t, errors.Is(VerifyScript(Script{}, Script{}.AddOp(OpCheckSig), check), ErrScriptFailed))
+}
@@ -0,0 +1,127 @@
+package base
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// SigHashType selects the parts of a transaction that a signature commits
+// to. It is appended to the signatures as their last byte.
+type SigHashType byte
+
+// Signature hash types. SigHashAnyoneCanPay is combined with one of the
+// others to commit to the signed input only.
+const (
+	// SigHashAll commits to every input and output
+	SigHashAll SigHashType = 0x01
+	// SigHashNone commits to the inputs but to none of the outputs
+	SigHashNone SigHashType = 0x02
+	// SigHashSingle commits to the inputs and to the output
+	// of the same index as the signed input
+	SigHashSingle SigHashType = 0x03
+	// SigHashAnyoneCanPay lets other inputs be added and removed
+	SigHashAnyoneCanPay SigHashType = 0x80
+
+	sigHashMask = 0x1f
+)
+
+var (
+	// ErrInvalidSigHash is returned when signing or verifying with a
+	// hash type that is unknown or does not apply to the input
+	ErrInvalidSigHash = errors.New("invalid signature hash type")
+	// ErrNonCanonicalSignature is returned when verifying a signature
+	// that could be re-encoded without invalidating it
+	ErrNonCanonicalSignature = errors.New("non-canonical signature")
+)
+
+func (t SigHashType) base() SigHashType {
+	return t & sigHashMask
+}
+
+// AnyoneCanPay checks if the hash type commits to the signed input only
+func (t SigHashType) AnyoneCanPay() bool {
+	return t&SigHashAnyoneCanPay != 0
+}
+
+// Valid checks if the hash type is one of the known combinations
+func (t SigHashType) Valid() bool {
+	if t&^(sigHashMask|SigHashAnyoneCanPay) != 0 {
+		return false
+	}
+	base := t.base()
+	return base == SigHashAll || base == SigHashNone || base == SigHashSingle
+}
+
+func (t SigHashType) String() string {
+	var name string
+	switch t.base() {
+	case SigHashAll:
+		name = "ALL"
+	case SigHashNone:
+		name = "NONE"
+	case SigHashSingle:
+		name = "SINGLE"
+	default:
+		return fmt.Sprintf("SIGHASH_%#x", byte(t))
+	}
+	if t.AnyoneCanPay() {
+		name += "|ANYONECANPAY"
+	}
+	return "SIGHASH_" + name
+}
+
+// SignatureHash returns the digest signed for the input of index in, both
+// when signing and when verifying. It is the hash of the trimmed copy of
+// the transaction, followed by the hash type, where the input holds
+// subscript, the locking script or the redeem script of the spent output,
+// in place of its unlocking script. The hash type then removes:
+//   - with SigHashNone, the outputs and the sequences of the other inputs
+//   - with SigHashSingle, the outputs after the one of index in, the
+//     content of those before it and the sequences of the other inputs
+//   - with SigHashAnyoneCanPay, the other inputs
+func (tx Transaction) SignatureHash(in int, subscript Script, hashType SigHashType) ([]byte, error) {
+	if in < 0 || in >= len(tx.Vin) {
+		return nil, fmt.Errorf("%w: no input %d", ErrInvalidSigHash, in)
+	}
+	if !hashType.Valid() {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSigHash, hashType)
+	}
+
+	txCopy := tx.TrimmedCopy()
+	txCopy.Vin[in].ScriptSig = subscript
+	switch hashType.base() {
+	case SigHashNone:
+		txCopy.Vout = nil
+		txCopy.clearOtherSequences(in)
+	case SigHashSingle:
+		if in >= len(tx.Vout) {
+			return nil, fmt.Errorf("%w: %s of input %d without output", ErrInvalidSigHash, hashType, in)
+		}
+		txCopy.Vout = make([]TXOutput, in+1)
+		for i := range txCopy.Vout[:in] {
+			txCopy.Vout[i].Value = -1
+		}
+		txCopy.Vout[in] = tx.Vout[in]
+		txCopy.clearOtherSequences(in)
+	}
+	if hashType.AnyoneCanPay() {
+		txCopy.Vin = txCopy.Vin[in : in+1]
+	}
+
+	var typeBytes [4]byte
+	binary.LittleEndian.PutUint32(typeBytes[:], uint32(hashType))
+	sum := sha256.Sum256(append(txCopy.Serialize(), typeBytes[:]...))
+	return sum[:], nil
+}
+
+// clearOtherSequences zeroes the sequences of the inputs other than in,
+// so that they can be updated without invalidating the signature
+func (tx *Transaction) clearOtherSequences(in int) {
+	for i := range tx.Vin {
+		if i != in {
+			tx.Vin[i].Sequence = 0
+		}
+	}
+}
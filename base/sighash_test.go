@@ -0,0 +1,164 @@
+package base
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// copyTransaction copies the inputs and outputs of a transaction
+func copyTransaction(tx Transaction) Transaction {
+	tx.Vin = append([]TXInput{}, tx.Vin...)
+	tx.Vout = append([]TXOutput{}, tx.Vout...)
+	return tx
+}
+
+func TestSignInputsFromSeveralWallets(t *testing.T) {
+	miner, to := NewWallet(), NewWallet()
+	wallets := []*Wallet{NewWallet(), NewWallet(), NewWallet()}
+	bc := CreateBlockchainWithParams(miner.GetStringAddress(), testChainParams())
+
+	tx := &Transaction{}
+	prevTXs := make(map[string]*Transaction)
+	total := 0
+	for _, w := range wallets {
+		coinbase := NewCoinbaseTX(w.GetStringAddress(), "")
+		_, err := bc.AddBlock([]*Transaction{coinbase})
+		assert.NoError(t, err)
+		prevTXs[hex.EncodeToString(coinbase.ID)] = coinbase
+		tx.Vin = append(tx.Vin, TXInput{Txid: coinbase.ID, OutIdx: 0})
+		total += coinbase.Vout[0].Value
+	}
+	tx.Vout = []TXOutput{*NewTXOutput(total, to.GetStringAddress())}
+
+	// Each wallet signs its own input only
+	for i, w := range wallets {
+		assert.Error(t, tx.VerifyInputs(prevTXs))
+		tx.Sign(w.PrivateKey, prevTXs)
+		assert.NotEmpty(t, tx.Vin[i].ScriptSig)
+		for _, in := range tx.Vin[i+1:] {
+			assert.Empty(t, in.ScriptSig)
+		}
+	}
+	assert.True(t, tx.Verify(prevTXs))
+
+	// The signatures commit to the outputs they spend
+	swapped := copyTransaction(*tx)
+	swapped.Vin[0].ScriptSig, swapped.Vin[1].ScriptSig = tx.Vin[1].ScriptSig, tx.Vin[0].ScriptSig
+	assert.True(t, errors.Is(swapped.VerifyInputs(prevTXs), ErrScriptFailed))
+
+	tx.ID = tx.Hash()
+	_, err := bc.AddBlock([]*Transaction{NewCoinbaseTX(miner.GetStringAddress(), ""), tx})
+	assert.NoError(t, err)
+	assert.Equal(t, total, bc.UTXODB().Balance(HashPubKey(to.PublicKey)))
+	for _, w := range wallets {
+		assert.Equal(t, 0, bc.UTXODB().Balance(HashPubKey(w.PublicKey)))
+	}
+}
+
+func TestSignatureHashTypes(t *testing.T) {
+	tx := Transaction{
+		Vin:  []TXInput{{Txid: []byte{1}, OutIdx: 0}, {Txid: []byte{2}, OutIdx: 1}},
+		Vout: []TXOutput{{Value: 1, ScriptPubKey: Script{1}}, {Value: 2, ScriptPubKey: Script{2}}},
+	}
+	subscript := PayToPubKeyHashScript(make([]byte, 20))
+
+	// Changes to the transaction, the signed input being the first one
+	changes := map[string]func(tx *Transaction){
+		"output":         func(tx *Transaction) { tx.Vout[0].Value++ },
+		"other output":   func(tx *Transaction) { tx.Vout[1].Value++ },
+		"other sequence": func(tx *Transaction) { tx.Vin[1].Sequence++ },
+		"new input":      func(tx *Transaction) { tx.Vin = append(tx.Vin, TXInput{Txid: []byte{3}}) },
+		"sequence":       func(tx *Transaction) { tx.Vin[0].Sequence++ },
+	}
+	covered := map[SigHashType][]string{
+		SigHashAll:                          {"output", "other output", "other sequence", "new input", "sequence"},
+		SigHashNone:                         {"new input", "sequence"},
+		SigHashSingle:                       {"output", "new input", "sequence"},
+		SigHashAll | SigHashAnyoneCanPay:    {"output", "other output", "sequence"},
+		SigHashNone | SigHashAnyoneCanPay:   {"sequence"},
+		SigHashSingle | SigHashAnyoneCanPay: {"output", "sequence"},
+	}
+
+	hashes := make(map[string]SigHashType)
+	for hashType, names := range covered {
+		hash, err := tx.SignatureHash(0, subscript, hashType)
+		assert.NoError(t, err)
+		hashes[hex.EncodeToString(hash)] = hashType
+
+		for name, change := range changes {
+			changed := copyTransaction(tx)
+			change(&changed)
+			changedHash, err := changed.SignatureHash(0, subscript, hashType)
+			assert.NoError(t, err)
+			assert.Equal(t, contains(names, name), hex.EncodeToString(hash) != hex.EncodeToString(changedHash), "%s %s", hashType, name)
+		}
+		other, err := tx.SignatureHash(0, subscript.AddOp(OpDup), hashType)
+		assert.NoError(t, err)
+		assert.NotEqual(t, hash, other)
+	}
+	assert.Len(t, hashes, len(covered))
+
+	_, err := tx.SignatureHash(0, subscript, 0x04)
+	assert.True(t, errors.Is(err, ErrInvalidSigHash))
+	_, err = tx.SignatureHash(2, subscript, SigHashAll)
+	assert.True(t, errors.Is(err, ErrInvalidSigHash))
+	tx.Vout = tx.Vout[:1]
+	_, err = tx.SignatureHash(1, subscript, SigHashSingle)
+	assert.True(t, errors.Is(err, ErrInvalidSigHash))
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestVerifyHonoursSigHashTypes(t *testing.T) {
+	alice, bob := NewWallet(), NewWallet()
+	funding := &Transaction{Vout: []TXOutput{
+		*NewTXOutput(10, alice.GetStringAddress()),
+		*NewTXOutput(20, bob.GetStringAddress()),
+	}}
+	funding.ID = funding.Hash()
+	prevTXs := map[string]*Transaction{hex.EncodeToString(funding.ID): funding}
+	to := NewWallet().GetStringAddress()
+
+	// Alice pledges her output to a payment others may add inputs to
+	tx := &Transaction{
+		Vin:  []TXInput{{Txid: funding.ID, OutIdx: 0}},
+		Vout: []TXOutput{*NewTXOutput(30, to)},
+	}
+	assert.NoError(t, tx.SignWithType(alice.PrivateKey, prevTXs, SigHashAll|SigHashAnyoneCanPay))
+	tx.Vin = append(tx.Vin, TXInput{Txid: funding.ID, OutIdx: 1})
+	tx.Sign(bob.PrivateKey, prevTXs)
+	assert.NoError(t, tx.VerifyInputs(prevTXs))
+	tx.Vout[0].Value--
+	assert.True(t, errors.Is(tx.VerifyInputs(prevTXs), ErrScriptFailed))
+
+	// With SigHashNone, the outputs are left to the other signers
+	tx = &Transaction{
+		Vin:  []TXInput{{Txid: funding.ID, OutIdx: 0}},
+		Vout: []TXOutput{*NewTXOutput(10, to)},
+	}
+	assert.NoError(t, tx.SignWithType(alice.PrivateKey, prevTXs, SigHashNone))
+	tx.Vout[0] = *NewTXOutput(10, bob.GetStringAddress())
+	assert.NoError(t, tx.VerifyInputs(prevTXs))
+
+	// The hash type is part of the signature
+	sig, err := tx.SignInputWithType(0, alice.PrivateKey, funding.Vout[0].ScriptPubKey, SigHashAll)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(SigHashAll), sig[len(sig)-1])
+	sig[len(sig)-1] = byte(SigHashNone)
+	tx.Vin[0].ScriptSig = PayToPubKeyHashScriptSig(sig, alice.PublicKey)
+	assert.True(t, errors.Is(tx.VerifyInputs(prevTXs), ErrScriptFailed))
+
+	// SigHashSingle needs an output of the same index
+	tx.Vin = append(tx.Vin, TXInput{Txid: funding.ID, OutIdx: 1})
+	assert.True(t, errors.Is(tx.SignWithType(bob.PrivateKey, prevTXs, SigHashSingle), ErrInvalidSigHash))
+}
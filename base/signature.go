@@ -0,0 +1,99 @@
+package base
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Sizes of the SEC1 encodings of the public keys
+const (
+	CompressedPubKeyLen   = 33
+	UncompressedPubKeyLen = 65
+)
+
+// Errors returned when parsing keys and signatures
+var (
+	ErrMalformedPubKey    = errors.New("malformed public key")
+	ErrMalformedSignature = errors.New("malformed signature")
+)
+
+// curve is the elliptic curve of the keys
+var curve = elliptic.P256()
+
+// SerializePubKey returns the compressed SEC1 encoding of a public key,
+// the parity of Y followed by X
+func SerializePubKey(pubKey ecdsa.PublicKey) []byte {
+	return elliptic.MarshalCompressed(curve, pubKey.X, pubKey.Y)
+}
+
+// SerializePubKeyUncompressed returns the uncompressed SEC1 encoding
+// of a public key, 0x04 followed by X and Y
+func SerializePubKeyUncompressed(pubKey ecdsa.PublicKey) []byte {
+	return elliptic.Marshal(curve, pubKey.X, pubKey.Y)
+}
+
+// ParsePubKey decodes a compressed or uncompressed SEC1 public key
+func ParsePubKey(data []byte) (*ecdsa.PublicKey, error) {
+	var x, y *big.Int
+	switch {
+	case len(data) == CompressedPubKeyLen && (data[0] == 0x02 || data[0] == 0x03):
+		x, y = elliptic.UnmarshalCompressed(curve, data)
+	case len(data) == UncompressedPubKeyLen && data[0] == 0x04:
+		x, y = elliptic.Unmarshal(curve, data)
+	default:
+		return nil, fmt.Errorf("%w: %d bytes", ErrMalformedPubKey, len(data))
+	}
+	if x == nil {
+		return nil, fmt.Errorf("%w: not on the curve", ErrMalformedPubKey)
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// Signature is an ECDSA signature
+type Signature struct {
+	R, S *big.Int
+}
+
+// Serialize returns the DER encoding of the signature
+func (sig Signature) Serialize() []byte {
+	der, err := asn1.Marshal(sig)
+	if err != nil {
+		panic(err.Error())
+	}
+	return der
+}
+
+// IsLowS checks if S is in the lower half of the curve order, only one
+// of S and N-S being accepted so that signatures cannot be re-encoded
+func (sig Signature) IsLowS() bool {
+	return sig.S.Cmp(halfOrder) <= 0
+}
+
+// halfOrder is the largest canonical S
+var halfOrder = new(big.Int).Rsh(curve.Params().N, 1)
+
+// ParseSignature decodes a DER signature, rejecting every other
+// encoding of the same value and values out of the range of the curve
+func ParseSignature(der []byte) (*Signature, error) {
+	sig := &Signature{}
+	rest, err := asn1.Unmarshal(der, sig)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedSignature, err)
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrMalformedSignature, len(rest))
+	}
+	n := curve.Params().N
+	if sig.R.Sign() <= 0 || sig.R.Cmp(n) >= 0 || sig.S.Sign() <= 0 || sig.S.Cmp(n) >= 0 {
+		return nil, fmt.Errorf("%w: R or S out of range", ErrMalformedSignature)
+	}
+	if !bytes.Equal(sig.Serialize(), der) {
+		return nil, fmt.Errorf("%w: not DER", ErrMalformedSignature)
+	}
+	return sig, nil
+}
@@ -0,0 +1,100 @@
+package base
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+	"testing/quick"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// derSignature encodes R and S as given, minimal or not
+func derSignature(r, s []byte) []byte {
+	der := []byte{0x30, byte(4 + len(r) + len(s)), 0x02, byte(len(r))}
+	der = append(der, r...)
+	der = append(der, 0x02, byte(len(s)))
+	return append(der, s...)
+}
+
+func TestParsePubKey(t *testing.T) {
+	wallet := NewWallet()
+	pubKey := wallet.PrivateKey.PublicKey
+	assert.Len(t, wallet.PublicKey, CompressedPubKeyLen)
+	assert.Equal(t, SerializePubKey(pubKey), wallet.PublicKey)
+	assert.Equal(t, encodeAddress(version, HashPubKey(SerializePubKey(pubKey))), wallet.GetAddress())
+
+	for _, data := range [][]byte{SerializePubKey(pubKey), SerializePubKeyUncompressed(pubKey)} {
+		parsed, err := ParsePubKey(data)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, pubKey.X.Cmp(parsed.X))
+		assert.Equal(t, 0, pubKey.Y.Cmp(parsed.Y))
+	}
+
+	uncompressed := SerializePubKeyUncompressed(pubKey)
+	offCurve := append([]byte{}, uncompressed...)
+	offCurve[len(offCurve)-1] ^= 1
+	malformed := [][]byte{
+		nil,
+		wallet.PublicKey[1:],
+		append([]byte{0x04}, wallet.PublicKey[1:]...),
+		append([]byte{0x02}, uncompressed[1:]...),
+		append([]byte{0x02}, bytes.Repeat([]byte{0xff}, 32)...),
+		offCurve,
+	}
+	for _, data := range malformed {
+		_, err := ParsePubKey(data)
+		assert.True(t, errors.Is(err, ErrMalformedPubKey), "%x", data)
+	}
+}
+
+func TestParseSignatureRequiresDER(t *testing.T) {
+	sig, err := ParseSignature(derSignature([]byte{1}, []byte{2}))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), sig.R.Int64())
+	assert.Equal(t, int64(2), sig.S.Int64())
+
+	n := curve.Params().N
+	malformed := [][]byte{
+		nil,
+		derSignature([]byte{0, 1}, []byte{2}),
+		derSignature([]byte{1}, []byte{0x80}),
+		derSignature([]byte{0}, []byte{2}),
+		derSignature(n.Bytes(), []byte{2}),
+		append(derSignature([]byte{1}, []byte{2}), 0),
+		{0x30, 0x81, 0x06, 0x02, 0x01, 0x01, 0x02, 0x01, 0x02},
+	}
+	for _, der := range malformed {
+		_, err := ParseSignature(der)
+		assert.True(t, errors.Is(err, ErrMalformedSignature), "%x", der)
+	}
+
+	high := Signature{R: big.NewInt(1), S: new(big.Int).Sub(n, big.NewInt(1))}
+	assert.False(t, high.IsLowS())
+	sig, err = ParseSignature(high.Serialize())
+	assert.NoError(t, err)
+	assert.False(t, sig.IsLowS())
+}
+
+func TestSignAndVerifyRandomWallets(t *testing.T) {
+	count := 3000
+	if testing.Short() {
+		count = 300
+	}
+	tx := Transaction{Vin: []TXInput{{Txid: []byte{1}}}}
+	signs := func(txid [32]byte, hashType byte) bool {
+		wallet := NewWallet()
+		subscript := PayToPubKeyHashScript(HashPubKey(wallet.PublicKey))
+		tx.Vin[0].Txid = txid[:]
+		hashTypes := []SigHashType{SigHashAll, SigHashNone, SigHashAll | SigHashAnyoneCanPay}
+		sigHashType := hashTypes[int(hashType)%len(hashTypes)]
+		sig, err := tx.SignInputWithType(0, wallet.PrivateKey, subscript, sigHashType)
+		if err != nil || checkSignatureEncoding(sig) != nil {
+			return false
+		}
+		hash, err := tx.SignatureHash(0, subscript, sigHashType)
+		return err == nil && verifySignature(wallet.PublicKey, sig[:len(sig)-1], hash)
+	}
+	assert.NoError(t, quick.Check(signs, &quick.Config{MaxCount: count}))
+}
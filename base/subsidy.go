@@ -0,0 +1,93 @@
+package base
+
+import (
+	"fmt"
+)
+
+// maxHalvings is the number of halvings after which no reward is left
+const maxHalvings = 63
+
+// MaxMoney is the largest amount of coins of an output, or of the sum of
+// the inputs, outputs or fees of a transaction or a block. Amounts are
+// checked against it before being summed, so that the sums never overflow.
+const MaxMoney = 21000000 * 100000000
+
+// addMoney adds an amount to a sum of amounts, reporting false when the
+// amount is out of range or the sum would exceed MaxMoney
+func addMoney(sum, amount int) (int, bool) {
+	if amount < 0 || amount > MaxMoney || sum > MaxMoney-amount {
+		return sum, false
+	}
+	return sum + amount, true
+}
+
+// Subsidy returns the new coins a coinbase may claim at the given height,
+// the initial reward being halved every HalvingInterval blocks
+func (p ChainParams) Subsidy(height int) int {
+	if p.HalvingInterval <= 0 || height < 0 {
+		return p.InitialReward
+	}
+	halvings := height / p.HalvingInterval
+	if halvings >= maxHalvings {
+		return 0
+	}
+	return p.InitialReward >> uint(halvings)
+}
+
+// TotalSubsidy returns the sum of the subsidies of the blocks
+// from the genesis up to the given height
+func (p ChainParams) TotalSubsidy(height int) int {
+	if height < 0 {
+		return 0
+	}
+	if p.HalvingInterval <= 0 {
+		return (height + 1) * p.InitialReward
+	}
+	total := 0
+	for start := 0; start <= height; start += p.HalvingInterval {
+		reward := p.Subsidy(start)
+		if reward == 0 {
+			break
+		}
+		end := start + p.HalvingInterval - 1
+		if end > height {
+			end = height
+		}
+		total += (end - start + 1) * reward
+	}
+	return total
+}
+
+// NextSubsidy returns the subsidy of the next block of the best chain
+func (bc Blockchain) NextSubsidy() int {
+	return bc.params.Subsidy(len(bc.nodes))
+}
+
+// Supply returns the coins issued by the best chain up to the given height,
+// the value of its unspent outputs once the block at that height is
+// connected. Fees are not issued, and the subsidies left unclaimed by the
+// coinbases are lost, so the supply never exceeds the total subsidy.
+func (bc Blockchain) Supply(height int) (int, error) {
+	if height < 0 || height >= len(bc.nodes) {
+		return 0, fmt.Errorf("no block at height %d", height)
+	}
+	utxos := bc.utxos.Set()
+	if height < len(bc.nodes)-1 {
+		utxos = make(UTXOSet)
+		for h := 0; h <= height; h++ {
+			block, err := bc.BlockAt(h)
+			if err != nil {
+				return 0, err
+			}
+			if _, err := utxos.Update(block.Transactions, h); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	supply := 0
+	for _, output := range utxos {
+		supply += output.Value
+	}
+	return supply, nil
+}
@@ -0,0 +1,47 @@
+package base
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubsidyHalves(t *testing.T) {
+	params := ChainParams{InitialReward: 10, HalvingInterval: 2}
+	var subsidies []int
+	for height := 0; height < 10; height++ {
+		subsidies = append(subsidies, params.Subsidy(height))
+	}
+	assert.Equal(t, []int{10, 10, 5, 5, 2, 2, 1, 1, 0, 0}, subsidies)
+	assert.Equal(t, 36, params.TotalSubsidy(9))
+	assert.Equal(t, 25, params.TotalSubsidy(2))
+	assert.Equal(t, 0, params.TotalSubsidy(-1))
+	assert.Equal(t, 0, params.Subsidy(2*maxHalvings))
+
+	params.HalvingInterval = 0
+	assert.Equal(t, 10, params.Subsidy(1000))
+	assert.Equal(t, 30, params.TotalSubsidy(2))
+}
+
+func TestValidateBlockChecksSubsidyByHeight(t *testing.T) {
+	params := testChainParams()
+	params.HalvingInterval = 2
+	address := NewWallet().GetStringAddress()
+	bc := CreateBlockchainWithParams(address, params)
+
+	for bc.Height() < 9 {
+		_, err := bc.AddBlock([]*Transaction{NewCoinbaseTXWithReward(address, "", bc.NextSubsidy()+1)})
+		assertRule(t, RuleCoinbase, err)
+		_, err = bc.AddBlock([]*Transaction{NewCoinbaseTXWithReward(address, "", bc.NextSubsidy())})
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 0, bc.NextSubsidy())
+
+	for height := 0; height <= bc.Height(); height++ {
+		supply, err := bc.Supply(height)
+		assert.NoError(t, err)
+		assert.Equal(t, params.TotalSubsidy(height), supply)
+	}
+	_, err := bc.Supply(bc.Height() + 1)
+	assert.Error(t, err)
+}
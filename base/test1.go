@@ -1,111 +1,278 @@
 package base
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"net"
 	"time"
 )
 
-// Wallet 1 always sends 10 coin to Wallet 2
+// pollInterval bounds how long the master blocks on a read before
+// checking whether mining has been cancelled
+const pollInterval = 100 * time.Millisecond
+
+// Wallet 1 sends 10 coin to Wallet 2 in every block once its rewards are mature
 // One input tx, one output tx
 func runTest1(n int) []int64 {
 	createBlockchain()
 	t := []int64{}
 	if verbose {
-		balance1, _ := utxos.FindSpendableOutputs(HashPubKey(wallet1.PublicKey), 9999999)
-		balance2, _ := utxos.FindSpendableOutputs(HashPubKey(wallet2.PublicKey), 9999999)
-		fmt.Printf("%d %d %d\n", len(chain.blocks), balance1, balance2)
+		fmt.Printf("%d %d %d\n", chain.Height()+1, ledger1.Balance().Confirmed, ledger2.Balance().Confirmed)
 	}
 
 	for i := 0; i < n; i++ {
-		newTransaction(10) // Send 10
+		// The rewards of wallet 1 are spent once mature,
+		// the blocks before carry their coinbase only
+		if matureBalance(wallet1) >= 10 {
+			newTransaction(10) // Send 10
+		}
 		txs := prepareTXs()
 		t0 := time.Now()
 
-		block := mine(txs, chain.CurrentBlock().Hash)
-		chain.blocks = append(chain.blocks, &block)
+		_, err := mine(context.Background(), txs, chain.CurrentBlock().Hash)
+		if err != nil {
+			fmt.Println(err.Error())
+			break
+		}
 
 		t = append(t, time.Now().Sub(t0).Milliseconds())
-		utxos.Update(txs)
+		logHashRates()
+		utxos = chain.UTXODB()
 		if verbose {
-			balance1, _ := utxos.FindSpendableOutputs(HashPubKey(wallet1.PublicKey), 9999999)
-			balance2, _ := utxos.FindSpendableOutputs(HashPubKey(wallet2.PublicKey), 9999999)
-			fmt.Printf("%d %d %d\n", len(chain.blocks), balance1, balance2)
+			fmt.Printf("%d %d %d\n", chain.Height()+1, ledger1.Balance().Confirmed, ledger2.Balance().Confirmed)
 		}
-		txBuffer = []*Transaction{}
 
-		if block.Nonce%2 == 1 {
-			slave1Score++
-		} else {
-			slave2Score++
-		}
-		if len(chain.blocks)%100 == 0 {
-			fmt.Println("Length of chain:", len(chain.blocks))
+		if (chain.Height()+1)%100 == 0 {
+			fmt.Println("Length of chain:", chain.Height()+1)
 		}
 	}
 
 	return t
 }
 
-func mine(txs []*Transaction, prevHash []byte) Block {
-	block := Block{PrevBlockHash: prevHash, Transactions: txs}
+// matureBalance returns the value of the outputs of the wallet
+// that the next block may spend
+func matureBalance(wallet *Wallet) int {
+	balance := 0
+	for _, output := range utxos.MatureOutputs(HashPubKey(wallet.PublicKey), chain.Height()+1, chain.Params().CoinbaseMaturity) {
+		balance += output.Value
+	}
+	return balance
+}
+
+// logHashRates records the last hash rate reported by each slave
+func logHashRates() {
+	for id := range hashRateLog {
+		hashRateLog[id] = append(hashRateLog[id], int64(slaveHashRates[id]))
+	}
+	if verbose {
+		fmt.Printf("Hash rates: ID 0: %.0f H/s, ID 1: %.0f H/s\n", slaveHashRates[0], slaveHashRates[1])
+	}
+}
+
+// mine sends the block to the slaves and returns the first solution that
+// becomes the tip of the chain, telling the slaves to stop once a solution
+// is found or ctx is done
+func mine(ctx context.Context, txs []*Transaction, prevHash []byte) (Block, error) {
+	block := Block{PrevBlockHash: prevHash, Transactions: txs, TargetBits: chain.NextTargetBits(), Algorithm: chain.Params().PoWAlgorithm}
 	block.Timestamp = time.Now().Unix()
 	block.Hash = []byte{}
 	block.Nonce = -1
 	mBlock := MarshalBlock(block)
 	sendChallenge(mBlock)
-	block = awaitResponse()
+	defer sendStop()
+
+	block, err := awaitResponse(ctx, prevHash)
+	if err != nil {
+		return Block{}, err
+	}
 	if verbose {
 		fmt.Print("   ", block.Nonce)
 		fmt.Println("\n ")
 	}
 
-	return block
+	return block, nil
 }
 
 func sendChallenge(mBlock []byte) {
-	_, err := conn.WriteToUDP(mBlock, slave1)
+	sendToSlaves(mBlock)
+}
+
+func sendStop() {
+	sendToSlaves([]byte("STP"))
+}
+
+func sendToSlaves(msg []byte) {
+	_, err := conn.WriteToUDP(msg, slave1)
 	if err != nil {
 		fmt.Println(err.Error())
 	}
-	_, err = conn.WriteToUDP(mBlock, slave2)
+	_, err = conn.WriteToUDP(msg, slave2)
 	if err != nil {
 		fmt.Println(err.Error())
 	}
 }
 
-func awaitResponse() Block {
+// awaitResponse processes the blocks sent by the slaves until one of them
+// extends the chain past prevHash or ctx is done. Late solutions of previous
+// challenges are also processed and end up on side branches.
+func awaitResponse(ctx context.Context, prevHash []byte) (Block, error) {
+	defer conn.SetReadDeadline(time.Time{})
 
+	buffer := make([]byte, MaxMessageSize)
 	for {
-		buffer := make([]byte, 1024)
+		select {
+		case <-ctx.Done():
+			return Block{}, ErrMiningCancelled
+		default:
+		}
+
+		conn.SetReadDeadline(time.Now().Add(pollInterval))
 		n, fromAddr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+				fmt.Println(err.Error())
+			}
+			continue
+		}
+		if n < 3 {
+			continue
+		}
+		if string(buffer[0:3]) == "HRT" {
+			var report HashRateReport
+			if err := json.Unmarshal(buffer[3:n], &report); err != nil {
+				fmt.Println(err.Error())
+				continue
+			}
+			slaveHashRates[report.ID] = report.HashRate
+			continue
+		}
+		if string(buffer[0:3]) != "POW" {
+			continue
+		}
 
-		var block Block
-		err = json.Unmarshal(buffer[3:n], &block)
+		block, err := DeserializeBlock(buffer[3:n])
 		if err != nil {
 			fmt.Println(err.Error())
+			continue
 		}
-		if chain.ValidateBlock(&block) {
-			if verbose {
-				fmt.Printf("%v\n", fromAddr)
-			}
+		if err := chain.ProcessBlock(block); err != nil {
+			continue
+		}
+		if verbose {
+			fmt.Printf("%v\n", fromAddr)
+		}
+		if chain.IsMainChain(block.Hash) && bytes.Equal(block.PrevBlockHash, prevHash) {
+			return *block, nil
+		}
+	}
+}
+
+// benchmarkPoW measures the hash rate of the legacy proof-of-work loop,
+// which rebuilds the header and compares big integers for every nonce,
+// against the midstate loop used by Run, hashing nHashes nonces with each
+func benchmarkPoW(nHashes int) (legacy, current MiningStats) {
+	wallet := NewWallet()
+	coinbase := NewCoinbaseTX(wallet.GetStringAddress(), GenesisCoinbaseData)
+	block := &Block{
+		Timestamp:     time.Now().Unix(),
+		Transactions:  []*Transaction{coinbase},
+		PrevBlockHash: make([]byte, sha256.Size),
+		TargetBits:    MaxTargetBits, // never solved, every nonce is hashed
+	}
+	pow := NewProofOfWork(block)
 
-			return block
+	t0 := time.Now()
+	num := big.NewInt(0)
+	header := pow.setupHeader()
+	for nonce := 0; nonce < nHashes; nonce++ {
+		sum := sha256.Sum256(addNonce(nonce, header))
+		num.SetBytes(sum[:])
+		if num.Cmp(pow.target) == -1 {
+			break
 		}
+	}
+	legacy = MiningStats{Hashes: uint64(nHashes), Elapsed: time.Since(t0)}
+
+	t0 = time.Now()
+	pow.runRange(context.Background(), 0, 1, nHashes, nil)
+	current = MiningStats{Hashes: pow.Hashes(), Elapsed: time.Since(t0)}
+
+	return legacy, current
+}
 
+// DefaultBenchmarkHashes is the number of nonces hashed by each loop of RunBenchmark
+const DefaultBenchmarkHashes = 5000000
+
+// RunBenchmark prints the hash rates of the legacy and the current
+// proof-of-work loops, hashing nHashes nonces with each
+func RunBenchmark(nHashes int) {
+	legacy, current := benchmarkPoW(nHashes)
+	fmt.Printf("Legacy:  %.0f H/s\n", legacy.HashRate())
+	fmt.Printf("Current: %.0f H/s\n", current.HashRate())
+	fmt.Printf("Speedup: %.2fx\n", current.HashRate()/legacy.HashRate())
+}
+
+// minerID returns the ID of the slave that mined the block,
+// slaves searching the nonces of their own parity
+func minerID(block *Block) int {
+	return block.Nonce % 2
+}
+
+// countBlocks returns the number of blocks of the best chain, and of the
+// side branches, mined by each slave
+func countBlocks() (scores, stale map[int]int) {
+	scores = make(map[int]int)
+	stale = make(map[int]int)
+	for height := 1; height <= chain.Height(); height++ {
+		block, err := chain.BlockAt(height)
+		if err != nil {
+			fmt.Println(err.Error())
+			continue
+		}
+		scores[minerID(block)]++
+	}
+	for _, hash := range chain.SideBlocks() {
+		block, err := chain.GetBlock(hash)
+		if err != nil {
+			fmt.Println(err.Error())
+			continue
+		}
+		stale[minerID(block)]++
 	}
+	return scores, stale
+}
 
+// HashRateReport is sent by a slave to the master while it mines
+type HashRateReport struct {
+	ID       int     // the slave ID
+	Hashes   uint64  // hashes computed for the current block
+	HashRate float64 // hashes per second
 }
 
-// MarshalBlock marshals the block
-func MarshalBlock(block Block) []byte {
-	mBlock, err := json.Marshal(block)
+// MarshalHashRate marshals the hash rate report
+func MarshalHashRate(report HashRateReport) []byte {
+	mReport, err := json.Marshal(report)
 	if err != nil {
 		fmt.Println(err.Error())
 	}
-	mBlock = append([]byte("POW"), mBlock...)
-	return mBlock
+	return append([]byte("HRT"), mReport...)
+}
+
+// MaxMessageSize is the largest UDP payload exchanged with the slaves
+const MaxMessageSize = 65507
+
+// MaxMessageBlockSize is the largest block a message carries after its tag,
+// and so the largest block of the experiment chain
+const MaxMessageBlockSize = MaxMessageSize - len("POW")
+
+// MarshalBlock marshals the block in its binary encoding
+func MarshalBlock(block Block) []byte {
+	return append([]byte("POW"), block.Serialize()...)
 }
 
 func addrEquals(a, b *net.UDPAddr) bool {
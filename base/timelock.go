@@ -0,0 +1,100 @@
+package base
+
+import (
+	"encoding/hex"
+)
+
+// LockTimeThreshold separates the lock times given as block heights,
+// below it, from those given as Unix timestamps
+const LockTimeThreshold = 500000000
+
+// Sequence numbers of the inputs. The sequence of an input is a relative
+// lock, in blocks or in units of 512 seconds, on the age of the output it
+// spends, unless SequenceLockDisabled is set.
+const (
+	// SequenceFinal disables the lock time when set by every input
+	SequenceFinal = 0xffffffff
+	// SequenceLockDisabled disables the relative lock of an input
+	SequenceLockDisabled = 1 << 31
+	// SequenceLockIsTime makes the relative lock a duration
+	SequenceLockIsTime = 1 << 22
+	// SequenceLockMask selects the value of the relative lock
+	SequenceLockMask = 0x0000ffff
+	// sequenceLockTimeShift converts the durations to seconds
+	sequenceLockTimeShift = 9
+)
+
+// RelativeHeightLock returns the sequence locking an input
+// until the output it spends has the given number of confirmations
+func RelativeHeightLock(blocks int) uint32 {
+	return uint32(blocks) & SequenceLockMask
+}
+
+// RelativeTimeLock returns the sequence locking an input until the
+// given number of seconds, rounded up to a multiple of 512, has elapsed
+// since the block of the output it spends
+func RelativeTimeLock(seconds int64) uint32 {
+	units := (seconds + 1<<sequenceLockTimeShift - 1) >> sequenceLockTimeShift
+	return SequenceLockIsTime | uint32(units)&SequenceLockMask
+}
+
+// IsFinal checks if the lock time of the transaction allows it in a block
+// of the given height and timestamp. The lock time is a height, or a
+// timestamp from LockTimeThreshold on, that the block must exceed.
+func (tx Transaction) IsFinal(height int, timestamp int64) bool {
+	if tx.LockTime == 0 {
+		return true
+	}
+	limit := int64(height)
+	if tx.LockTime >= LockTimeThreshold {
+		limit = timestamp
+	}
+	if tx.LockTime < limit {
+		return true
+	}
+	for _, input := range tx.Vin {
+		if input.Sequence != SequenceFinal {
+			return false
+		}
+	}
+	return true
+}
+
+// checkLocks checks the lock time and the relative locks of a transaction
+// in a block of the given height and timestamp, spending the unspent
+// outputs or the outputs of the created transactions, which have no age
+func (bc *Blockchain) checkLocks(tx *Transaction, height int, timestamp int64, utxos UTXOSet, created map[string]*Transaction) error {
+	if !tx.IsFinal(height, timestamp) {
+		return invalidBlock(RuleLockTime, "transaction %x is locked until %d", tx.ID, tx.LockTime)
+	}
+	for _, input := range tx.Vin {
+		if input.Sequence&SequenceLockDisabled != 0 {
+			continue
+		}
+		lock := int64(input.Sequence & SequenceLockMask)
+		if lock == 0 {
+			continue
+		}
+
+		var age int64
+		op := NewOutpoint(input.Txid, input.OutIdx)
+		if _, ok := created[hex.EncodeToString(input.Txid)]; !ok {
+			output, ok := utxos[op]
+			if !ok || output.Height >= len(bc.nodes) {
+				return invalidBlock(RuleMissingInput, "output %s is spent or does not exist", op)
+			}
+			if input.Sequence&SequenceLockIsTime != 0 {
+				age = timestamp - bc.nodes[output.Height].timestamp
+			} else {
+				age = int64(height - output.Height)
+			}
+		}
+		if input.Sequence&SequenceLockIsTime != 0 {
+			lock <<= sequenceLockTimeShift
+		}
+		if age < lock {
+			return invalidBlock(RuleSequenceLock, "output %s of age %d spent by %x is locked for %d", op, age, tx.ID, lock)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package base
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// lockedTransaction creates a transaction of amount from the wallet
+// with the given lock time and sequence on every input
+func lockedTransaction(t *testing.T, bc *Blockchain, from *Wallet, to string, amount int, lockTime int64, sequence uint32) *Transaction {
+	tx, err := NewUTXOTransaction(from, to, amount, bc.UTXODB(), bc)
+	assert.NoError(t, err)
+	tx.LockTime = lockTime
+	for i := range tx.Vin {
+		tx.Vin[i].Sequence = sequence
+	}
+	bc.SignTransaction(tx, from.PrivateKey)
+	return tx
+}
+
+func addEmptyBlock(t *testing.T, bc *Blockchain, address string) {
+	_, err := bc.AddBlock([]*Transaction{NewCoinbaseTX(address, "")})
+	assert.NoError(t, err)
+}
+
+func TestTransactionIsFinal(t *testing.T) {
+	now := time.Now().Unix()
+	tx := Transaction{Vin: []TXInput{{Txid: []byte{1}}}}
+	assert.True(t, tx.IsFinal(0, now))
+
+	tx.LockTime = 5
+	assert.False(t, tx.IsFinal(5, now))
+	assert.True(t, tx.IsFinal(6, now))
+
+	tx.LockTime = now
+	assert.True(t, LockTimeThreshold < now)
+	assert.False(t, tx.IsFinal(1000, now))
+	assert.True(t, tx.IsFinal(1000, now+1))
+
+	// Final sequences disable the lock time
+	tx.Vin[0].Sequence = SequenceFinal
+	assert.True(t, tx.IsFinal(1000, now))
+}
+
+func TestValidateBlockEnforcesLockTime(t *testing.T) {
+	from, to := NewWallet(), NewWallet()
+	address := from.GetStringAddress()
+	bc := CreateBlockchainWithParams(address, testChainParams())
+
+	tx := lockedTransaction(t, bc, from, to.GetStringAddress(), 4, 2, 0)
+	_, err := bc.AddBlock([]*Transaction{NewCoinbaseTX(address, ""), tx})
+	assertRule(t, RuleLockTime, err)
+	addEmptyBlock(t, bc, address)
+	_, err = bc.AddBlock([]*Transaction{NewCoinbaseTX(address, ""), tx})
+	assertRule(t, RuleLockTime, err)
+
+	addEmptyBlock(t, bc, address)
+	_, err = bc.AddBlock([]*Transaction{NewCoinbaseTX(address, ""), tx})
+	assert.NoError(t, err)
+
+	// The lock time is signed
+	tx = lockedTransaction(t, bc, from, to.GetStringAddress(), 4, 0, 0)
+	tx.LockTime = 1
+	tx.ID = tx.Hash()
+	_, err = bc.AddBlock([]*Transaction{NewCoinbaseTX(address, ""), tx})
+	assertRule(t, RuleSignature, err)
+}
+
+func TestValidateBlockEnforcesSequenceLocks(t *testing.T) {
+	from, to := NewWallet(), NewWallet()
+	address := from.GetStringAddress()
+	bc := CreateBlockchainWithParams(address, testChainParams())
+
+	// The output of the genesis block is spent two blocks later
+	tx := lockedTransaction(t, bc, from, to.GetStringAddress(), 4, 0, RelativeHeightLock(2))
+	_, err := bc.AddBlock([]*Transaction{NewCoinbaseTX(address, ""), tx})
+	assertRule(t, RuleSequenceLock, err)
+	addEmptyBlock(t, bc, address)
+	_, err = bc.AddBlock([]*Transaction{NewCoinbaseTX(address, ""), tx})
+	assert.NoError(t, err)
+
+	// A relative time lock counts from the block of the output
+	tx = lockedTransaction(t, bc, from, to.GetStringAddress(), 3, 0, RelativeTimeLock(1000))
+	output := bc.UTXOSet()[NewOutpoint(tx.Vin[0].Txid, tx.Vin[0].OutIdx)]
+	start := bc.nodes[output.Height].timestamp
+	height := bc.Height() + 1
+	err = bc.checkLocks(tx, height, start+1000, bc.UTXOSet(), nil)
+	assertRule(t, RuleSequenceLock, err)
+	assert.NoError(t, bc.checkLocks(tx, height, start+1024, bc.UTXOSet(), nil))
+
+	// An output created in the same block has no age
+	created := map[string]*Transaction{hex.EncodeToString(tx.Vin[0].Txid): nil}
+	assertRule(t, RuleSequenceLock, bc.checkLocks(tx, height, start+1024, bc.UTXOSet(), created))
+	tx.Vin[0].Sequence = SequenceLockDisabled
+	assert.NoError(t, bc.checkLocks(tx, height, start, bc.UTXOSet(), created))
+}
+
+func TestMempoolHoldsBackLockedTransactions(t *testing.T) {
+	from, to := NewWallet(), NewWallet()
+	address := from.GetStringAddress()
+	bc := CreateBlockchainWithParams(address, testChainParams())
+	mp := NewMempool(bc)
+
+	tx := lockedTransaction(t, bc, from, to.GetStringAddress(), 4, 1, 0)
+	assert.NoError(t, mp.Add(tx))
+	template := mp.BlockTemplate(address, "")
+	assert.Len(t, template, 1)
+	_, err := bc.MineBlock(template)
+	assert.NoError(t, err)
+	assert.True(t, mp.Has(tx.ID))
+
+	template = mp.BlockTemplate(address, "")
+	assert.Len(t, template, 2)
+	_, err = bc.MineBlock(template)
+	assert.NoError(t, err)
+	assert.False(t, mp.Has(tx.ID))
+}
@@ -1,41 +1,51 @@
 package base
 
 import (
-	"bytes"
 	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
-	"encoding/gob"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"math/big"
 	"strings"
 )
 
 // Transaction represents a Bitcoin transaction
 type Transaction struct {
-	ID   []byte
-	Vin  []TXInput
-	Vout []TXOutput
+	ID       []byte
+	Vin      []TXInput
+	Vout     []TXOutput
+	LockTime int64 // height or timestamp before which it may not be mined, 0 for none
 }
 
-// Serialize returns a serialized Transaction
+// Serialize returns the binary encoding of a Transaction, without its ID
 func (tx Transaction) Serialize() []byte {
-	var data bytes.Buffer
-	enc := gob.NewEncoder(&data)
-
-	enc.Encode(Transaction{tx.ID, tx.Vin, tx.Vout})
+	e := &encoder{buf: make([]byte, 0, tx.Size())}
+	tx.encode(e)
+	return e.buf
+}
 
-	return data.Bytes()
+// SerializeNoWitness returns the binary encoding of a Transaction without
+// its witness, the unlocking scripts of its inputs. The data of a coinbase
+// is not witness and is kept.
+func (tx Transaction) SerializeNoWitness() []byte {
+	if tx.IsCoinbase() {
+		return tx.Serialize()
+	}
+	return tx.TrimmedCopy().Serialize()
 }
 
-// Hash returns the hash of the Transaction
+// Hash returns the ID of the Transaction, the hash of its encoding without
+// witness, so that it does not change when the inputs are signed
 func (tx *Transaction) Hash() []byte {
-	t := *tx
-	t.ID = []byte{}
-	sum := sha256.Sum256(t.Serialize())
+	sum := sha256.Sum256(tx.SerializeNoWitness())
+	return sum[:]
+}
+
+// WitnessHash returns the hash of the Transaction including its witness
+func (tx *Transaction) WitnessHash() []byte {
+	sum := sha256.Sum256(tx.Serialize())
 	return sum[:]
 }
 
@@ -49,14 +59,13 @@ func (tx Transaction) String() string {
 		lines = append(lines, fmt.Sprintf("     Input %d:", i))
 		lines = append(lines, fmt.Sprintf("       TXID:      %x", input.Txid))
 		lines = append(lines, fmt.Sprintf("       OutIdx:    %d", input.OutIdx))
-		lines = append(lines, fmt.Sprintf("       Signature: %x", input.Signature))
-		lines = append(lines, fmt.Sprintf("       PubKey: %x", input.PubKey))
+		lines = append(lines, fmt.Sprintf("       ScriptSig: %x", []byte(input.ScriptSig)))
 	}
 
 	for i, output := range tx.Vout {
 		lines = append(lines, fmt.Sprintf("     Output %d:", i))
 		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
-		lines = append(lines, fmt.Sprintf("       PubKeyHash: %x", output.PubKeyHash))
+		lines = append(lines, fmt.Sprintf("       ScriptPubKey: %v", output.ScriptPubKey))
 	}
 
 	return strings.Join(lines, "\n")
@@ -69,131 +78,273 @@ func (tx Transaction) IsCoinbase() bool {
 		return false
 	}
 	input := tx.Vin[0]
-	output := tx.Vout[0]
 
 	hasEmptyID := len(input.Txid) == 0
 	isFirst := input.OutIdx == -1
-	hasNoSignature := input.Signature == nil
 
-	hasCorrectReward := output.Value == BlockReward
-
-	return hasOneInput && hasEmptyID && isFirst && hasCorrectReward && hasNoSignature
+	// The value of the coinbase depends on the fees of its block,
+	// it is checked by Blockchain.ValidateBlock
+	return hasOneInput && hasEmptyID && isFirst
 }
 
-// NewCoinbaseTX creates a new coinbase transaction
+// extraNonceLen is the size of the extra nonce at the end of the coinbase data
+const extraNonceLen = 8
+
+// NewCoinbaseTX creates a new coinbase transaction claiming BlockReward
 func NewCoinbaseTX(to, data string) *Transaction {
+	return NewCoinbaseTXWithReward(to, data, BlockReward)
+}
+
+// NewCoinbaseTXWithReward creates a new coinbase transaction claiming
+// reward, the subsidy of its block and the fees of its transactions
+func NewCoinbaseTXWithReward(to, data string, reward int) *Transaction {
 	if data == "" {
 		data = "Reward to " + to
 	}
+	// The coinbase data ends with an extra nonce that miners can roll.
+	// It starts at a random value, so that coinbases paying the same
+	// reward to the same address still have different IDs
+	var extraNonce [extraNonceLen]byte
+	if _, err := rand.Read(extraNonce[:]); err != nil {
+		panic(err.Error())
+	}
+	coinbaseData := append([]byte(data), extraNonce[:]...)
 	tx := &Transaction{
 		Vin: []TXInput{
-			{Txid: []byte{}, OutIdx: -1, Signature: nil, PubKey: []byte(data)},
+			{Txid: []byte{}, OutIdx: -1, ScriptSig: coinbaseData},
 		},
 		Vout: []TXOutput{
-			*NewTXOutput(BlockReward, to),
+			*NewTXOutput(reward, to),
 		},
 	}
 	tx.ID = tx.Hash()
 	return tx
 }
 
-// NewUTXOTransaction creates a new UTXO transaction
-func NewUTXOTransaction(wallet *Wallet, to string, amount int, utxos UTXOSet, bc *Blockchain) (*Transaction, error) {
+// ExtraNonce returns the extra nonce of a coinbase transaction
+func (tx Transaction) ExtraNonce() int64 {
+	if !tx.IsCoinbase() || len(tx.Vin[0].ScriptSig) < extraNonceLen {
+		return 0
+	}
+	data := tx.Vin[0].ScriptSig
+	return int64(binary.BigEndian.Uint64(data[len(data)-extraNonceLen:]))
+}
+
+// SetExtraNonce returns a copy of a coinbase transaction
+// with the given extra nonce and the matching ID
+func (tx Transaction) SetExtraNonce(extraNonce int64) (*Transaction, error) {
+	if !tx.IsCoinbase() {
+		return nil, errors.New("Only coinbase transactions have an extra nonce")
+	}
+	data := tx.Vin[0].ScriptSig
+	if len(data) < extraNonceLen {
+		return nil, errors.New("Coinbase data has no extra nonce")
+	}
+
+	newData := append([]byte{}, data[:len(data)-extraNonceLen]...)
+	newData = append(newData, IntToHex(extraNonce)...)
+	input := tx.Vin[0]
+	input.ScriptSig = newData
+	rolled := Transaction{Vin: []TXInput{input}, Vout: tx.Vout, LockTime: tx.LockTime}
+	rolled.ID = rolled.Hash()
+	return &rolled, nil
+}
+
+// NewUTXOTransaction creates a new UTXO transaction without a fee
+func NewUTXOTransaction(wallet *Wallet, to string, amount int, utxos OutputFinder, bc *Blockchain) (*Transaction, error) {
+	return NewUTXOTransactionWithFee(wallet, to, amount, 0, utxos, bc)
+}
+
+// feeRateBytes is the number of serialized bytes a fee rate is given for
+const feeRateBytes = 1000
+
+// FeeForSize returns the fee paid at feeRate, per 1000 bytes,
+// by a serialized transaction of size bytes
+func FeeForSize(feeRate, size int) int {
+	return (feeRate*size + feeRateBytes - 1) / feeRateBytes
+}
+
+// NewUTXOTransactionWithFeeRate creates a new UTXO transaction paying
+// feeRate for every 1000 bytes of the serialized transaction
+func NewUTXOTransactionWithFeeRate(wallet *Wallet, to string, amount, feeRate int, utxos OutputFinder, bc *Blockchain) (*Transaction, error) {
+	tx, _, err := NewUTXOTransactionWithSelection(wallet, to, amount, feeRate, CoinSelection{}, utxos, bc)
+	return tx, err
+}
+
+// NewUTXOTransactionWithSelection creates a new UTXO transaction paying
+// feeRate for every 1000 bytes of the serialized transaction, spending
+// the coins chosen by selection, and reports the coins it spends
+func NewUTXOTransactionWithSelection(wallet *Wallet, to string, amount, feeRate int, selection CoinSelection, utxos OutputFinder, bc *Blockchain) (*Transaction, *CoinSelectionReport, error) {
+	if feeRate < 0 {
+		return nil, nil, errors.New("Negative fee rate")
+	}
+	// The size depends on the inputs needed to pay the fee,
+	// so raise the fee until it covers the size of the transaction
+	fee := 0
+	for {
+		tx, report, err := newUTXOTransaction(wallet, to, amount, fee, selection, utxos, bc)
+		if err != nil {
+			return nil, nil, err
+		}
+		required := FeeForSize(feeRate, tx.Size())
+		if report.Fee >= required {
+			return tx, report, nil
+		}
+		fee = required
+	}
+}
+
+// NewUTXOTransactionWithFee creates a new UTXO transaction leaving fee
+// to the miner of its block, the inputs exceeding amount and fee
+// being sent back as change
+func NewUTXOTransactionWithFee(wallet *Wallet, to string, amount, fee int, utxos OutputFinder, bc *Blockchain) (*Transaction, error) {
+	tx, _, err := newUTXOTransaction(wallet, to, amount, fee, CoinSelection{}, utxos, bc)
+	return tx, err
+}
+
+// newUTXOTransaction creates a new UTXO transaction leaving at least fee
+// to the miner, and spending the coins chosen by selection. A change up to
+// the cost of change of the selection is left to the miner as well.
+func newUTXOTransaction(wallet *Wallet, to string, amount, fee int, selection CoinSelection, utxos OutputFinder, bc *Blockchain) (*Transaction, *CoinSelectionReport, error) {
+	if amount < 0 || fee < 0 {
+		return nil, nil, errors.New("Negative amount or fee")
+	}
 	hashedPubKey := GetPubKeyHashFromAddress(wallet.GetStringAddress())
-	n, spendableOutputs := utxos.FindSpendableOutputs(hashedPubKey, amount)
-	if n < amount {
-		return nil, errors.New("Not enough funds")
+	mature := utxos.MatureOutputs(hashedPubKey, bc.Height()+1, bc.params.CoinbaseMaturity)
+	coins := sortedCoins(mature)
+	selected, strategy, err := selection.Select(coins, amount+fee)
+	if err != nil {
+		return nil, nil, err
+	}
+	n := coinsValue(selected)
+	if n < amount+fee {
+		return nil, nil, errors.New("Not enough funds")
 	}
 
 	var inputs []TXInput
 	var outputs []TXOutput
 	prevTXs := make(map[string]*Transaction)
-	// Create inputs
-	for txID, indexes := range spendableOutputs {
-		for _, i := range indexes {
-			inn := TXInput{Txid: Hex2Bytes(txID), OutIdx: i, PubKey: wallet.PublicKey, Signature: nil}
-			inputs = append(inputs, inn)
-
-		}
-		prevTX, _ := bc.FindTransaction(Hex2Bytes(txID))
-		prevTXs[txID] = prevTX
+	// Create inputs, signed against the outputs they spend
+	// without looking up their transactions in the chain
+	for _, coin := range selected {
+		inn := TXInput{Txid: Hex2Bytes(coin.Outpoint.Txid), OutIdx: coin.Outpoint.Index}
+		inputs = append(inputs, inn)
+		addSpentOutput(prevTXs, inn, mature[coin.Outpoint].TXOutput)
 	}
 
 	//Create outputs
 	output := *NewTXOutput(amount, to)
 	outputs = append(outputs, output)
-	if n-amount > 0 {
-		change := *NewTXOutput(n-amount, wallet.GetStringAddress())
-		outputs = append(outputs, change)
+	change := n - amount - fee
+	if change <= selection.CostOfChange {
+		fee, change = fee+change, 0
+	}
+	if change > 0 {
+		outputs = append(outputs, *NewTXOutput(change, wallet.GetStringAddress()))
 	}
 
-	// Create the transaction
+	// Create the transaction, its ID not covering the signatures
 	tx := &Transaction{Vin: inputs, Vout: outputs}
 	tx.ID = tx.Hash()
 	tx.Sign(wallet.PrivateKey, prevTXs)
-	return tx, nil
+	report := &CoinSelectionReport{Strategy: strategy, Inputs: len(selected), Selected: n, Change: change, Fee: fee}
+	return tx, report, nil
 }
 
-// TrimmedCopy creates a trimmed copy of Transaction to be used in signing
+// TrimmedCopy creates a trimmed copy of Transaction to be used in signing,
+// without the ID since it is computed after signing,
+// nor the unlocking scripts holding the signatures
 func (tx Transaction) TrimmedCopy() Transaction {
 	var inputs []TXInput
 	for _, input := range tx.Vin {
-		inputs = append(inputs, TXInput{Txid: input.Txid, OutIdx: input.OutIdx})
+		inputs = append(inputs, TXInput{Txid: input.Txid, OutIdx: input.OutIdx, Sequence: input.Sequence})
+	}
+	return Transaction{Vin: inputs, Vout: tx.Vout, LockTime: tx.LockTime}
+}
+
+// SignInput returns the SigHashAll signature of the input of index in,
+// spending an output locked with subscript
+func (tx Transaction) SignInput(in int, privKey ecdsa.PrivateKey, subscript Script) []byte {
+	sig, err := tx.SignInputWithType(in, privKey, subscript, SigHashAll)
+	if err != nil {
+		panic(err.Error())
+	}
+	return sig
+}
+
+// SignInputWithType returns the signature of the input of index in,
+// spending an output locked with subscript, followed by the hash type
+func (tx Transaction) SignInputWithType(in int, privKey ecdsa.PrivateKey, subscript Script, hashType SigHashType) ([]byte, error) {
+	hash, err := tx.SignatureHash(in, subscript, hashType)
+	if err != nil {
+		return nil, err
+	}
+	r, s, err := ecdsa.Sign(rand.Reader, &privKey, hash)
+	if err != nil {
+		return nil, err
 	}
-	return Transaction{ID: tx.ID, Vin: inputs, Vout: tx.Vout}
+	// Both S and N-S are valid, only the low one is canonical
+	sig := Signature{R: r, S: s}
+	if !sig.IsLowS() {
+		sig.S.Sub(privKey.Curve.Params().N, s)
+	}
+	return append(sig.Serialize(), byte(hashType)), nil
 }
 
-// Sign signs each input of a Transaction
+// Sign signs the inputs of a Transaction spending the pay-to-pubkey-hash
+// outputs of the key with SigHashAll, the other inputs being left unchanged
 func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]*Transaction) {
+	if err := tx.SignWithType(privKey, prevTXs, SigHashAll); err != nil {
+		panic(err.Error())
+	}
+}
+
+// SignWithType signs the inputs of a Transaction spending the
+// pay-to-pubkey-hash outputs of the key with the given hash type
+func (tx *Transaction) SignWithType(privKey ecdsa.PrivateKey, prevTXs map[string]*Transaction, hashType SigHashType) error {
 	// 1) coinbase transactions are not signed.
 	// 2) Throw a Panic in case of any prevTXs (used inputs) didn't exists
-	// Take a look on the tests to see the expected error message
-	// 3) Create a copy of the transaction to be signed
-	// 4) Sign all the previous TXInputs of the transaction tx using the
-	// copy as the payload (serialized) to be signed in the ecdsa.Sig
-	// (https://golang.org/pkg/crypto/ecdsa/#Sign)
-	// Make sure that each input of the copy to be signed
-	// have the correct PubKeyHash of each output in the prevTXs
-	// Store the signature as a concatenation of R and S fields
+	// 3) Sign each input spending an output locked to the key, over
+	// its signature hash committing to the locking script of the
+	// output, and unlock it with the signature and the public key
 	if tx.IsCoinbase() {
-		return
+		return nil
 	}
 
 	for _, in := range tx.Vin {
 		key := hex.EncodeToString(in.Txid)
 		if _, ok := prevTXs[key]; !ok {
 			panic("Current input transaction isn't listed in previous transactions")
-		} else {
-			// Do more checks?
 		}
 	}
 
-	txCopy := tx.TrimmedCopy()
-	for i := range tx.Vin {
-		r, s, _ := ecdsa.Sign(rand.Reader, &privKey, txCopy.Serialize())
-		sig := append(r.Bytes(), s.Bytes()...)
-		tx.Vin[i].Signature = sig
+	pubKey := pubKeyToByte(privKey.PublicKey)
+	pubKeyHash := HashPubKey(pubKey)
+	for i, in := range tx.Vin {
+		prevTX := prevTXs[hex.EncodeToString(in.Txid)]
+		if in.OutIdx < 0 || in.OutIdx >= len(prevTX.Vout) {
+			continue
+		}
+		prevOut := prevTX.Vout[in.OutIdx]
+		if !prevOut.ScriptPubKey.IsPayToPubKeyHash() || !prevOut.IsLockedWithKey(pubKeyHash) {
+			continue
+		}
+		sig, err := tx.SignInputWithType(i, privKey, prevOut.ScriptPubKey, hashType)
+		if err != nil {
+			return fmt.Errorf("input %d: %w", i, err)
+		}
+		tx.Vin[i].ScriptSig = PayToPubKeyHashScriptSig(sig, pubKey)
 	}
+	return nil
 }
 
 // Verify verifies signatures of Transaction inputs
 func (tx Transaction) Verify(prevTXs map[string]*Transaction) bool {
 	// 1) coinbase transactions are not signed.
 	// 2) Throw a Panic in case of any prevTXs (used inputs) didn't exists
-	// Take a look on the tests to see the expected error message
-	// 3) Create the same copy of the transaction that was signed
-	// and get the curve used for sign: P256
-	// 4) Doing the opposite operation of the signing, perform the
-	// verification of the signature, by recovering the R and S byte fields
-	// of the Signature and the X and Y fields of the PubKey from
-	// the inputs of tx. Verify the signature of each input using the
-	// ecdsa.Verify function (https://golang.org/pkg/crypto/ecdsa/#Verify)
-	// Note that to use this function you need to reconstruct the
-	// ecdsa.PublicKey. Also notice that the ecdsa.Verify function receive
-	// a byte array, you the transaction copy need to be serialized.
-	// return true if all inputs have valid signature,
-	// and false if any of them have an invalid signature.
-
+	// 3) Run the unlocking script of each input with the locking script
+	// of the output it spends, checking the signatures against the
+	// same copy of the transaction that was signed
 	if tx.IsCoinbase() {
 		return true
 	}
@@ -203,35 +354,71 @@ func (tx Transaction) Verify(prevTXs map[string]*Transaction) bool {
 		key := hex.EncodeToString(in.Txid)
 		if _, ok := prevTXs[key]; !ok {
 			panic("Current input transaction isn't listed in previous transactions")
-		} else {
-			// Do more checks?
 		}
 	}
+	return tx.VerifyInputs(prevTXs) == nil
+}
 
-	// txCopy := tx.TrimmedCopy() // Why do we need the copy?
-	for _, input := range tx.Vin {
-		var pubKey ecdsa.PublicKey
-		pubKey.Curve = elliptic.P256()
-
-		x := big.NewInt(0)
-		xBytes := input.PubKey[0 : len(input.PubKey)/2]
-		x.SetBytes(xBytes)
-		pubKey.X = x
-
-		y := big.NewInt(0)
-		yBytes := input.PubKey[len(input.PubKey)/2:]
-		y.SetBytes(yBytes)
-		pubKey.Y = y
-
-		sig := input.Signature
-		r := big.NewInt(0)
-		r.SetBytes(sig[0 : len(sig)/2])
-		s := big.NewInt(0)
-		s.SetBytes(sig[len(sig)/2:])
-
-		if !ecdsa.Verify(&pubKey, tx.Serialize(), r, s) {
-			return false
+// VerifyInputs runs the scripts of the inputs of a non-coinbase
+// transaction, and returns the reason of the first failure. Scripts
+// checking a non-canonical signature fail with ErrNonCanonicalSignature.
+func (tx Transaction) VerifyInputs(prevTXs map[string]*Transaction) error {
+	for i, in := range tx.Vin {
+		prevTX, ok := prevTXs[hex.EncodeToString(in.Txid)]
+		if !ok || in.OutIdx < 0 || in.OutIdx >= len(prevTX.Vout) {
+			return fmt.Errorf("input %d spends a missing output", i)
+		}
+		var sigErr error
+		check := func(sig, pubKey []byte, subscript Script) bool {
+			if len(sig) == 0 {
+				return false
+			}
+			if err := checkSignatureEncoding(sig); err != nil {
+				sigErr = err
+				return false
+			}
+			hash, err := tx.SignatureHash(i, subscript, SigHashType(sig[len(sig)-1]))
+			return err == nil && verifySignature(pubKey, sig[:len(sig)-1], hash)
 		}
+		if err := VerifyScript(in.ScriptSig, prevTX.Vout[in.OutIdx].ScriptPubKey, check); err != nil {
+			if sigErr != nil {
+				err = sigErr
+			}
+			return fmt.Errorf("input %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// checkSignatureEncoding checks that a signature followed by its hash
+// type is the only encoding of its value: a DER signature with S in the
+// lower half of the curve order, and a known hash type
+func checkSignatureEncoding(sig []byte) error {
+	if len(sig) == 0 {
+		return fmt.Errorf("%w: empty", ErrNonCanonicalSignature)
+	}
+	if hashType := SigHashType(sig[len(sig)-1]); !hashType.Valid() {
+		return fmt.Errorf("%w: %s", ErrNonCanonicalSignature, hashType)
+	}
+	parsed, err := ParseSignature(sig[:len(sig)-1])
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNonCanonicalSignature, err)
+	}
+	if !parsed.IsLowS() {
+		return fmt.Errorf("%w: high S", ErrNonCanonicalSignature)
+	}
+	return nil
+}
+
+// verifySignature checks a DER signature of a hash by a SEC1 public key
+func verifySignature(pubKey, sig, hash []byte) bool {
+	key, err := ParsePubKey(pubKey)
+	if err != nil {
+		return false
+	}
+	parsed, err := ParseSignature(sig)
+	if err != nil {
+		return false
 	}
-	return true
+	return ecdsa.Verify(key, hash, parsed.R, parsed.S)
 }
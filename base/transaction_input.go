@@ -2,19 +2,22 @@ package base
 
 import (
 	"bytes"
-	"crypto/sha256"
 )
 
 // TXInput represents a transaction input
 type TXInput struct {
 	Txid      []byte // The ID (i.e. Hash) of the transaction.
 	OutIdx    int    // The index of the output
-	Signature []byte // The signature of this input.
-	PubKey    []byte // The raw public key (not hashed)
+	ScriptSig Script // The script unlocking the output, its witness, or the coinbase data
+	Sequence  uint32 // The relative lock on the output, see SequenceLockDisabled
 }
 
-// UsesKey checks whether the address initiated the transaction
+// UsesKey checks whether the address initiated the transaction,
+// the input unlocking a pay-to-pubkey-hash output with its key
 func (in *TXInput) UsesKey(pubKeyHash []byte) bool {
-	sum := sha256.Sum256(in.PubKey)
-	return bytes.Equal(sum[:], pubKeyHash)
+	ops, err := in.ScriptSig.parse()
+	if err != nil || len(ops) != 2 || !ops[1].isPush() {
+		return false
+	}
+	return bytes.Equal(HashPubKey(ops[1].data), pubKeyHash)
 }
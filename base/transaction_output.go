@@ -7,34 +7,48 @@ import (
 
 // TXOutput represents a transaction output
 type TXOutput struct {
-	Value      int    // The amount
-	PubKeyHash []byte // The hash of the public key (used to "lock" the output)
+	Value        int    // The amount
+	ScriptPubKey Script // The script "locking" the output
 }
 
 // Lock locks the transaction to a specific address
 // Only this address owns this transaction
 func (out *TXOutput) Lock(address string) {
-	// "Lock" the TXOutput to a specific PubKeyHash
-	// based on the given address
-	if !(out.PubKeyHash == nil || len(out.PubKeyHash) == 0) {
-		return // Should not sign if already signed
+	// "Lock" the TXOutput with the script of the address:
+	// pay-to-script-hash for a script address,
+	// pay-to-pubkey-hash otherwise
+	if len(out.ScriptPubKey) != 0 {
+		return // Should not lock if already locked
+	}
+	decoded := Base58Decode([]byte(address))
+	hash := decoded[1 : len(decoded)-addressChecksumLen]
+	if decoded[0] == scriptVersion {
+		out.ScriptPubKey = PayToScriptHashScript(hash)
+	} else {
+		out.ScriptPubKey = PayToPubKeyHashScript(hash)
 	}
-	pubKeyHash := Base58Decode([]byte(address))
-	out.PubKeyHash = pubKeyHash
 }
 
-// IsLockedWithKey checks if the output can be used by the owner of the pubkey
+// IsLockedWithKey checks if the output is locked to the address of the
+// given hash, the hash of a public key or of a redeem script
 func (out *TXOutput) IsLockedWithKey(pubKeyHash []byte) bool {
-	return bytes.Equal(out.PubKeyHash, pubKeyHash)
+	hash := out.ScriptPubKey.AddressHash()
+	return hash != nil && bytes.Equal(hash, pubKeyHash)
 }
 
 // NewTXOutput create a new TXOutput
 func NewTXOutput(value int, address string) *TXOutput {
 	// Create a new locked TXOutput
-	pubKeyHash := Base58Decode([]byte(address))
-	return &TXOutput{value, pubKeyHash[1 : len(pubKeyHash)-4]}
+	out := &TXOutput{Value: value}
+	out.Lock(address)
+	return out
+}
+
+// NewScriptTXOutput creates a new TXOutput locked with the given script
+func NewScriptTXOutput(value int, script Script) *TXOutput {
+	return &TXOutput{Value: value, ScriptPubKey: script}
 }
 
 func (out TXOutput) String() string {
-	return fmt.Sprintf("{%d, %x}", out.Value, out.PubKeyHash)
+	return fmt.Sprintf("{%d, %v}", out.Value, out.ScriptPubKey)
 }
@@ -0,0 +1,91 @@
+package base
+
+import (
+	"crypto/elliptic"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// highS returns the unlocking script of a pay-to-pubkey-hash input
+// with the signature re-encoded with the high S
+func highS(t *testing.T, scriptSig Script) Script {
+	ops, err := scriptSig.parse()
+	assert.NoError(t, err)
+	der := ops[0].data
+	sig, err := ParseSignature(der[:len(der)-1])
+	assert.NoError(t, err)
+	sig.S.Sub(elliptic.P256().Params().N, sig.S)
+	return PayToPubKeyHashScriptSig(append(sig.Serialize(), der[len(der)-1]), ops[1].data)
+}
+
+func TestTransactionIDExcludesWitness(t *testing.T) {
+	from, to := NewWallet(), NewWallet()
+	bc := CreateBlockchainWithParams(from.GetStringAddress(), testChainParams())
+	tx, err := NewUTXOTransaction(from, to.GetStringAddress(), 4, bc.UTXOSet(), bc)
+	assert.NoError(t, err)
+	id, witnessHash := tx.ID, tx.WitnessHash()
+	assert.Equal(t, id, tx.Hash())
+	assert.NotEqual(t, id, witnessHash)
+
+	// Signing again changes the witness only
+	bc.SignTransaction(tx, from.PrivateKey)
+	assert.Equal(t, id, tx.ID)
+	assert.NotEqual(t, witnessHash, tx.WitnessHash())
+	tx.Vin[0].ScriptSig = nil
+	assert.Equal(t, id, tx.Hash())
+	tx.Vout[0].Value++
+	assert.NotEqual(t, id, tx.Hash())
+
+	// The data of a coinbase is part of its ID
+	coinbase := NewCoinbaseTX(to.GetStringAddress(), "a")
+	assert.Equal(t, coinbase.WitnessHash(), coinbase.Hash())
+	assert.NotEqual(t, coinbase.ID, NewCoinbaseTX(to.GetStringAddress(), "b").ID)
+}
+
+func TestSignaturesHaveLowS(t *testing.T) {
+	wallet := NewWallet()
+	tx := Transaction{Vin: []TXInput{{Txid: []byte{1}}}}
+	subscript := PayToPubKeyHashScript(HashPubKey(wallet.PublicKey))
+	for i := 0; i < 20; i++ {
+		sig := tx.SignInput(0, wallet.PrivateKey, subscript)
+		assert.NoError(t, checkSignatureEncoding(sig))
+	}
+
+	sig := tx.SignInput(0, wallet.PrivateKey, subscript)
+	assert.True(t, errors.Is(checkSignatureEncoding(sig[1:]), ErrNonCanonicalSignature))
+	sig[len(sig)-1] = 0
+	assert.True(t, errors.Is(checkSignatureEncoding(sig), ErrNonCanonicalSignature))
+	zero := append(Signature{R: big.NewInt(0), S: big.NewInt(1)}.Serialize(), byte(SigHashAll))
+	assert.True(t, errors.Is(checkSignatureEncoding(zero), ErrNonCanonicalSignature))
+}
+
+func TestValidateBlockRejectsMalleatedSignatures(t *testing.T) {
+	from, to := NewWallet(), NewWallet()
+	address := from.GetStringAddress()
+	bc := CreateBlockchainWithParams(address, testChainParams())
+	tx, err := NewUTXOTransaction(from, to.GetStringAddress(), 4, bc.UTXOSet(), bc)
+	assert.NoError(t, err)
+	prevTXs, err := bc.GetInputTXsOf(tx)
+	assert.NoError(t, err)
+
+	// The high S signature is valid ECDSA, with the same transaction ID
+	malleated := copyTransaction(*tx)
+	malleated.Vin[0].ScriptSig = highS(t, tx.Vin[0].ScriptSig)
+	assert.Equal(t, tx.ID, malleated.Hash())
+	assert.True(t, errors.Is(malleated.VerifyInputs(prevTXs), ErrNonCanonicalSignature))
+	_, err = bc.AddBlock([]*Transaction{NewCoinbaseTX(address, ""), &malleated})
+	assertRule(t, RuleSignature, err)
+
+	block, err := bc.AddBlock([]*Transaction{NewCoinbaseTX(address, ""), tx})
+	assert.NoError(t, err)
+	assert.NotEqual(t, block.HashTransactions(), block.HashWitnesses())
+
+	// The header commits to the witness
+	root := block.HashTransactions()
+	block.Transactions[1] = &malleated
+	assert.Equal(t, root, block.HashTransactions())
+	assert.False(t, NewProofOfWork(block).Validate())
+}
@@ -0,0 +1,404 @@
+package base
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Files of a persisted UTXODB
+const (
+	utxoSnapshotFileName = "utxos.snapshot"
+	utxoJournalFileName  = "utxos.journal"
+)
+
+// utxoCompactInterval is the number of journal records
+// after which the journal is folded into a new snapshot
+const utxoCompactInterval = 256
+
+// UTXOUndoDepth is the number of most recent blocks whose undo records
+// a UTXODB keeps, which bounds the depth of a reorganization
+const UTXOUndoDepth = 100
+
+// UTXODB is a UTXO set indexed by the PubKeyHash of its outputs, with the
+// undo records of the last UTXOUndoDepth blocks connected to it. Older undo
+// records are dropped, so that its size does not grow with the chain.
+// A UTXODB opened in a directory persists a snapshot of its state and
+// a journal of the blocks connected and disconnected since the snapshot.
+type UTXODB struct {
+	set    UTXOSet
+	owners map[string]map[Outpoint]bool // outpoints by hex encoded PubKeyHash
+	undos  map[string]*BlockUndo        // by block hash
+	tip    []byte                       // the last connected block
+	seq    uint64                       // sequence number of the last change
+
+	dir       string
+	journal   *os.File
+	journaled int // records in the journal
+}
+
+// utxoEntry is an unspent output with its outpoint
+type utxoEntry struct {
+	Outpoint Outpoint
+	Output   UTXO
+}
+
+// utxoSnapshot is the persisted state of a UTXODB
+type utxoSnapshot struct {
+	Seq     uint64
+	Tip     []byte
+	Outputs []utxoEntry
+	Undos   map[string]*BlockUndo
+}
+
+// utxoRecord is a journal record of a block connected to a UTXODB,
+// or disconnected from it
+type utxoRecord struct {
+	Seq        uint64
+	Hash       []byte
+	Tip        []byte // the tip after the change
+	Disconnect bool
+	Undo       *BlockUndo  // changes of a connected block
+	Created    []utxoEntry // outputs added by a connected block
+}
+
+// NewUTXODB creates an empty UTXODB kept in memory
+func NewUTXODB() *UTXODB {
+	return &UTXODB{
+		set:    make(UTXOSet),
+		owners: make(map[string]map[Outpoint]bool),
+		undos:  make(map[string]*BlockUndo),
+	}
+}
+
+// OpenUTXODB opens or creates a UTXODB persisted in dir.
+// A partial record at the end of the journal, for instance after
+// a crash, is dropped.
+func OpenUTXODB(dir string) (*UTXODB, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	db := NewUTXODB()
+	db.dir = dir
+
+	raw, err := ioutil.ReadFile(filepath.Join(dir, utxoSnapshotFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		snapshot, err := deserializeUTXOSnapshot(raw)
+		if err != nil {
+			return nil, fmt.Errorf("corrupted UTXO snapshot: %v", err)
+		}
+		db.seq, db.tip = snapshot.Seq, snapshot.Tip
+		for _, entry := range snapshot.Outputs {
+			db.add(entry.Outpoint, entry.Output)
+		}
+		if snapshot.Undos != nil {
+			db.undos = snapshot.Undos
+		}
+	}
+
+	db.journal, err = os.OpenFile(filepath.Join(dir, utxoJournalFileName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.replayJournal(); err != nil {
+		db.journal.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// replayJournal applies the records of the journal newer than the
+// snapshot, and truncates the journal after the last complete record
+func (db *UTXODB) replayJournal() error {
+	raw, err := ioutil.ReadAll(db.journal)
+	if err != nil {
+		return err
+	}
+
+	var end int64
+	r := bytes.NewReader(raw)
+	for r.Len() > 0 {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			break
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			break
+		}
+		record, err := deserializeUTXORecord(payload)
+		if err != nil {
+			break
+		}
+		end += 4 + int64(size)
+		db.journaled++
+		if record.Seq <= db.seq {
+			continue // already in the snapshot
+		}
+		if err := db.apply(record); err != nil {
+			return fmt.Errorf("corrupted UTXO journal: %v", err)
+		}
+	}
+
+	// Drop a partially written record
+	if end < int64(len(raw)) {
+		if err := db.journal.Truncate(end); err != nil {
+			return err
+		}
+	}
+	_, err = db.journal.Seek(end, io.SeekStart)
+	return err
+}
+
+// apply replays a journal record
+func (db *UTXODB) apply(record *utxoRecord) error {
+	if record.Disconnect {
+		undo, ok := db.undos[hex.EncodeToString(record.Hash)]
+		if !ok {
+			return fmt.Errorf("no undo record of block %x", record.Hash)
+		}
+		db.revert(record.Hash, undo)
+	} else {
+		for _, spent := range record.Undo.Spent {
+			db.remove(spent.Outpoint)
+		}
+		for _, entry := range record.Created {
+			db.add(entry.Outpoint, entry.Output)
+		}
+		db.undos[hex.EncodeToString(record.Hash)] = record.Undo
+		db.pruneUndos(record.Undo.Height)
+	}
+	db.seq, db.tip = record.Seq, record.Tip
+	return nil
+}
+
+// pruneUndos drops the undo records of the blocks more than
+// UTXOUndoDepth blocks below the tip at the given height
+func (db *UTXODB) pruneUndos(height int) {
+	for key, undo := range db.undos {
+		if undo.Height <= height-UTXOUndoDepth {
+			delete(db.undos, key)
+		}
+	}
+}
+
+func (db *UTXODB) add(op Outpoint, output UTXO) {
+	db.set[op] = output
+	owner := hex.EncodeToString(output.ScriptPubKey.AddressHash())
+	if db.owners[owner] == nil {
+		db.owners[owner] = make(map[Outpoint]bool)
+	}
+	db.owners[owner][op] = true
+}
+
+func (db *UTXODB) remove(op Outpoint) {
+	output, ok := db.set[op]
+	if !ok {
+		return
+	}
+	delete(db.set, op)
+	owner := hex.EncodeToString(output.ScriptPubKey.AddressHash())
+	delete(db.owners[owner], op)
+	if len(db.owners[owner]) == 0 {
+		delete(db.owners, owner)
+	}
+}
+
+// revert reverts the changes of a block, as UTXOSet.Revert
+func (db *UTXODB) revert(hash []byte, undo *BlockUndo) {
+	for _, spent := range undo.Spent {
+		db.add(spent.Outpoint, spent.Output)
+	}
+	for _, op := range undo.Created {
+		db.remove(op)
+	}
+	delete(db.undos, hex.EncodeToString(hash))
+}
+
+// Connect updates the database with the transactions of the block
+// at the given height, which becomes its tip
+func (db *UTXODB) Connect(block *Block, height int) error {
+	// Compute the changes on the set, and revert them
+	// until they are journaled
+	undo, err := db.set.Update(block.Transactions, height)
+	if err != nil {
+		return err
+	}
+	record := &utxoRecord{Seq: db.seq + 1, Hash: block.Hash, Tip: block.Hash, Undo: undo}
+	for _, op := range undo.Created {
+		if output, ok := db.set[op]; ok {
+			record.Created = append(record.Created, utxoEntry{Outpoint: op, Output: output})
+		}
+	}
+	db.set.Revert(undo)
+
+	return db.commit(record)
+}
+
+// Disconnect reverts the changes of the block of the given hash,
+// which must be the tip, making prevHash the new tip
+func (db *UTXODB) Disconnect(hash, prevHash []byte) error {
+	if !bytes.Equal(hash, db.tip) {
+		return fmt.Errorf("block %x is not the tip of the UTXO database", hash)
+	}
+	if _, ok := db.undos[hex.EncodeToString(hash)]; !ok {
+		return fmt.Errorf("no undo record of block %x", hash)
+	}
+	return db.commit(&utxoRecord{Seq: db.seq + 1, Hash: hash, Tip: prevHash, Disconnect: true})
+}
+
+// commit writes a record to the journal and applies it
+func (db *UTXODB) commit(record *utxoRecord) error {
+	if db.journal != nil {
+		payload := record.serialize()
+		entry := make([]byte, 4+len(payload))
+		binary.BigEndian.PutUint32(entry, uint32(len(payload)))
+		copy(entry[4:], payload)
+		if _, err := db.journal.Write(entry); err != nil {
+			return err
+		}
+		if err := db.journal.Sync(); err != nil {
+			return err
+		}
+		db.journaled++
+	}
+	if err := db.apply(record); err != nil {
+		return err
+	}
+	if db.journal != nil && db.journaled >= utxoCompactInterval {
+		return db.compact()
+	}
+	return nil
+}
+
+// compact writes a snapshot of the database and empties the journal
+func (db *UTXODB) compact() error {
+	snapshot := utxoSnapshot{Seq: db.seq, Tip: db.tip, Undos: db.undos}
+	for op, output := range db.set {
+		snapshot.Outputs = append(snapshot.Outputs, utxoEntry{Outpoint: op, Output: output})
+	}
+	tmp := filepath.Join(db.dir, utxoSnapshotFileName+".tmp")
+	if err := ioutil.WriteFile(tmp, snapshot.serialize(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(db.dir, utxoSnapshotFileName)); err != nil {
+		return err
+	}
+
+	// The records are in the snapshot, which skips them if the
+	// journal is not truncated
+	if err := db.journal.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := db.journal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	db.journaled = 0
+	return nil
+}
+
+// Reset empties the database
+func (db *UTXODB) Reset() error {
+	db.set = make(UTXOSet)
+	db.owners = make(map[string]map[Outpoint]bool)
+	db.undos = make(map[string]*BlockUndo)
+	db.tip = nil
+	db.seq++
+	if db.journal == nil {
+		return nil
+	}
+	return db.compact()
+}
+
+// Close closes the journal of a persisted database
+func (db *UTXODB) Close() error {
+	if db.journal == nil {
+		return nil
+	}
+	return db.journal.Close()
+}
+
+// Tip returns the hash of the last block connected to the database
+func (db *UTXODB) Tip() []byte {
+	return db.tip
+}
+
+// Set returns the unspent outputs, which must not be modified by the caller
+func (db *UTXODB) Set() UTXOSet {
+	return db.set
+}
+
+// Outputs returns the unspent outputs locked with pubKeyHash
+func (db *UTXODB) Outputs(pubKeyHash []byte) map[Outpoint]UTXO {
+	outputs := make(map[Outpoint]UTXO)
+	for op := range db.owners[hex.EncodeToString(pubKeyHash)] {
+		outputs[op] = db.set[op]
+	}
+	return outputs
+}
+
+// Balance returns the value of the unspent outputs locked with pubKeyHash
+func (db *UTXODB) Balance(pubKeyHash []byte) int {
+	balance := 0
+	for op := range db.owners[hex.EncodeToString(pubKeyHash)] {
+		balance += db.set[op].Value
+	}
+	return balance
+}
+
+// FindMatureOutputs finds and returns the unspent outputs locked with
+// pubKeyHash that a block at the given height may spend, looking only
+// at the outputs of pubKeyHash
+func (db *UTXODB) FindMatureOutputs(pubKeyHash []byte, amount, height, maturity int) (int, map[string][]int) {
+	return UTXOSet(db.Outputs(pubKeyHash)).FindMatureOutputs(pubKeyHash, amount, height, maturity)
+}
+
+// MatureOutputs returns the unspent outputs locked with pubKeyHash
+// that a block at the given height may spend
+func (db *UTXODB) MatureOutputs(pubKeyHash []byte, height, maturity int) map[Outpoint]UTXO {
+	return UTXOSet(db.Outputs(pubKeyHash)).MatureOutputs(pubKeyHash, height, maturity)
+}
+
+// checkIndex checks that the address index matches the outputs
+func (db *UTXODB) checkIndex() error {
+	indexed := 0
+	for owner, ops := range db.owners {
+		for op := range ops {
+			output, ok := db.set[op]
+			if !ok || hex.EncodeToString(output.ScriptPubKey.AddressHash()) != owner {
+				return fmt.Errorf("index of %s has the output %s", owner, op)
+			}
+		}
+		indexed += len(ops)
+	}
+	if indexed != len(db.set) {
+		return fmt.Errorf("%d outputs indexed out of %d", indexed, len(db.set))
+	}
+	return nil
+}
+
+// String lists the outputs of the database by outpoint
+func (db *UTXODB) String() string {
+	ops := make([]Outpoint, 0, len(db.set))
+	for op := range db.set {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].String() < ops[j].String() })
+
+	var lines []string
+	lines = append(lines, fmt.Sprintf("--- UTXO DB at %x:", db.tip))
+	for _, op := range ops {
+		lines = append(lines, fmt.Sprintf("     Output %s: %v", op, db.set[op]))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,164 @@
+package base
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fileBlockchain creates a chain with n blocks in a FileBlockStore in dir
+func fileBlockchain(t *testing.T, dir string, wallet *Wallet, n int) *Blockchain {
+	store, err := OpenFileBlockStore(dir)
+	assert.NoError(t, err)
+	bc, err := NewBlockchain(store, wallet.GetStringAddress(), testChainParams())
+	assert.NoError(t, err)
+	for i := 0; i < n; i++ {
+		_, err := bc.AddBlock([]*Transaction{NewCoinbaseTX(wallet.GetStringAddress(), "")})
+		assert.NoError(t, err)
+	}
+	return bc
+}
+
+func reopenBlockchain(t *testing.T, dir string) *Blockchain {
+	store, err := OpenFileBlockStore(dir)
+	assert.NoError(t, err)
+	bc, err := OpenBlockchain(store)
+	assert.NoError(t, err)
+	return bc
+}
+
+func TestUTXODBPersistsWithTheChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "utxodb")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	from, to := NewWallet(), NewWallet()
+	bc := fileBlockchain(t, dir, from, 2)
+	tx, err := NewUTXOTransaction(from, to.GetStringAddress(), 4, bc.UTXODB(), bc)
+	assert.NoError(t, err)
+	_, err = bc.AddBlock([]*Transaction{NewCoinbaseTX(from.GetStringAddress(), ""), tx})
+	assert.NoError(t, err)
+	assert.NoError(t, bc.Close())
+
+	reopened := reopenBlockchain(t, dir)
+	defer reopened.Close()
+	assert.Equal(t, reopened.CurrentBlock().Hash, reopened.UTXODB().Tip())
+	assert.NoError(t, reopened.CheckUTXOs())
+	assert.Equal(t, 4, reopened.UTXODB().Balance(HashPubKey(to.PublicKey)))
+	assert.Equal(t, 4*BlockReward-4, reopened.UTXODB().Balance(HashPubKey(from.PublicKey)))
+}
+
+func TestUTXODBReindexesFromTheChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "utxodb")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	bc := fileBlockchain(t, dir, NewWallet(), 3)
+	utxos := bc.FindUTXOSet()
+	assert.NoError(t, bc.Close())
+
+	// Lose the UTXO database
+	assert.NoError(t, os.Remove(filepath.Join(dir, utxoSnapshotFileName)))
+	assert.NoError(t, os.Remove(filepath.Join(dir, utxoJournalFileName)))
+
+	reopened := reopenBlockchain(t, dir)
+	defer reopened.Close()
+	assert.NoError(t, reopened.CheckUTXOs())
+	assert.True(t, utxos.Equal(reopened.UTXOSet()))
+
+	// A tampered database fails the check until it is reindexed
+	reopened.UTXODB().remove(NewOutpoint(reopened.CurrentBlock().Transactions[0].ID, 0))
+	assert.Error(t, reopened.CheckUTXOs())
+	assert.NoError(t, reopened.ReindexUTXOs())
+	assert.NoError(t, reopened.CheckUTXOs())
+}
+
+func TestUTXODBReplaysJournalAfterCompaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "utxodb")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	address := NewWallet().GetStringAddress()
+	db, err := OpenUTXODB(dir)
+	assert.NoError(t, err)
+	var blocks []*Block
+	prev := []byte{}
+	for height := 0; height < utxoCompactInterval+3; height++ {
+		block := &Block{Hash: []byte{byte(height >> 8), byte(height)}, PrevBlockHash: prev,
+			Transactions: []*Transaction{NewCoinbaseTX(address, "")}}
+		assert.NoError(t, db.Connect(block, height))
+		blocks = append(blocks, block)
+		prev = block.Hash
+	}
+	last := blocks[len(blocks)-1]
+	assert.NoError(t, db.Disconnect(last.Hash, last.PrevBlockHash))
+	assert.Equal(t, (utxoCompactInterval+4)%utxoCompactInterval, db.journaled)
+	assert.NoError(t, db.Close())
+
+	// Leave a partially written record behind
+	f, err := os.OpenFile(filepath.Join(dir, utxoJournalFileName), os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	f.Write([]byte{0, 0, 1})
+	f.Close()
+
+	reopened, err := OpenUTXODB(dir)
+	assert.NoError(t, err)
+	defer reopened.Close()
+	assert.Equal(t, last.PrevBlockHash, reopened.Tip())
+	assert.True(t, db.Set().Equal(reopened.Set()))
+	assert.NoError(t, reopened.checkIndex())
+	assert.NoError(t, reopened.Disconnect(last.PrevBlockHash, blocks[len(blocks)-3].Hash))
+}
+
+func TestUTXODBIndexesOutputsByOwner(t *testing.T) {
+	from, to := NewWallet(), NewWallet()
+	bc := CreateBlockchainWithParams(from.GetStringAddress(), testChainParams())
+	db := bc.UTXODB()
+
+	tx, err := NewUTXOTransaction(from, to.GetStringAddress(), 4, db, bc)
+	assert.NoError(t, err)
+	_, err = bc.AddBlock([]*Transaction{NewCoinbaseTX(to.GetStringAddress(), ""), tx})
+	assert.NoError(t, err)
+
+	assert.Len(t, db.Outputs(HashPubKey(from.PublicKey)), 1)
+	assert.Len(t, db.Outputs(HashPubKey(to.PublicKey)), 2)
+	assert.Equal(t, BlockReward+4, db.Balance(HashPubKey(to.PublicKey)))
+	sum, outputs := db.FindMatureOutputs(HashPubKey(to.PublicKey), BlockReward+1, bc.Height()+1, 0)
+	assert.Equal(t, BlockReward+4, sum)
+	assert.Len(t, outputs, 2)
+	assert.NoError(t, db.checkIndex())
+}
+
+func TestUTXODBKeepsUndoRecordsOfRecentBlocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "utxodb")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	address := NewWallet().GetStringAddress()
+	db, err := OpenUTXODB(dir)
+	assert.NoError(t, err)
+	var blocks []*Block
+	prev := []byte{}
+	for height := 0; height < 2*utxoCompactInterval+3; height++ {
+		block := &Block{Hash: []byte{byte(height >> 8), byte(height)}, PrevBlockHash: prev,
+			Transactions: []*Transaction{NewCoinbaseTX(address, "")}}
+		assert.NoError(t, db.Connect(block, height))
+		blocks = append(blocks, block)
+		prev = block.Hash
+	}
+	assert.Len(t, db.undos, UTXOUndoDepth)
+	assert.NoError(t, db.Close())
+
+	reopened, err := OpenUTXODB(dir)
+	assert.NoError(t, err)
+	defer reopened.Close()
+	assert.Len(t, reopened.undos, UTXOUndoDepth)
+	for i := len(blocks) - 1; i >= len(blocks)-UTXOUndoDepth; i-- {
+		assert.NoError(t, reopened.Disconnect(blocks[i].Hash, blocks[i].PrevBlockHash))
+	}
+	deepest := blocks[len(blocks)-UTXOUndoDepth-1]
+	assert.Error(t, reopened.Disconnect(deepest.Hash, deepest.PrevBlockHash))
+}
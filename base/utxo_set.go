@@ -3,93 +3,174 @@ package base
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"strings"
 )
 
+// Errors of a UTXO set update
+var (
+	ErrMissingOutput   = errors.New("output is spent or does not exist")
+	ErrDoubleSpend     = errors.New("output is spent twice")
+	ErrDuplicateOutput = errors.New("output already exists")
+)
+
+// Outpoint identifies a transaction output
+type Outpoint struct {
+	Txid  string // the ID of the transaction, hex encoded
+	Index int    // the index of the output in the transaction
+}
+
+// NewOutpoint returns the outpoint of the output of index outIdx
+// of the transaction with the given ID
+func NewOutpoint(txID []byte, outIdx int) Outpoint {
+	return Outpoint{Txid: hex.EncodeToString(txID), Index: outIdx}
+}
+
+func (o Outpoint) String() string {
+	return fmt.Sprintf("%s:%d", o.Txid, o.Index)
+}
+
+// UTXO is an unspent transaction output, with the height
+// and the kind of the transaction that created it
+type UTXO struct {
+	TXOutput
+	Height   int  // height of the block of the transaction
+	Coinbase bool // set when created by a coinbase transaction
+}
+
+// IsMature checks if the output may be spent by a block at the given
+// height, coinbase outputs needing maturity confirmations
+func (u UTXO) IsMature(height, maturity int) bool {
+	return !u.Coinbase || height-u.Height >= maturity
+}
+
+// SpentOutput is an output spent by a block, kept to restore it
+type SpentOutput struct {
+	Outpoint Outpoint
+	Output   UTXO
+}
+
+// BlockUndo records the changes of a block to the UTXO set,
+// so that they can be reverted when the block is disconnected
+type BlockUndo struct {
+	Height  int           // height of the block
+	Spent   []SpentOutput // outputs spent by the block, in spending order
+	Created []Outpoint    // outputs created by the block
+}
+
+// OutputFinder finds the unspent outputs a wallet may spend
+type OutputFinder interface {
+	MatureOutputs(pubKeyHash []byte, height, maturity int) map[Outpoint]UTXO
+}
+
 // UTXOSet represents a set of UTXO as an in-memory cache
-// The key of the map is the transaction ID
-// (encoded as string) that contains these outputs
-type UTXOSet map[string][]TXOutput
+// The key of the map is the outpoint of the output
+type UTXOSet map[Outpoint]UTXO
 
 // FindSpendableOutputs finds and returns unspent outputs in the UTXO Set
 // to reference in inputs
 func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
-	// Modify your function to use IsLockedWithKey instead of CanBeUnlockedWith
+	return u.FindMatureOutputs(pubKeyHash, amount, 0, 0)
+}
+
+// FindMatureOutputs finds and returns the unspent outputs in the UTXO Set
+// that a block at the given height may spend, to reference in inputs.
+// The outputs are taken in outpoint order until they reach amount.
+func (u UTXOSet) FindMatureOutputs(pubKeyHash []byte, amount, height, maturity int) (int, map[string][]int) {
 	spendable := make(map[string][]int)
 	sum := 0
-	for txID, outputs := range u {
-		var out []int
-		for i, output := range outputs {
-
-			if (&output).IsLockedWithKey(pubKeyHash) {
-				if sum < amount { // Only append enough outputs
-					out = append(out, i)
-					sum += output.Value
-				} else {
-					break
-				}
-			}
-		}
-		spendable[txID] = out
+	for _, coin := range accumulate(sortedCoins(u.MatureOutputs(pubKeyHash, height, maturity)), amount) {
+		spendable[coin.Outpoint.Txid] = append(spendable[coin.Outpoint.Txid], coin.Outpoint.Index)
+		sum += coin.Value
 	}
-
 	return sum, spendable
 }
 
+// MatureOutputs returns the unspent outputs locked with pubKeyHash
+// that a block at the given height may spend
+func (u UTXOSet) MatureOutputs(pubKeyHash []byte, height, maturity int) map[Outpoint]UTXO {
+	outputs := make(map[Outpoint]UTXO)
+	for op, output := range u {
+		if output.IsLockedWithKey(pubKeyHash) && output.IsMature(height, maturity) {
+			outputs[op] = output
+		}
+	}
+	return outputs
+}
+
 // FindUTXO finds UTXO in the UTXO Set for a given unlockingData key (e.g., address)
 func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TXOutput {
-	// Modify your function to use IsLockedWithKey instead of CanBeUnlockedWith
 	var UTXO []TXOutput
-	for _, outputs := range u {
-		for _, output := range outputs {
-			if (&output).IsLockedWithKey(pubKeyHash) {
-				UTXO = append(UTXO, output)
-			}
+	for _, output := range u {
+		if output.IsLockedWithKey(pubKeyHash) {
+			UTXO = append(UTXO, output.TXOutput)
 		}
 	}
 	return UTXO
 }
 
+// Balance returns the value of the unspent outputs locked with pubKeyHash
+func (u UTXOSet) Balance(pubKeyHash []byte) int {
+	balance := 0
+	for _, output := range u.FindUTXO(pubKeyHash) {
+		balance += output.Value
+	}
+	return balance
+}
+
 // CountUTXOs returns the number of transactions outputs in the UTXO set
 func (u UTXOSet) CountUTXOs() int {
-	count := 0
-	for _, outputs := range u {
-		count += len(outputs)
-	}
-	return count
+	return len(u)
 }
 
 // Update updates the UTXO Set with the new set of transactions
-func (u UTXOSet) Update(transactions []*Transaction) {
+// of the block at the given height, and returns the record to revert it.
+// The set is left unchanged when a transaction spends a missing output,
+// or creates an output that already exists.
+func (u UTXOSet) Update(transactions []*Transaction, height int) (*BlockUndo, error) {
+	undo := &BlockUndo{Height: height}
+	spent := make(map[Outpoint]bool)
 	for _, tx := range transactions {
-
-		// Find and delete outputs that are linked to inputs
-		for _, input := range tx.Vin {
-			key := hex.EncodeToString(input.Txid)
-			if _, ok := u[key]; ok {
-				var newOutputs []TXOutput
-				for i, output := range u[key] {
-					if i != input.OutIdx {
-						newOutputs = append(newOutputs, output)
+		if !tx.IsCoinbase() {
+			for _, input := range tx.Vin {
+				op := NewOutpoint(input.Txid, input.OutIdx)
+				output, ok := u[op]
+				if !ok {
+					u.Revert(undo)
+					if spent[op] {
+						return nil, fmt.Errorf("%w: %s", ErrDoubleSpend, op)
 					}
+					return nil, fmt.Errorf("%w: %s", ErrMissingOutput, op)
 				}
-
-				if len(newOutputs) == 0 {
-					delete(u, key)
-				} else {
-					u[key] = newOutputs
-				}
-
+				delete(u, op)
+				spent[op] = true
+				undo.Spent = append(undo.Spent, SpentOutput{Outpoint: op, Output: output})
 			}
 		}
 
-		// Add all output of this tx as UTXO for now
-		for _, output := range tx.Vout {
-			key := hex.EncodeToString(tx.ID)
-			u[key] = append(u[key], output)
+		for i, output := range tx.Vout {
+			op := NewOutpoint(tx.ID, i)
+			if _, ok := u[op]; ok {
+				u.Revert(undo)
+				return nil, fmt.Errorf("%w: %s", ErrDuplicateOutput, op)
+			}
+			u[op] = UTXO{TXOutput: output, Height: height, Coinbase: tx.IsCoinbase()}
+			undo.Created = append(undo.Created, op)
 		}
 	}
+	return undo, nil
+}
+
+// Revert reverts the update of a block, restoring the outputs it spent
+// and removing the outputs it created, including those it spent itself
+func (u UTXOSet) Revert(undo *BlockUndo) {
+	for _, spent := range undo.Spent {
+		u[spent.Outpoint] = spent.Output
+	}
+	for _, op := range undo.Created {
+		delete(u, op)
+	}
 }
 
 // Equal compares two UTXOSet
@@ -98,18 +179,12 @@ func (u UTXOSet) Equal(utxos UTXOSet) bool {
 		return false
 	}
 
-	for txid, outputs := range u {
-		o, ok := utxos[txid]
-
-		if !ok || len(outputs) != len(o) {
+	for op, out := range u {
+		o, ok := utxos[op]
+		if !ok || out.Value != o.Value || !bytes.Equal(out.ScriptPubKey, o.ScriptPubKey) ||
+			out.Height != o.Height || out.Coinbase != o.Coinbase {
 			return false
 		}
-
-		for i, out := range outputs {
-			if out.Value != o[i].Value || !bytes.Equal(out.PubKeyHash, o[i].PubKeyHash) {
-				return false
-			}
-		}
 	}
 
 	return true
@@ -119,11 +194,8 @@ func (u UTXOSet) String() string {
 	var lines []string
 
 	lines = append(lines, fmt.Sprintf("--- UTXO SET:"))
-	for txid, outputs := range u {
-		lines = append(lines, fmt.Sprintf("     TxID: %s", txid))
-		for i, out := range outputs {
-			lines = append(lines, fmt.Sprintf("           Output %d: %v", i, out))
-		}
+	for op, out := range u {
+		lines = append(lines, fmt.Sprintf("     Output %s: %v", op, out))
 	}
 
 	return strings.Join(lines, "\n")
@@ -0,0 +1,113 @@
+package base
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// spendTX creates an unsigned transaction spending the given outputs
+// of prev, with outputs of the given values locked to address
+func spendTX(prev *Transaction, outIdxs []int, address string, values ...int) *Transaction {
+	tx := &Transaction{}
+	for _, i := range outIdxs {
+		tx.Vin = append(tx.Vin, TXInput{Txid: prev.ID, OutIdx: i})
+	}
+	for _, value := range values {
+		tx.Vout = append(tx.Vout, *NewTXOutput(value, address))
+	}
+	tx.ID = tx.Hash()
+	return tx
+}
+
+func copyUTXOSet(u UTXOSet) UTXOSet {
+	c := make(UTXOSet)
+	for op, output := range u {
+		c[op] = output
+	}
+	return c
+}
+
+func TestUTXOSetUpdateSpendsByOutpoint(t *testing.T) {
+	wallet := NewWallet()
+	address := wallet.GetStringAddress()
+	utxos := make(UTXOSet)
+	coinbase := NewCoinbaseTX(address, "")
+	split := spendTX(coinbase, []int{0}, address, 3, 7)
+
+	_, err := utxos.Update([]*Transaction{coinbase}, 0)
+	assert.NoError(t, err)
+	_, err = utxos.Update([]*Transaction{split}, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, utxos.CountUTXOs())
+
+	// Spending the first output must not shift the second one
+	_, err = utxos.Update([]*Transaction{spendTX(split, []int{0}, address, 3)}, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, utxos[NewOutpoint(split.ID, 1)].Value)
+	_, err = utxos.Update([]*Transaction{spendTX(split, []int{1}, address, 7)}, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, utxos.CountUTXOs())
+	assert.Equal(t, BlockReward, utxos.Balance(HashPubKey(wallet.PublicKey)))
+}
+
+func TestUTXOSetUpdateRejectsMissingAndSpentOutputs(t *testing.T) {
+	address := NewWallet().GetStringAddress()
+	utxos := make(UTXOSet)
+	coinbase := NewCoinbaseTX(address, "")
+	_, err := utxos.Update([]*Transaction{coinbase}, 0)
+	assert.NoError(t, err)
+	before := copyUTXOSet(utxos)
+
+	spend := spendTX(coinbase, []int{0}, address, 4)
+	respend := spendTX(coinbase, []int{0}, address, 5)
+	_, err = utxos.Update([]*Transaction{spend, respend}, 1)
+	assert.True(t, errors.Is(err, ErrDoubleSpend))
+	assert.True(t, before.Equal(utxos), "a failed update must leave the set unchanged")
+
+	_, err = utxos.Update([]*Transaction{spendTX(coinbase, []int{1}, address, 4)}, 1)
+	assert.True(t, errors.Is(err, ErrMissingOutput))
+	_, err = utxos.Update([]*Transaction{coinbase}, 1)
+	assert.True(t, errors.Is(err, ErrDuplicateOutput))
+	assert.True(t, before.Equal(utxos))
+}
+
+func TestUTXOSetRevertUndoesUpdates(t *testing.T) {
+	address := NewWallet().GetStringAddress()
+	utxos := make(UTXOSet)
+	coinbase := NewCoinbaseTX(address, "")
+	_, err := utxos.Update([]*Transaction{coinbase}, 0)
+	assert.NoError(t, err)
+	before := copyUTXOSet(utxos)
+
+	// A block spending an output it creates
+	split := spendTX(coinbase, []int{0}, address, 3, 7)
+	merge := spendTX(split, []int{0, 1}, address, 10)
+	undo, err := utxos.Update([]*Transaction{NewCoinbaseTX(address, ""), split, merge}, 1)
+	assert.NoError(t, err)
+	assert.Len(t, undo.Spent, 3)
+	assert.Equal(t, 2, utxos.CountUTXOs())
+
+	utxos.Revert(undo)
+	assert.True(t, before.Equal(utxos))
+}
+
+func TestReorganizeRevertsUndoRecords(t *testing.T) {
+	from, to := NewWallet(), NewWallet()
+	address := from.GetStringAddress()
+	bc := CreateBlockchainWithParams(address, testChainParams())
+	fork := bc.CurrentBlock()
+
+	tx, err := NewUTXOTransaction(from, to.GetStringAddress(), 4, bc.UTXOSet(), bc)
+	assert.NoError(t, err)
+	_, err = bc.AddBlock([]*Transaction{NewCoinbaseTX(address, ""), tx})
+	assert.NoError(t, err)
+
+	b1 := mineOn(fork, address, "b1")
+	assert.NoError(t, bc.ProcessBlock(b1))
+	assert.NoError(t, bc.ProcessBlock(mineOn(b1, address, "b2")))
+	assert.Equal(t, b1.Hash, bc.nodes[1].hash)
+	assert.Equal(t, 0, bc.UTXOSet().Balance(HashPubKey(to.PublicKey)))
+	assert.True(t, bc.FindUTXOSet().Equal(bc.UTXOSet()))
+}
@@ -0,0 +1,285 @@
+package base
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Limits of the block timestamp
+const (
+	// medianTimeBlocks is the number of previous blocks whose median
+	// timestamp a new block must not precede
+	medianTimeBlocks = 11
+	// maxFutureBlockTime is how far in the future, in seconds,
+	// a block timestamp may be
+	maxFutureBlockTime = 2 * 60 * 60
+)
+
+// ValidationRule names a rule of block validation
+type ValidationRule string
+
+// Rules checked by Blockchain.ValidateBlock
+const (
+	RuleNoTransactions ValidationRule = "no-transactions"
+	RuleCoinbase       ValidationRule = "coinbase"
+	RuleTransactionID  ValidationRule = "transaction-id"
+	RuleBlockSize      ValidationRule = "block-size"
+	RuleAlgorithm      ValidationRule = "algorithm"
+	RuleProofOfWork    ValidationRule = "proof-of-work"
+	RulePrevBlock      ValidationRule = "prev-block"
+	RuleDifficulty     ValidationRule = "difficulty"
+	RuleTimestamp      ValidationRule = "timestamp"
+	RuleMissingInput   ValidationRule = "missing-input"
+	RuleDoubleSpend    ValidationRule = "double-spend"
+	RuleDuplicateTX    ValidationRule = "duplicate-transaction"
+	RuleImmatureSpend  ValidationRule = "immature-spend"
+	RuleLockTime       ValidationRule = "lock-time"
+	RuleSequenceLock   ValidationRule = "sequence-lock"
+	RuleSignature      ValidationRule = "signature"
+	RuleValue          ValidationRule = "value"
+)
+
+// ValidationError explains which rule an invalid block breaks
+type ValidationError struct {
+	Rule   ValidationRule
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid block: %s: %s", e.Rule, e.Reason)
+}
+
+func invalidBlock(rule ValidationRule, format string, args ...interface{}) error {
+	return &ValidationError{Rule: rule, Reason: fmt.Sprintf(format, args...)}
+}
+
+// ValidateBlock validates the a block after mining or
+// before adding it to the blockchain.
+// The transactions are checked against the UTXO set only when the block
+// extends the best chain, blocks of side branches being checked when the
+// chain switches to their branch.
+func (bc *Blockchain) ValidateBlock(block *Block) error {
+	if err := bc.checkBlock(block); err != nil {
+		return err
+	}
+
+	parent, ok := bc.index[hex.EncodeToString(block.PrevBlockHash)]
+	if !ok {
+		return invalidBlock(RulePrevBlock, "unknown previous block %x", block.PrevBlockHash)
+	}
+	if parent.invalid {
+		return invalidBlock(RulePrevBlock, "previous block %x is invalid", block.PrevBlockHash)
+	}
+	if bits := bc.nextTargetBits(parent); block.TargetBits != bits {
+		return invalidBlock(RuleDifficulty, "target bits %d, expected %d", block.TargetBits, bits)
+	}
+	if median := medianTimePast(parent); block.Timestamp < median {
+		return invalidBlock(RuleTimestamp, "timestamp %d before median time %d", block.Timestamp, median)
+	}
+	if limit := time.Now().Unix() + maxFutureBlockTime; block.Timestamp > limit {
+		return invalidBlock(RuleTimestamp, "timestamp %d too far in the future", block.Timestamp)
+	}
+
+	if parent == bc.nodes[len(bc.nodes)-1] {
+		return bc.checkTransactions(block, parent.height+1, bc.utxos.Set())
+	}
+	return nil
+}
+
+// checkBlock checks the rules that do not depend on the chain
+func (bc *Blockchain) checkBlock(block *Block) error {
+	if len(block.Transactions) == 0 {
+		return invalidBlock(RuleNoTransactions, "block has no transactions")
+	}
+	if !block.Transactions[0].IsCoinbase() {
+		return invalidBlock(RuleCoinbase, "first transaction is not a coinbase")
+	}
+	for i, tx := range block.Transactions {
+		if i > 0 && tx.IsCoinbase() {
+			return invalidBlock(RuleCoinbase, "transaction %d is a coinbase", i)
+		}
+		if hash := tx.Hash(); hex.EncodeToString(hash) != hex.EncodeToString(tx.ID) {
+			return invalidBlock(RuleTransactionID, "transaction %x has hash %x", tx.ID, hash)
+		}
+	}
+
+	if size := block.Size(); size > bc.params.MaxBlockSize {
+		return invalidBlock(RuleBlockSize, "%d bytes, limit is %d", size, bc.params.MaxBlockSize)
+	}
+	// The proof of work of a difficulty out of range cannot be checked
+	if !validTargetBits(block.TargetBits) {
+		return invalidBlock(RuleDifficulty, "target bits %d outside [%d, %d]", block.TargetBits, MinTargetBits, MaxTargetBits)
+	}
+	if block.Algorithm != bc.params.PoWAlgorithm {
+		return invalidBlock(RuleAlgorithm, "algorithm %q, expected %q", block.Algorithm, bc.params.PoWAlgorithm)
+	}
+	if !NewProofOfWork(block).Validate() {
+		return invalidBlock(RuleProofOfWork, "hash %x does not match the header or the target", block.Hash)
+	}
+	return nil
+}
+
+// checkTransactions checks the transactions of a block against the unspent
+// outputs of its parent, their locks against the height and the timestamp
+// of the block, and that the coinbase claims no more than the subsidy at
+// the height of the block and the fees of the block
+func (bc *Blockchain) checkTransactions(block *Block, height int, utxos UTXOSet) error {
+	// A transaction must not overwrite the unspent outputs
+	// of an earlier transaction with the same ID
+	for _, tx := range block.Transactions {
+		for i := range tx.Vout {
+			op := NewOutpoint(tx.ID, i)
+			if _, ok := utxos[op]; ok {
+				return invalidBlock(RuleDuplicateTX, "output %s already exists", op)
+			}
+		}
+	}
+
+	fees, err := bc.checkSpends(block.Transactions[1:], height, utxos)
+	if err != nil {
+		return err
+	}
+	created := make(map[string]*Transaction)
+	for _, tx := range block.Transactions[1:] {
+		if err := bc.checkLocks(tx, height, block.Timestamp, utxos, created); err != nil {
+			return err
+		}
+		created[hex.EncodeToString(tx.ID)] = tx
+	}
+
+	claimed := 0
+	for _, output := range block.Transactions[0].Vout {
+		if output.Value < 0 || output.Value > MaxMoney {
+			return invalidBlock(RuleValue, "coinbase has an output of %d", output.Value)
+		}
+		var ok bool
+		if claimed, ok = addMoney(claimed, output.Value); !ok {
+			return invalidBlock(RuleValue, "coinbase outputs exceed %d", MaxMoney)
+		}
+	}
+	if allowed := bc.params.Subsidy(height) + fees; claimed > allowed {
+		return invalidBlock(RuleCoinbase, "coinbase claims %d, allowed %d", claimed, allowed)
+	}
+	return nil
+}
+
+// BlockFees returns the fees of transactions spending the unspent outputs
+// of the best chain, or of the transactions preceding them, as in a block
+// extending the best chain.
+// A coinbase transaction may claim them in addition to the subsidy.
+func (bc *Blockchain) BlockFees(txs []*Transaction) (int, error) {
+	var spends []*Transaction
+	for _, tx := range txs {
+		if !tx.IsCoinbase() {
+			spends = append(spends, tx)
+		}
+	}
+	return bc.checkSpends(spends, len(bc.nodes), bc.utxos.Set())
+}
+
+// checkSpends checks non-coinbase transactions of a block at the given
+// height against the unspent outputs, and the outputs created by the
+// transactions that precede them, and returns the sum of their fees
+func (bc *Blockchain) checkSpends(txs []*Transaction, height int, utxos UTXOSet) (int, error) {
+	fees := 0
+	created := make(map[string]*Transaction)
+	spent := make(map[Outpoint]bool)
+	for _, tx := range txs {
+		fee, err := bc.checkSpend(tx, height, utxos, created, spent)
+		if err != nil {
+			return 0, err
+		}
+		var ok bool
+		if fees, ok = addMoney(fees, fee); !ok {
+			return 0, invalidBlock(RuleValue, "fees exceed %d", MaxMoney)
+		}
+		created[hex.EncodeToString(tx.ID)] = tx
+	}
+	return fees, nil
+}
+
+// checkSpend checks a non-coinbase transaction of a block at the given
+// height spending unspent outputs, or outputs of the created transactions,
+// marks its inputs as spent and returns its fee
+func (bc *Blockchain) checkSpend(tx *Transaction, height int, utxos UTXOSet, created map[string]*Transaction, spent map[Outpoint]bool) (int, error) {
+	prevTXs := make(map[string]*Transaction)
+	inputs := 0
+	var ok bool
+	for _, input := range tx.Vin {
+		key := hex.EncodeToString(input.Txid)
+		op := NewOutpoint(input.Txid, input.OutIdx)
+		if spent[op] {
+			return 0, invalidBlock(RuleDoubleSpend, "output %s spent twice", op)
+		}
+		spent[op] = true
+
+		if prevTX, ok := created[key]; ok {
+			if input.OutIdx < 0 || input.OutIdx >= len(prevTX.Vout) {
+				return 0, invalidBlock(RuleMissingInput, "output %s does not exist", op)
+			}
+			if inputs, ok = addMoney(inputs, prevTX.Vout[input.OutIdx].Value); !ok {
+				return 0, invalidBlock(RuleValue, "inputs of transaction %x exceed %d", tx.ID, MaxMoney)
+			}
+			prevTXs[key] = prevTX
+			continue
+		}
+
+		output, ok := utxos[op]
+		if !ok {
+			return 0, invalidBlock(RuleMissingInput, "output %s is spent or does not exist", op)
+		}
+		if !output.IsMature(height, bc.params.CoinbaseMaturity) {
+			return 0, invalidBlock(RuleImmatureSpend, "coinbase output %s of height %d spent at height %d", op, output.Height, height)
+		}
+		if inputs, ok = addMoney(inputs, output.Value); !ok {
+			return 0, invalidBlock(RuleValue, "inputs of transaction %x exceed %d", tx.ID, MaxMoney)
+		}
+		addSpentOutput(prevTXs, input, output.TXOutput)
+	}
+
+	outputs := 0
+	for _, output := range tx.Vout {
+		if output.Value < 0 || output.Value > MaxMoney {
+			return 0, invalidBlock(RuleValue, "transaction %x has an output of %d", tx.ID, output.Value)
+		}
+		if outputs, ok = addMoney(outputs, output.Value); !ok {
+			return 0, invalidBlock(RuleValue, "outputs of transaction %x exceed %d", tx.ID, MaxMoney)
+		}
+	}
+	if inputs < outputs {
+		return 0, invalidBlock(RuleValue, "transaction %x spends %d but has only %d", tx.ID, outputs, inputs)
+	}
+	if err := tx.VerifyInputs(prevTXs); err != nil {
+		return 0, invalidBlock(RuleSignature, "transaction %x: %v", tx.ID, err)
+	}
+	return inputs - outputs, nil
+}
+
+// addSpentOutput adds the output spent by an input to a partial copy of
+// its transaction in prevTXs. VerifyInputs reads nothing else of the
+// previous transactions, so they need not be looked up in the chain.
+func addSpentOutput(prevTXs map[string]*Transaction, input TXInput, output TXOutput) {
+	key := hex.EncodeToString(input.Txid)
+	prevTX, ok := prevTXs[key]
+	if !ok {
+		prevTX = &Transaction{ID: input.Txid}
+		prevTXs[key] = prevTX
+	}
+	for len(prevTX.Vout) <= input.OutIdx {
+		prevTX.Vout = append(prevTX.Vout, TXOutput{})
+	}
+	prevTX.Vout[input.OutIdx] = output
+}
+
+// medianTimePast returns the median timestamp of the node
+// and the blocks preceding it
+func medianTimePast(node *blockNode) int64 {
+	var timestamps []int64
+	for ; node != nil && len(timestamps) < medianTimeBlocks; node = node.parent {
+		timestamps = append(timestamps, node.timestamp)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2]
+}
@@ -0,0 +1,231 @@
+package base
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func assertRule(t *testing.T, rule ValidationRule, err error) {
+	if assert.IsType(t, &ValidationError{}, err) {
+		assert.Equal(t, rule, err.(*ValidationError).Rule)
+	}
+}
+
+func TestValidateBlockAcceptsTransfers(t *testing.T) {
+	from, to := NewWallet(), NewWallet()
+	bc := CreateBlockchainWithParams(from.GetStringAddress(), testChainParams())
+
+	tx, err := NewUTXOTransaction(from, to.GetStringAddress(), 4, bc.UTXOSet(), bc)
+	assert.NoError(t, err)
+	coinbase := NewCoinbaseTX(from.GetStringAddress(), "")
+	_, err = bc.AddBlock([]*Transaction{coinbase, tx})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 4, sumOutputs(bc.UTXOSet().FindUTXO(HashPubKey(to.PublicKey))))
+}
+
+func TestValidateBlockVerifiesInputsFromUTXOSet(t *testing.T) {
+	from, to := NewWallet(), NewWallet()
+	bc := CreateBlockchainWithParams(from.GetStringAddress(), testChainParams())
+	tx, err := NewUTXOTransaction(from, to.GetStringAddress(), 4, bc.UTXOSet(), bc)
+	assert.NoError(t, err)
+	_, err = bc.AddBlock([]*Transaction{NewCoinbaseTX(from.GetStringAddress(), ""), tx})
+	assert.NoError(t, err)
+
+	// The previous transactions are rebuilt from the outputs they spend,
+	// here the change of tx
+	spend, err := NewUTXOTransaction(from, to.GetStringAddress(), 3, bc.UTXOSet(), bc)
+	assert.NoError(t, err)
+	prevTXs := make(map[string]*Transaction)
+	for _, input := range spend.Vin {
+		output, ok := bc.UTXOSet()[NewOutpoint(input.Txid, input.OutIdx)]
+		assert.True(t, ok)
+		addSpentOutput(prevTXs, input, output.TXOutput)
+	}
+	assert.NoError(t, spend.VerifyInputs(prevTXs))
+	_, err = bc.AddBlock([]*Transaction{NewCoinbaseTX(from.GetStringAddress(), ""), spend})
+	assert.NoError(t, err)
+}
+
+func TestValidateBlockRejectsDoubleSpends(t *testing.T) {
+	from, to := NewWallet(), NewWallet()
+	bc := CreateBlockchainWithParams(from.GetStringAddress(), testChainParams())
+
+	tx1, err := NewUTXOTransaction(from, to.GetStringAddress(), 4, bc.UTXOSet(), bc)
+	assert.NoError(t, err)
+	tx2, err := NewUTXOTransaction(from, to.GetStringAddress(), 5, bc.UTXOSet(), bc)
+	assert.NoError(t, err)
+	coinbase := NewCoinbaseTX(from.GetStringAddress(), "")
+	_, err = bc.AddBlock([]*Transaction{coinbase, tx1, tx2})
+	assertRule(t, RuleDoubleSpend, err)
+
+	_, err = bc.AddBlock([]*Transaction{coinbase, tx1})
+	assert.NoError(t, err)
+	coinbase = NewCoinbaseTX(from.GetStringAddress(), "")
+	_, err = bc.AddBlock([]*Transaction{coinbase, tx2})
+	assertRule(t, RuleMissingInput, err)
+}
+
+func TestValidateBlockRejectsExtraCoinbase(t *testing.T) {
+	address := NewWallet().GetStringAddress()
+	bc := CreateBlockchainWithParams(address, testChainParams())
+
+	_, err := bc.AddBlock([]*Transaction{NewCoinbaseTX(address, "1"), NewCoinbaseTX(address, "2")})
+	assertRule(t, RuleCoinbase, err)
+
+	coinbase := NewCoinbaseTX(address, "")
+	_, err = bc.AddBlock([]*Transaction{coinbase})
+	assert.NoError(t, err)
+	_, err = bc.AddBlock([]*Transaction{coinbase})
+	assertRule(t, RuleDuplicateTX, err)
+}
+
+func TestValidateBlockRejectsTamperedTransactions(t *testing.T) {
+	from, to := NewWallet(), NewWallet()
+	bc := CreateBlockchainWithParams(from.GetStringAddress(), testChainParams())
+
+	tx, err := NewUTXOTransaction(from, to.GetStringAddress(), 4, bc.UTXOSet(), bc)
+	assert.NoError(t, err)
+	tx.Vout[0].Value = 10
+	coinbase := NewCoinbaseTX(from.GetStringAddress(), "")
+	_, err = bc.AddBlock([]*Transaction{coinbase, tx})
+	assertRule(t, RuleTransactionID, err)
+
+	tx.ID = tx.Hash()
+	_, err = bc.AddBlock([]*Transaction{coinbase, tx})
+	assertRule(t, RuleValue, err)
+
+	tx.Vout[0].Value = 4
+	tx.ID = tx.Hash()
+	tx.Vin[0].ScriptSig[1] ^= 0xff // the first byte of the signature
+	_, err = bc.AddBlock([]*Transaction{coinbase, tx})
+	assertRule(t, RuleSignature, err)
+}
+
+func TestValidateBlockCollectsFees(t *testing.T) {
+	from, to := NewWallet(), NewWallet()
+	bc := CreateBlockchainWithParams(from.GetStringAddress(), testChainParams())
+
+	tx, err := NewUTXOTransactionWithFee(from, to.GetStringAddress(), 4, 3, bc.UTXOSet(), bc)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, sumOutputs(tx.Vout))
+	fees, err := bc.BlockFees([]*Transaction{tx})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, fees)
+
+	_, err = bc.AddBlock([]*Transaction{NewCoinbaseTXWithReward(from.GetStringAddress(), "", BlockReward+fees+1), tx})
+	assertRule(t, RuleCoinbase, err)
+
+	_, err = bc.AddBlock([]*Transaction{NewCoinbaseTXWithReward(from.GetStringAddress(), "", BlockReward+fees), tx})
+	assert.NoError(t, err)
+	assert.Equal(t, 2*BlockReward, sumOutputs(bc.UTXOSet().FindUTXO(HashPubKey(from.PublicKey)))+4)
+}
+
+func TestValidateBlockRejectsOverflowingValues(t *testing.T) {
+	from := NewWallet()
+	address := from.GetStringAddress()
+	bc := CreateBlockchainWithParams(address, testChainParams())
+	block, err := bc.AddBlock([]*Transaction{NewCoinbaseTX(address, "")})
+	assert.NoError(t, err)
+
+	// Each transaction spends a reward into two outputs whose sum wraps
+	// around to a negative value, and so do the fees of the block
+	half := math.MaxInt64/2 + 1
+	mp := NewMempool(bc)
+	txs := []*Transaction{NewCoinbaseTX(address, "")}
+	for _, reward := range []*Transaction{bc.GetGenesisBlock().Transactions[0], block.Transactions[0]} {
+		tx := &Transaction{
+			Vin:  []TXInput{{Txid: reward.ID, OutIdx: 0}},
+			Vout: []TXOutput{*NewTXOutput(half, address), *NewTXOutput(half, address)},
+		}
+		tx.Sign(from.PrivateKey, map[string]*Transaction{hex.EncodeToString(reward.ID): reward})
+		tx.ID = tx.Hash()
+		assert.True(t, errors.Is(mp.Add(tx), ErrInvalidTransaction))
+		txs = append(txs, tx)
+	}
+	_, err = bc.AddBlock(txs)
+	assertRule(t, RuleValue, err)
+
+	_, err = bc.AddBlock([]*Transaction{NewCoinbaseTXWithReward(address, "", MaxMoney+1)})
+	assertRule(t, RuleValue, err)
+	assert.Equal(t, 1, bc.Height())
+}
+
+func TestNewUTXOTransactionWithFeeRate(t *testing.T) {
+	from, to := NewWallet(), NewWallet()
+	bc := CreateBlockchainWithParams(from.GetStringAddress(), testChainParams())
+
+	_, err := NewUTXOTransactionWithFeeRate(from, to.GetStringAddress(), 4, 1000, bc.UTXOSet(), bc)
+	assert.Error(t, err)
+
+	tx, err := NewUTXOTransactionWithFeeRate(from, to.GetStringAddress(), 4, 10, bc.UTXOSet(), bc)
+	assert.NoError(t, err)
+	fees, err := bc.BlockFees([]*Transaction{tx})
+	assert.NoError(t, err)
+	assert.True(t, fees > 0)
+	assert.True(t, fees >= FeeForSize(10, tx.Size()))
+}
+
+func TestValidateBlockRejectsImmatureCoinbaseSpends(t *testing.T) {
+	params := testChainParams()
+	params.CoinbaseMaturity = 2
+	from, to := NewWallet(), NewWallet()
+	bc := CreateBlockchainWithParams(from.GetStringAddress(), params)
+	mp := NewMempool(bc)
+	reward := bc.GetGenesisBlock().Transactions[0]
+
+	_, err := NewUTXOTransaction(from, to.GetStringAddress(), 4, bc.UTXOSet(), bc)
+	assert.Error(t, err)
+	spend := spendPending(from, reward, to.GetStringAddress(), BlockReward)
+	assert.True(t, errors.Is(mp.Add(spend), ErrInvalidTransaction))
+	_, err = bc.AddBlock([]*Transaction{NewCoinbaseTX(from.GetStringAddress(), ""), spend})
+	assertRule(t, RuleImmatureSpend, err)
+
+	_, err = bc.AddBlock([]*Transaction{NewCoinbaseTX(to.GetStringAddress(), "")})
+	assert.NoError(t, err)
+	_, err = NewUTXOTransaction(from, to.GetStringAddress(), 4, bc.UTXOSet(), bc)
+	assert.NoError(t, err)
+	assert.NoError(t, mp.Add(spend))
+	_, err = bc.MineBlock(mp.BlockTemplate(from.GetStringAddress(), ""))
+	assert.NoError(t, err)
+}
+
+func sumOutputs(outputs []TXOutput) int {
+	sum := 0
+	for _, output := range outputs {
+		sum += output.Value
+	}
+	return sum
+}
+
+func TestValidateBlockRejectsTargetBitsOutOfRange(t *testing.T) {
+	address := NewWallet().GetStringAddress()
+	bc := CreateBlockchainWithParams(address, testChainParams())
+	tip := bc.CurrentBlock()
+
+	for _, bits := range []int{MinTargetBits - 1, MaxTargetBits + 1, 300, -5} {
+		block := &Block{
+			Timestamp:     tip.Timestamp,
+			Transactions:  []*Transaction{NewCoinbaseTX(address, "")},
+			PrevBlockHash: tip.Hash,
+			TargetBits:    bits,
+			Algorithm:     tip.Algorithm,
+			Hash:          []byte("fresh hash"),
+		}
+		assertRule(t, RuleDifficulty, bc.ProcessBlock(block))
+
+		// A received block goes through the same checks
+		received, err := DeserializeBlock(block.Serialize())
+		assert.NoError(t, err)
+		assertRule(t, RuleDifficulty, bc.ProcessBlock(received))
+
+		_, err = Miner{}.Mine(context.Background(), block)
+		assert.Equal(t, ErrInvalidTargetBits, err, bits)
+		assert.False(t, NewProofOfWork(block).Validate())
+	}
+}
@@ -8,11 +8,16 @@ import (
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
+	"fmt"
 
 	"golang.org/x/crypto/ripemd160"
 )
 
 const version = byte(0x00)
+
+// scriptVersion is the version of the addresses of redeem scripts
+const scriptVersion = byte(0x05)
 const addressChecksumLen = 4
 
 // Wallet stores private and public keys
@@ -30,9 +35,9 @@ func NewWallet() *Wallet {
 // CreateWallet initialize a wallet from the given keys
 func CreateWallet(privKey *ecdsa.PrivateKey, pubKey *ecdsa.PublicKey) *Wallet {
 	// Create a wallet with the given keys, note that the PublicKey field in the
-	// Wallet struct is a byte array (the concatenation of the X and Y
-	// coordinates of the ecdsa.PublicKey) this is done to be easy to hash it
-	// in future operations
+	// Wallet struct is a byte array (the compressed SEC1 encoding of the
+	// ecdsa.PublicKey) this is done to be easy to hash it in future
+	// operations
 	return &Wallet{PrivateKey: *privKey, PublicKey: pubKeyToByte(*pubKey)}
 }
 
@@ -41,12 +46,33 @@ func CreateWallet(privKey *ecdsa.PrivateKey, pubKey *ecdsa.PublicKey) *Wallet {
 func (w Wallet) GetAddress() []byte {
 	// Create a address following the logic described in the link above and
 	// in the lab documentation
-	pubHash := HashPubKey(w.PublicKey)
-	versionedPayload := append([]byte{version}, pubHash...)
+	return encodeAddress(version, HashPubKey(w.PublicKey))
+}
+
+// encodeAddress encodes the versioned hash with its checksum
+func encodeAddress(version byte, hash []byte) []byte {
+	versionedPayload := append([]byte{version}, hash...)
 	checksum := checksum(versionedPayload)
-	encoded := Base58Encode(append(versionedPayload, checksum...))
+	return Base58Encode(append(versionedPayload, checksum...))
+}
+
+// ScriptAddress returns the pay-to-script-hash address of a redeem script
+func ScriptAddress(redeemScript Script) string {
+	return string(encodeAddress(scriptVersion, HashPubKey(redeemScript)))
+}
 
-	return encoded
+// NewMultisigAddress returns the pay-to-script-hash address requiring
+// m signatures of the wallets, and its redeem script
+func NewMultisigAddress(m int, wallets ...*Wallet) (string, Script, error) {
+	var pubKeys [][]byte
+	for _, w := range wallets {
+		pubKeys = append(pubKeys, w.PublicKey)
+	}
+	redeemScript, err := MultisigScript(m, pubKeys)
+	if err != nil {
+		return "", nil, err
+	}
+	return ScriptAddress(redeemScript), redeemScript, nil
 }
 
 // GetStringAddress returns wallet address as string
@@ -66,7 +92,8 @@ func HashPubKey(pubKey []byte) []byte {
 	return rip.Sum(nil)
 }
 
-// GetPubKeyHashFromAddress returns the hash of the public key
+// GetPubKeyHashFromAddress returns the hash of the public key,
+// or of the redeem script of a script address,
 // discarding the version and the checksum
 func GetPubKeyHashFromAddress(address string) []byte {
 	// Decode the address using Base58Decode and extract the hash of the pubkey
@@ -113,9 +140,8 @@ func newKeyPair() (ecdsa.PrivateKey, []byte) {
 
 func pubKeyToByte(pubkey ecdsa.PublicKey) []byte {
 	// step 1 of: https://en.bitcoin.it/wiki/Technical_background_of_version_1_Bitcoin_addresses#How_to_create_Bitcoin_Address
-	x := pubkey.X.Bytes()
-	y := pubkey.Y.Bytes()
-	return append(x, y...)
+	// The addresses are derived from the compressed key
+	return SerializePubKey(pubkey)
 }
 
 func encodeKeyPair(privateKey *ecdsa.PrivateKey, publicKey *ecdsa.PublicKey) (string, string) {
@@ -136,21 +162,49 @@ func encodePublicKey(publicKey *ecdsa.PublicKey) string {
 	return string(pemEncodedPub)
 }
 
-func decodeKeyPair(pemEncoded string, pemEncodedPub string) (*ecdsa.PrivateKey, *ecdsa.PublicKey) {
-	return decodePrivateKey(pemEncoded), decodePublicKey(pemEncodedPub)
+func decodeKeyPair(pemEncoded string, pemEncodedPub string) (*ecdsa.PrivateKey, *ecdsa.PublicKey, error) {
+	privateKey, err := decodePrivateKey(pemEncoded)
+	if err != nil {
+		return nil, nil, err
+	}
+	publicKey, err := decodePublicKey(pemEncodedPub)
+	if err != nil {
+		return nil, nil, err
+	}
+	return privateKey, publicKey, nil
 }
 
-func decodePrivateKey(pemEncoded string) *ecdsa.PrivateKey {
-	block, _ := pem.Decode([]byte(pemEncoded))
-	privateKey, _ := x509.ParseECPrivateKey(block.Bytes)
+// ErrInvalidPEMKey is returned when decoding a key that is not
+// a PEM encoded key of the curve of the wallets
+var ErrInvalidPEMKey = errors.New("invalid PEM key")
 
-	return privateKey
+func decodePrivateKey(pemEncoded string) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(pemEncoded))
+	if block == nil {
+		return nil, fmt.Errorf("%w: no PEM block", ErrInvalidPEMKey)
+	}
+	privateKey, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPEMKey, err)
+	}
+	if privateKey.Curve != curve {
+		return nil, fmt.Errorf("%w: curve %s", ErrInvalidPEMKey, privateKey.Curve.Params().Name)
+	}
+	return privateKey, nil
 }
 
-func decodePublicKey(pemEncodedPub string) *ecdsa.PublicKey {
+func decodePublicKey(pemEncodedPub string) (*ecdsa.PublicKey, error) {
 	blockPub, _ := pem.Decode([]byte(pemEncodedPub))
-	genericPubKey, _ := x509.ParsePKIXPublicKey(blockPub.Bytes)
-	publicKey := genericPubKey.(*ecdsa.PublicKey) // cast to ecdsa
-
-	return publicKey
+	if blockPub == nil {
+		return nil, fmt.Errorf("%w: no PEM block", ErrInvalidPEMKey)
+	}
+	genericPubKey, err := x509.ParsePKIXPublicKey(blockPub.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPEMKey, err)
+	}
+	publicKey, ok := genericPubKey.(*ecdsa.PublicKey) // cast to ecdsa
+	if !ok || publicKey.Curve != curve {
+		return nil, fmt.Errorf("%w: not a key of the curve", ErrInvalidPEMKey)
+	}
+	return publicKey, nil
 }
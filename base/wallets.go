@@ -0,0 +1,263 @@
+package base
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sort"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// DefaultWalletsFile is the file of the wallets of the master and the CLI
+const DefaultWalletsFile = "wallets.dat"
+
+// WalletPassphraseEnv is the environment variable holding
+// the passphrase of the wallets file
+const WalletPassphraseEnv = "DAT650_WALLET_PASSPHRASE"
+
+// Errors returned by the wallets store
+var (
+	ErrWrongPassphrase    = errors.New("wrong passphrase or corrupted wallets file")
+	ErrInvalidWalletsFile = errors.New("invalid wallets file")
+	ErrUnknownWallet      = errors.New("unknown wallet")
+	ErrWalletExists       = errors.New("wallet already exists")
+)
+
+// The wallets file starts with a header of walletsMagic, the version,
+// the scrypt parameters and salt deriving the key from the passphrase,
+// and the AES-GCM nonce. The header authenticates the encrypted list
+// of wallets that follows, each encoded as its name and private key.
+var walletsMagic = []byte("DATW")
+
+const (
+	walletsFileVersion = 1
+	walletsSaltLen     = 16
+	walletsKeyLen      = 32
+	walletsHeaderLen   = 4 + 1 + 3 + walletsSaltLen
+)
+
+// Cost parameters of the scrypt key derivation of new wallets files,
+// a variable so that the tests can lower it
+var walletsScryptLogN = 15
+
+const (
+	walletsScryptR = 8
+	walletsScryptP = 1
+)
+
+// Wallets stores named wallets in a file encrypted with a passphrase.
+// Every change is written to the file.
+type Wallets struct {
+	path    string
+	header  []byte // the header of the file without the nonce
+	aead    cipher.AEAD
+	wallets map[string]*Wallet
+}
+
+// CreateWallets creates an empty wallets file encrypted with passphrase
+func CreateWallets(path, passphrase string) (*Wallets, error) {
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("%s: %w", path, os.ErrExist)
+	}
+	salt := make([]byte, walletsSaltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	header := append([]byte{}, walletsMagic...)
+	header = append(header, walletsFileVersion, byte(walletsScryptLogN), walletsScryptR, walletsScryptP)
+	header = append(header, salt...)
+
+	ws, err := newWallets(path, header, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if err := ws.save(); err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+// OpenWallets decrypts the wallets file with passphrase
+func OpenWallets(path, passphrase string) (*Wallets, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < walletsHeaderLen || !bytes.Equal(data[:len(walletsMagic)], walletsMagic) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidWalletsFile, path)
+	}
+	if version := data[len(walletsMagic)]; version != walletsFileVersion {
+		return nil, fmt.Errorf("%w: version %d", ErrInvalidWalletsFile, version)
+	}
+	ws, err := newWallets(path, data[:walletsHeaderLen], passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := ws.aead.NonceSize()
+	if len(data) < walletsHeaderLen+nonceSize {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidWalletsFile, path)
+	}
+	nonce := data[walletsHeaderLen : walletsHeaderLen+nonceSize]
+	plaintext, err := ws.aead.Open(nil, nonce, data[walletsHeaderLen+nonceSize:], ws.header)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	if err := ws.decode(plaintext); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWalletsFile, err)
+	}
+	return ws, nil
+}
+
+// newWallets derives the key of a wallets file of the given header
+func newWallets(path string, header []byte, passphrase string) (*Wallets, error) {
+	params := header[len(walletsMagic)+1:]
+	logN, r, p := int(params[0]), int(params[1]), int(params[2])
+	if logN < 1 || logN > 30 {
+		return nil, fmt.Errorf("%w: scrypt cost 2^%d", ErrInvalidWalletsFile, logN)
+	}
+	salt := header[walletsHeaderLen-walletsSaltLen:]
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<logN, r, p, walletsKeyLen)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWalletsFile, err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Wallets{
+		path:    path,
+		header:  append([]byte{}, header...),
+		aead:    aead,
+		wallets: make(map[string]*Wallet),
+	}, nil
+}
+
+// Names returns the names of the wallets in order
+func (ws *Wallets) Names() []string {
+	names := make([]string, 0, len(ws.wallets))
+	for name := range ws.wallets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns the wallet of the given name
+func (ws *Wallets) Get(name string) (*Wallet, error) {
+	wallet, ok := ws.wallets[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownWallet, name)
+	}
+	return wallet, nil
+}
+
+// Create generates a new wallet of the given name
+func (ws *Wallets) Create(name string) (*Wallet, error) {
+	return ws.add(name, NewWallet())
+}
+
+// Import adds the wallet of a PEM encoded private key
+func (ws *Wallets) Import(name, pemKey string) (*Wallet, error) {
+	privKey, err := decodePrivateKey(pemKey)
+	if err != nil {
+		return nil, err
+	}
+	return ws.add(name, CreateWallet(privKey, &privKey.PublicKey))
+}
+
+// Export returns the PEM encoded private key of the wallet
+func (ws *Wallets) Export(name string) (string, error) {
+	wallet, err := ws.Get(name)
+	if err != nil {
+		return "", err
+	}
+	return encodePrivateKey(&wallet.PrivateKey), nil
+}
+
+func (ws *Wallets) add(name string, wallet *Wallet) (*Wallet, error) {
+	if name == "" {
+		return nil, errors.New("empty wallet name")
+	}
+	if _, ok := ws.wallets[name]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrWalletExists, name)
+	}
+	ws.wallets[name] = wallet
+	if err := ws.save(); err != nil {
+		delete(ws.wallets, name)
+		return nil, err
+	}
+	return wallet, nil
+}
+
+// save encrypts the wallets with a new nonce and replaces the file
+func (ws *Wallets) save() error {
+	nonce := make([]byte, ws.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	data := append(append([]byte{}, ws.header...), nonce...)
+	data = ws.aead.Seal(data, nonce, ws.encode(), ws.header)
+
+	tmp := ws.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ws.path)
+}
+
+func (ws *Wallets) encode() []byte {
+	e := &encoder{}
+	names := ws.Names()
+	e.uvarint(uint64(len(names)))
+	for _, name := range names {
+		key := make([]byte, walletsKeyLen)
+		ws.wallets[name].PrivateKey.D.FillBytes(key)
+		e.bytes([]byte(name))
+		e.bytes(key)
+	}
+	return e.buf
+}
+
+func (ws *Wallets) decode(data []byte) error {
+	d := &decoder{data: data}
+	n := d.count(2)
+	for i := 0; i < n && d.err == nil; i++ {
+		name := string(d.bytes())
+		key := d.bytes()
+		if d.err != nil {
+			break
+		}
+		privKey, err := privateKeyFromScalar(key)
+		if err != nil {
+			return fmt.Errorf("wallet %s: %w", name, err)
+		}
+		ws.wallets[name] = CreateWallet(privKey, &privKey.PublicKey)
+	}
+	return d.finish()
+}
+
+// privateKeyFromScalar returns the private key of the given scalar
+func privateKeyFromScalar(scalar []byte) (*ecdsa.PrivateKey, error) {
+	d := new(big.Int).SetBytes(scalar)
+	if d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+		return nil, errors.New("private key out of range")
+	}
+	privKey := &ecdsa.PrivateKey{D: d}
+	privKey.PublicKey.Curve = curve
+	privKey.PublicKey.X, privKey.PublicKey.Y = curve.ScalarBaseMult(scalar)
+	return privKey, nil
+}
@@ -0,0 +1,92 @@
+package base
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// walletsPath returns the path of a wallets file in a new directory,
+// with a low scrypt cost for the duration of the test
+func walletsPath(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "wallets")
+	assert.NoError(t, err)
+	logN := walletsScryptLogN
+	walletsScryptLogN = 4
+	t.Cleanup(func() {
+		walletsScryptLogN = logN
+		os.RemoveAll(dir)
+	})
+	return filepath.Join(dir, DefaultWalletsFile)
+}
+
+func TestWalletsPersistAcrossOpens(t *testing.T) {
+	path := walletsPath(t)
+	ws, err := CreateWallets(path, "secret")
+	assert.NoError(t, err)
+	_, err = CreateWallets(path, "secret")
+	assert.True(t, errors.Is(err, os.ErrExist))
+
+	a, err := ws.Create("a")
+	assert.NoError(t, err)
+	_, err = ws.Create("a")
+	assert.True(t, errors.Is(err, ErrWalletExists))
+	imported := NewWallet()
+	c, err := ws.Import("c", encodePrivateKey(&imported.PrivateKey))
+	assert.NoError(t, err)
+	assert.Equal(t, imported.GetStringAddress(), c.GetStringAddress())
+	_, err = ws.Create("b")
+	assert.NoError(t, err)
+	_, err = ws.Import("d", "not a key")
+	assert.True(t, errors.Is(err, ErrInvalidPEMKey))
+
+	ws, err = OpenWallets(path, "secret")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, ws.Names())
+	reopened, err := ws.Get("a")
+	assert.NoError(t, err)
+	assert.Equal(t, a.GetStringAddress(), reopened.GetStringAddress())
+	assert.Equal(t, a.PublicKey, reopened.PublicKey)
+	_, err = ws.Get("d")
+	assert.True(t, errors.Is(err, ErrUnknownWallet))
+
+	// An exported key imports as the same wallet
+	exported, err := ws.Export("c")
+	assert.NoError(t, err)
+	copied, err := ws.Import("copy of c", exported)
+	assert.NoError(t, err)
+	assert.Equal(t, c.GetStringAddress(), copied.GetStringAddress())
+}
+
+func TestWalletsFileIsEncrypted(t *testing.T) {
+	path := walletsPath(t)
+	ws, err := CreateWallets(path, "secret")
+	assert.NoError(t, err)
+	wallet, err := ws.Create("miner")
+	assert.NoError(t, err)
+
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.False(t, bytes.Contains(data, []byte("miner")))
+	assert.False(t, bytes.Contains(data, wallet.PrivateKey.D.Bytes()))
+
+	_, err = OpenWallets(path, "wrong")
+	assert.Equal(t, ErrWrongPassphrase, err)
+
+	// The header and the encrypted wallets are authenticated
+	for _, i := range []int{walletsHeaderLen - 1, len(data) - 1} {
+		tampered := append([]byte{}, data...)
+		tampered[i] ^= 1
+		assert.NoError(t, ioutil.WriteFile(path, tampered, 0600))
+		_, err = OpenWallets(path, "secret")
+		assert.Equal(t, ErrWrongPassphrase, err)
+	}
+	assert.NoError(t, ioutil.WriteFile(path, append([]byte("XXXX"), data[4:]...), 0600))
+	_, err = OpenWallets(path, "secret")
+	assert.True(t, errors.Is(err, ErrInvalidWalletsFile))
+}
@@ -3,9 +3,123 @@ package main
 import (
 	"dat650/base"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
 )
 
 func main() {
+	if len(os.Args) >= 2 && os.Args[1] == "wallet" {
+		if err := walletCommand(os.Args[2:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) >= 2 && os.Args[1] == "benchmark" {
+		benchmarkCommand(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) == 3 {
+		var err error
+		var result string
+		switch os.Args[1] {
+		case "reindex":
+			err = base.ReindexChain(os.Args[2])
+			result = "was rebuilt from the chain"
+		case "checkutxos":
+			err = base.CheckChain(os.Args[2])
+			result = "is consistent"
+		default:
+			usage()
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println("UTXO database of", os.Args[2], result)
+		return
+	}
+
 	fmt.Println("Base main")
 	base.MainMethod()
 }
+
+func usage() {
+	fmt.Println("usage: master [reindex|checkutxos <dir>]")
+	fmt.Println("       the difficulty is set by", base.TargetBitsEnv, "and", base.RetargetIntervalEnv)
+	fmt.Println("       master benchmark [hashes]")
+	fmt.Println("       master wallet [create <name>|list|import <name> <pem file>|export <name>]")
+	os.Exit(2)
+}
+
+// benchmarkCommand compares the hash rates of the legacy and the current
+// proof-of-work loops, over base.DefaultBenchmarkHashes nonces by default
+func benchmarkCommand(args []string) {
+	nHashes := base.DefaultBenchmarkHashes
+	switch {
+	case len(args) == 1:
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			usage()
+		}
+		nHashes = n
+	case len(args) > 1:
+		usage()
+	}
+	base.RunBenchmark(nHashes)
+}
+
+// walletCommand manages the wallets file, encrypted with the passphrase
+// of the environment variable base.WalletPassphraseEnv
+func walletCommand(args []string) error {
+	if len(args) == 0 {
+		usage()
+	}
+	passphrase := os.Getenv(base.WalletPassphraseEnv)
+	if passphrase == "" {
+		return fmt.Errorf("%s is not set", base.WalletPassphraseEnv)
+	}
+	ws, err := base.OpenWallets(base.DefaultWalletsFile, passphrase)
+	if os.IsNotExist(err) && args[0] == "create" {
+		ws, err = base.CreateWallets(base.DefaultWalletsFile, passphrase)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case args[0] == "create" && len(args) == 2:
+		wallet, err := ws.Create(args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Println(args[1], wallet.GetStringAddress())
+	case args[0] == "list" && len(args) == 1:
+		for _, name := range ws.Names() {
+			wallet, _ := ws.Get(name)
+			fmt.Println(name, wallet.GetStringAddress())
+		}
+	case args[0] == "import" && len(args) == 3:
+		pemKey, err := ioutil.ReadFile(args[2])
+		if err != nil {
+			return err
+		}
+		wallet, err := ws.Import(args[1], string(pemKey))
+		if err != nil {
+			return err
+		}
+		fmt.Println(args[1], wallet.GetStringAddress())
+	case args[0] == "export" && len(args) == 2:
+		pemKey, err := ws.Export(args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Print(pemKey)
+	default:
+		usage()
+	}
+	return nil
+}
@@ -1,10 +1,11 @@
 package main
 
 import (
+	"context"
 	"dat650/base"
-	"encoding/json"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 )
 
@@ -22,6 +23,12 @@ var ourID int = 1
 // Eirik
 // var ourID int = 0
 
+// nSlaves is the number of slaves sharing the nonce space
+const nSlaves = 2
+
+// reportInterval is how often the hash rate is sent to the master
+const reportInterval = time.Second
+
 var networkNodes = []int{}
 var addresses = []string{}
 
@@ -55,62 +62,101 @@ func main() {
 
 func handleRequest(connection *net.UDPConn) {
 	fmt.Println("HandleRequest")
-	defer func(connection *net.UDPConn) {
-		if err := recover(); err != nil {
-			fmt.Println("Crashed. Starting new handleRequest routine.")
-			go handleRequest(connection)
-		}
-	}(connection)
-	buffer := make([]byte, 1024)
-	stopChan := make(chan bool)
-	close(stopChan)
+	buffer := make([]byte, base.MaxMessageSize)
+
+	// cancel stops the current pow, done is closed once it has returned
+	cancel := func() {}
+	done := make(chan struct{})
+	close(done)
 	for {
 		n, _, err := connection.ReadFromUDP(buffer)
 		if err != nil {
 			fmt.Println(err.Error())
+			continue
+		}
+		if n < 3 {
+			continue
 		}
 		tag := string(buffer[0:3])
 
 		switch tag {
 		case "STP":
-			_, ok := <-stopChan
-			fmt.Println(ok)
-			if ok {
-				stopChan <- true
-			}
+			cancel()
+			<-done
 		case "POW":
-			// Attempt to stop previous pow
-			ticker := time.NewTicker(50 * time.Millisecond)
-			select {
-			case _, ok := <-stopChan:
-				if ok {
-					stopChan <- true
-				}
-			case <-ticker.C:
-				stopChan <- true
-			}
-			ticker.Stop()
+			// Stop previous pow
+			cancel()
+			<-done
 
-			stopChan = make(chan bool)
-			var block base.Block
-			err = json.Unmarshal(buffer[3:n], &block)
+			block, err := base.DeserializeBlock(buffer[3:n])
 			if err != nil {
 				fmt.Println(err.Error())
+				continue
 			}
 
-			go func(block base.Block) {
-				block.Mine(stopChan, ourID, 2)
-				if block.Nonce != -1 {
-					sendResponse(connection, block)
-				}
-				defer func() {
-					if err := recover(); err != nil {
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+			done = make(chan struct{})
+			go mine(ctx, connection, *block, done)
+		}
+	}
+}
+
+// mine solves the block and sends it back to the master,
+// reporting the hash rate while mining and closing done when it returns
+func mine(ctx context.Context, connection *net.UDPConn, block base.Block, done chan struct{}) {
+	defer close(done)
 
-					}
-				}()
-				close(stopChan)
-			}(block)
+	tracker := &hashRateTracker{progress: make(map[int]base.MiningProgress)}
+	miner := base.Miner{ID: ourID, NSlaves: nSlaves, Progress: tracker.update, ProgressInterval: reportInterval}
+	stop := make(chan struct{})
+	go reportHashRate(connection, tracker, stop)
 
+	stats, err := miner.Mine(ctx, &block)
+	close(stop)
+	sendHashRate(connection, base.HashRateReport{ID: ourID, Hashes: stats.Hashes, HashRate: stats.HashRate()})
+	if err != nil {
+		if err != base.ErrMiningCancelled {
+			fmt.Println(err.Error())
+		}
+		return
+	}
+	sendResponse(connection, block)
+}
+
+// hashRateTracker keeps the last progress of every mining goroutine
+type hashRateTracker struct {
+	mu       sync.Mutex
+	progress map[int]base.MiningProgress
+}
+
+func (t *hashRateTracker) update(p base.MiningProgress) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.progress[p.Worker] = p
+}
+
+func (t *hashRateTracker) report() base.HashRateReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	report := base.HashRateReport{ID: ourID}
+	for _, p := range t.progress {
+		report.Hashes += p.Hashes
+		report.HashRate += p.HashRate
+	}
+	return report
+}
+
+// reportHashRate sends the hash rate to the master until stop is closed
+func reportHashRate(connection *net.UDPConn, tracker *hashRateTracker, stop chan struct{}) {
+	ticker := time.NewTicker(reportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			sendHashRate(connection, tracker.report())
 		}
 	}
 }
@@ -123,3 +169,12 @@ func sendResponse(connection *net.UDPConn, block base.Block) {
 	// addr, _ := net.ResolveUDPAddr("udp4", "192.168.39.135:1234")
 	connection.WriteToUDP(base.MarshalBlock(block), addr)
 }
+
+func sendHashRate(connection *net.UDPConn, report base.HashRateReport) {
+	// Karl
+	addr, _ := net.ResolveUDPAddr("udp4", ":1234")
+
+	// Eirik
+	// addr, _ := net.ResolveUDPAddr("udp4", "192.168.39.135:1234")
+	connection.WriteToUDP(base.MarshalHashRate(report), addr)
+}